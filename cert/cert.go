@@ -0,0 +1,218 @@
+// Package cert generates self-signed certificates for testing the encrypted
+// setup and loads TLS configurations for the gRPC server and client.
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// validity of every generated certificate
+const validFor = 365 * 24 * time.Hour
+
+// KeyPair is a certificate together with its private key
+type KeyPair struct {
+	Certificate *x509.Certificate
+	PrivateKey  *ecdsa.PrivateKey
+	CertPEM     []byte
+	KeyPEM      []byte
+}
+
+// GenerateCA returns a new self-signed certificate authority
+func GenerateCA(commonName string) (*KeyPair, error) {
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	return newKeyPair(template, nil)
+}
+
+// GenerateServer returns a server certificate for the given hosts signed by ca.
+// Hosts may be DNS names or IP addresses.
+func GenerateServer(ca *KeyPair, commonName string, hosts []string) (*KeyPair, error) {
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	return newKeyPair(template, ca)
+}
+
+// GenerateClient returns a client certificate signed by ca
+func GenerateClient(ca *KeyPair, commonName string) (*KeyPair, error) {
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	return newKeyPair(template, ca)
+}
+
+// WriteFiles writes the certificate and private key as PEM files
+func (keyPair *KeyPair) WriteFiles(certFile string, keyFile string) error {
+	err := os.WriteFile(certFile, keyPair.CertPEM, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write certificate file: %w", err)
+	}
+
+	err = os.WriteFile(keyFile, keyPair.KeyPEM, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write key file: %w", err)
+	}
+
+	return nil
+}
+
+// TLSCertificate returns the key pair in the form used by tls.Config
+func (keyPair *KeyPair) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair(keyPair.CertPEM, keyPair.KeyPEM)
+}
+
+// LoadServerTLSConfig loads the server certificate and key. When clientCAFile
+// is set, clients must present a certificate signed by that CA (mutual TLS).
+func LoadServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.NoClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(clientCAFile) > 0 {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// LoadClientTLSConfig loads the CA that signed the server certificate and,
+// for mutual TLS, the client certificate and key
+func LoadClientTLSConfig(rootCAFile string, certFile string, keyFile string) (*tls.Config, error) {
+	pool, err := loadCertPool(rootCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pemCA, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCA) {
+		return nil, fmt.Errorf("cannot add CA certificate from %s", caFile)
+	}
+
+	return pool, nil
+}
+
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{"Laptop App"},
+		},
+		NotBefore: now.Add(-time.Minute),
+		NotAfter:  now.Add(validFor),
+	}, nil
+}
+
+// newKeyPair signs the template with parent, or with itself when parent is nil
+func newKeyPair(template *x509.Certificate, parent *KeyPair) (*KeyPair, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate private key: %w", err)
+	}
+
+	signer := privateKey
+	issuer := template
+	if parent != nil {
+		signer = parent.PrivateKey
+		issuer = parent.Certificate
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &privateKey.PublicKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create certificate: %w", err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal private key: %w", err)
+	}
+
+	return &KeyPair{
+		Certificate: certificate,
+		PrivateKey:  privateKey,
+		CertPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
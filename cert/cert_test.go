@@ -0,0 +1,117 @@
+package cert_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"laptop-app-using-grpc/cert"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	files := generateTestCertificates(t, folder)
+
+	serverConfig, err := cert.LoadServerTLSConfig(files["server-cert"], files["server-key"], files["ca-cert"])
+	require.NoError(t, err)
+	serverAddress := startTestTLSServer(t, credentials.NewTLS(serverConfig))
+
+	// a client with a certificate signed by the CA is accepted
+	clientConfig, err := cert.LoadClientTLSConfig(files["ca-cert"], files["client-cert"], files["client-key"])
+	require.NoError(t, err)
+	err = createTestLaptop(serverAddress, credentials.NewTLS(clientConfig))
+	require.NoError(t, err)
+
+	// a client without a certificate is rejected during the handshake
+	clientConfig, err = cert.LoadClientTLSConfig(files["ca-cert"], "", "")
+	require.NoError(t, err)
+	err = createTestLaptop(serverAddress, credentials.NewTLS(clientConfig))
+	require.Error(t, err)
+}
+
+func TestServerTLS(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	files := generateTestCertificates(t, folder)
+
+	serverConfig, err := cert.LoadServerTLSConfig(files["server-cert"], files["server-key"], "")
+	require.NoError(t, err)
+	serverAddress := startTestTLSServer(t, credentials.NewTLS(serverConfig))
+
+	clientConfig, err := cert.LoadClientTLSConfig(files["ca-cert"], "", "")
+	require.NoError(t, err)
+	err = createTestLaptop(serverAddress, credentials.NewTLS(clientConfig))
+	require.NoError(t, err)
+
+	// a client that trusts another CA refuses the server certificate
+	otherCA, err := cert.GenerateCA("Other CA")
+	require.NoError(t, err)
+	otherCAFile := filepath.Join(folder, "other-ca-cert.pem")
+	require.NoError(t, otherCA.WriteFiles(otherCAFile, filepath.Join(folder, "other-ca-key.pem")))
+
+	clientConfig, err = cert.LoadClientTLSConfig(otherCAFile, "", "")
+	require.NoError(t, err)
+	err = createTestLaptop(serverAddress, credentials.NewTLS(clientConfig))
+	require.Error(t, err)
+}
+
+// generateTestCertificates writes a CA, server and client key pair to folder
+func generateTestCertificates(t *testing.T, folder string) map[string]string {
+	ca, err := cert.GenerateCA("Test CA")
+	require.NoError(t, err)
+
+	server, err := cert.GenerateServer(ca, "test-server", []string{"localhost", "127.0.0.1"})
+	require.NoError(t, err)
+
+	client, err := cert.GenerateClient(ca, "test-client")
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	for name, keyPair := range map[string]*cert.KeyPair{"ca": ca, "server": server, "client": client} {
+		files[name+"-cert"] = filepath.Join(folder, name+"-cert.pem")
+		files[name+"-key"] = filepath.Join(folder, name+"-key.pem")
+		require.NoError(t, keyPair.WriteFiles(files[name+"-cert"], files[name+"-key"]))
+	}
+
+	return files
+}
+
+func startTestTLSServer(t *testing.T, creds credentials.TransportCredentials) string {
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+
+	grpcServer := grpc.NewServer(grpc.Creds(creds))
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	return listener.Addr().String()
+}
+
+func createTestLaptop(serverAddress string, creds credentials.TransportCredentials) error {
+	conn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	return err
+}
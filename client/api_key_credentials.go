@@ -0,0 +1,24 @@
+package client
+
+import "context"
+
+// APIKeyCredentials attaches an API key to every RPC, for machine clients
+// that should not log in with a password
+type APIKeyCredentials struct {
+	key string
+}
+
+// NewAPIKeyCredentials returns per-RPC credentials for the API key
+func NewAPIKeyCredentials(key string) *APIKeyCredentials {
+	return &APIKeyCredentials{key}
+}
+
+// GetRequestMetadata returns the metadata that carries the API key
+func (creds *APIKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-api-key": creds.key}, nil
+}
+
+// RequireTransportSecurity allows sending the key without TLS, for local setups
+func (creds *APIKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
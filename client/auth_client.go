@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"laptop-app-using-grpc/pb/pb"
+	"time"
+)
+
+// AuthClient is a client to call authentication RPC
+type AuthClient struct {
+	service  pb.AuthServiceClient
+	username string
+	password string
+}
+
+// NewAuthClient returns a new auth client
+func NewAuthClient(cc *grpc.ClientConn, username string, password string) *AuthClient {
+	service := pb.NewAuthServiceClient(cc)
+	return &AuthClient{service, username, password}
+}
+
+// Login logs the user in and returns the access token with its expiry time
+func (client *AuthClient) Login() (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.LoginRequest{
+		Username: client.username,
+		Password: client.password,
+	}
+
+	res, err := client.service.Login(ctx, req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return res.GetAccessToken(), res.GetExpiresAt().AsTime(), nil
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"log"
+	"sync"
+	"time"
+)
+
+// how long before expiry the token is refreshed
+const refreshBefore = time.Minute
+
+// retry delay after a failed refresh
+const refreshRetryDelay = 5 * time.Second
+
+// AuthInterceptor is a client interceptor for authentication
+type AuthInterceptor struct {
+	authClient  *AuthClient
+	authMethods map[string]bool
+
+	mutex       sync.RWMutex
+	accessToken string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAuthInterceptor logs in and returns a new auth interceptor that keeps
+// the access token fresh until it is closed. authMethods holds the full
+// names of the methods that need a token.
+func NewAuthInterceptor(authClient *AuthClient, authMethods map[string]bool) (*AuthInterceptor, error) {
+	interceptor := &AuthInterceptor{
+		authClient:  authClient,
+		authMethods: authMethods,
+		done:        make(chan struct{}),
+	}
+
+	expiresAt, err := interceptor.refreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	go interceptor.scheduleRefreshToken(expiresAt)
+
+	return interceptor, nil
+}
+
+// Unary returns a client interceptor to authenticate unary RPC
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if interceptor.authMethods[method] {
+			ctx = interceptor.attachToken(ctx)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream returns a client interceptor to authenticate stream RPC
+func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if interceptor.authMethods[method] {
+			ctx = interceptor.attachToken(ctx)
+		}
+
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+interceptor.accessToken)
+}
+
+// Close stops refreshing the access token, the last one is still attached
+func (interceptor *AuthInterceptor) Close() error {
+	interceptor.closeOnce.Do(func() {
+		close(interceptor.done)
+	})
+
+	return nil
+}
+
+func (interceptor *AuthInterceptor) scheduleRefreshToken(expiresAt time.Time) {
+	for {
+		wait := time.Until(expiresAt) - refreshBefore
+		if wait < 0 {
+			wait = time.Until(expiresAt) / 2
+		}
+		if !interceptor.sleep(wait) {
+			return
+		}
+
+		newExpiresAt, err := interceptor.refreshToken()
+		if err != nil {
+			log.Print("cannot refresh token: ", err)
+			if !interceptor.sleep(refreshRetryDelay) {
+				return
+			}
+			continue
+		}
+
+		expiresAt = newExpiresAt
+	}
+}
+
+// sleep waits for d and reports false when the interceptor is closed first
+func (interceptor *AuthInterceptor) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-interceptor.done:
+		return false
+	}
+}
+
+func (interceptor *AuthInterceptor) refreshToken() (time.Time, error) {
+	accessToken, expiresAt, err := interceptor.authClient.Login()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot log in: %w", err)
+	}
+
+	interceptor.mutex.Lock()
+	interceptor.accessToken = accessToken
+	interceptor.mutex.Unlock()
+
+	log.Printf("token refreshed, expires at %v", expiresAt)
+	return expiresAt, nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strings"
+)
+
+// DescribeError formats a gRPC error with one line for its code and message
+// and one indented line per detail, such as an invalid field or a missing
+// resource. Wrapped status errors are found too, other errors are returned
+// as they are.
+func DescribeError(err error) string {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return err.Error()
+	}
+
+	st := grpcErr.GRPCStatus()
+	lines := []string{fmt.Sprintf("%s: %s", st.Code(), st.Message())}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			line := fmt.Sprintf("  reason: %s (%s)", detail.GetReason(), detail.GetDomain())
+			if metadata := formatMetadata(detail.GetMetadata()); len(metadata) > 0 {
+				line += " " + metadata
+			}
+			lines = append(lines, line)
+		case *errdetails.BadRequest:
+			for _, violation := range detail.GetFieldViolations() {
+				lines = append(lines, fmt.Sprintf("  invalid field %s: %s", violation.GetField(), violation.GetDescription()))
+			}
+		case *errdetails.ResourceInfo:
+			lines = append(lines, fmt.Sprintf("  resource %s %q: %s", detail.GetResourceType(), detail.GetResourceName(), detail.GetDescription()))
+		case *errdetails.RetryInfo:
+			lines = append(lines, fmt.Sprintf("  retry after: %v", detail.GetRetryDelay().AsDuration()))
+		case *errdetails.QuotaFailure:
+			for _, violation := range detail.GetViolations() {
+				lines = append(lines, fmt.Sprintf("  quota exceeded for %s: %s", violation.GetSubject(), violation.GetDescription()))
+			}
+		case error:
+			lines = append(lines, fmt.Sprintf("  unreadable detail: %v", detail))
+		default:
+			lines = append(lines, fmt.Sprintf("  %T: %v", detail, detail))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// formatMetadata formats the metadata as sorted key=value pairs
+func formatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, " ")
+}
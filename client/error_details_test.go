@@ -0,0 +1,45 @@
+package client_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"laptop-app-using-grpc/client"
+	"testing"
+	"time"
+)
+
+func TestDescribeError(t *testing.T) {
+	t.Parallel()
+
+	st, err := status.New(codes.InvalidArgument, "image is too large").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   "IMAGE_TOO_LARGE",
+			Domain:   "laptop-app.vyom1611",
+			Metadata: map[string]string{"size": "11", "max_size": "10"},
+		},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "chunk_data", Description: "the image must not be larger than 10 bytes"},
+		}},
+		&errdetails.ResourceInfo{ResourceType: "vyom1611.laptop_app.Laptop", ResourceName: "abc", Description: "laptop abc"},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)},
+	)
+	require.NoError(t, err)
+
+	expected := `InvalidArgument: image is too large
+  reason: IMAGE_TOO_LARGE (laptop-app.vyom1611) max_size=10 size=11
+  invalid field chunk_data: the image must not be larger than 10 bytes
+  resource vyom1611.laptop_app.Laptop "abc": laptop abc
+  retry after: 1.5s`
+	require.Equal(t, expected, client.DescribeError(st.Err()))
+
+	// wrapped status errors are described the same way
+	require.Equal(t, expected, client.DescribeError(fmt.Errorf("cannot upload image: %w", st.Err())))
+
+	require.Equal(t, "NotFound: no laptop", client.DescribeError(status.Error(codes.NotFound, "no laptop")))
+	require.Equal(t, "plain error", client.DescribeError(errors.New("plain error")))
+}
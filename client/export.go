@@ -0,0 +1,297 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/serializer"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats an exported catalog can be written in
+const (
+	// ExportNDJSON writes one JSON object per line, with the field names and
+	// enums of serializer.ProtobufToJSON
+	ExportNDJSON = string(serializer.NDJSON)
+	// ExportJSONArray writes one JSON array, with an object per line
+	ExportJSONArray = string(serializer.JSONArray)
+	// ExportCSV writes a header and one row per laptop, with a column per
+	// leaf field such as cpu.brand or screen.resolution.width
+	ExportCSV = "csv"
+	// ExportDelimited writes every response as binary protobuf, prefixed
+	// with its size as a varint
+	ExportDelimited = string(serializer.Delimited)
+)
+
+// ExportFormats lists every export format
+var ExportFormats = []string{ExportNDJSON, ExportJSONArray, ExportCSV, ExportDelimited}
+
+// ExportWriter writes exported laptops in one of the export formats
+type ExportWriter interface {
+	Write(res *pb.ExportLaptopsResponse) error
+	// Flush ends the export and writes the buffered laptops to the
+	// underlying writer, which is not closed
+	Flush() error
+}
+
+// NewExportWriter returns a writer for the format, compressed into w. The
+// CSV header depends on whether ratings and images are included by the
+// request.
+func NewExportWriter(w io.Writer, format string, compression serializer.Compression, req *pb.ExportLaptopsRequest) (ExportWriter, error) {
+	switch format {
+	case ExportNDJSON, ExportJSONArray, ExportDelimited:
+		writer, err := serializer.NewMessageWriter(w, serializer.StreamFormat(format), compression)
+		if err != nil {
+			return nil, err
+		}
+		return &messageWriter{writer: writer}, nil
+	case ExportCSV:
+		compressor, err := serializer.NewCompressedWriter(w, compression)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVWriter(compressor, req.GetIncludeRatings(), req.GetIncludeImages())
+	default:
+		return nil, fmt.Errorf("unknown export format %q, expected one of %s", format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// ExportLaptops streams the laptops matching the request into the writer,
+// flushes it and returns the number of laptops written
+func ExportLaptops(ctx context.Context, laptopClient pb.LaptopServiceClient, req *pb.ExportLaptopsRequest, writer ExportWriter) (int, error) {
+	stream, err := laptopClient.ExportLaptops(ctx, req)
+	if err != nil {
+		return 0, fmt.Errorf("cannot export laptops: %w", err)
+	}
+
+	count := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("cannot receive laptop: %w", err)
+		}
+
+		err = writer.Write(res)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, writer.Flush()
+}
+
+type messageWriter struct {
+	writer *serializer.MessageWriter
+}
+
+func (writer *messageWriter) Write(res *pb.ExportLaptopsResponse) error {
+	return writer.writer.Write(res)
+}
+
+func (writer *messageWriter) Flush() error {
+	return writer.writer.Close()
+}
+
+// csvColumn is a leaf field of an export response, found by following path
+// from the response message
+type csvColumn struct {
+	name string
+	path []protoreflect.FieldDescriptor
+}
+
+type csvWriter struct {
+	compressor io.WriteCloser
+	writer     *csv.Writer
+	columns    []csvColumn
+}
+
+// newCSVWriter writes the header of the columns. Laptop fields are named
+// without a prefix, rating fields start with "rating." and the image
+// references are a single JSON column.
+func newCSVWriter(compressor io.WriteCloser, includeRatings bool, includeImages bool) (*csvWriter, error) {
+	fields := (&pb.ExportLaptopsResponse{}).ProtoReflect().Descriptor().Fields()
+
+	var columns []csvColumn
+	columns = append(columns, leafColumns("", fields.ByName("laptop"))...)
+	if includeRatings {
+		columns = append(columns, leafColumns("rating", fields.ByName("rating"))...)
+	}
+	if includeImages {
+		columns = append(columns, leafColumns("images", fields.ByName("images"))...)
+	}
+
+	writer := &csvWriter{compressor: compressor, writer: csv.NewWriter(compressor), columns: columns}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+
+	err := writer.writer.Write(header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write CSV header: %w", err)
+	}
+
+	return writer, nil
+}
+
+// leafColumns flattens a field into one column per leaf field. Repeated
+// fields, maps and timestamps are leaves.
+func leafColumns(name string, field protoreflect.FieldDescriptor, parents ...protoreflect.FieldDescriptor) []csvColumn {
+	path := append(append([]protoreflect.FieldDescriptor{}, parents...), field)
+
+	if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() || isTimestamp(field.Message()) {
+		return []csvColumn{{name: name, path: path}}
+	}
+
+	var columns []csvColumn
+	fields := field.Message().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		child := fields.Get(i)
+		childName := string(child.Name())
+		if len(name) > 0 {
+			childName = name + "." + childName
+		}
+
+		columns = append(columns, leafColumns(childName, child, path...)...)
+	}
+
+	return columns
+}
+
+func (writer *csvWriter) Write(res *pb.ExportLaptopsResponse) error {
+	message := res.ProtoReflect()
+
+	row := make([]string, len(writer.columns))
+	for i, column := range writer.columns {
+		value, err := columnValue(message, column.path)
+		if err != nil {
+			return fmt.Errorf("cannot format column %s: %w", column.name, err)
+		}
+		row[i] = value
+	}
+
+	return writer.writer.Write(row)
+}
+
+func (writer *csvWriter) Flush() error {
+	writer.writer.Flush()
+	err := writer.writer.Error()
+	if err != nil {
+		return err
+	}
+
+	return writer.compressor.Close()
+}
+
+// columnValue formats the leaf field at the end of path. A field inside an
+// unset message, an unset message and a oneof field that is not chosen are
+// empty, other unset fields have their default value.
+func columnValue(message protoreflect.Message, path []protoreflect.FieldDescriptor) (string, error) {
+	for _, field := range path[:len(path)-1] {
+		if !message.Has(field) {
+			return "", nil
+		}
+		message = message.Get(field).Message()
+	}
+
+	field := path[len(path)-1]
+	if field.ContainingOneof() != nil || (field.Kind() == protoreflect.MessageKind && !field.IsList() && !field.IsMap()) {
+		if !message.Has(field) {
+			return "", nil
+		}
+	}
+
+	value := message.Get(field)
+	switch {
+	case field.IsList():
+		list := value.List()
+		values := make([]json.RawMessage, list.Len())
+		for i := range values {
+			element, err := jsonValue(field, list.Get(i))
+			if err != nil {
+				return "", err
+			}
+			values[i] = element
+		}
+
+		data, err := json.Marshal(values)
+		return string(data), err
+	case field.IsMap():
+		values := make(map[string]json.RawMessage)
+		var err error
+		value.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			values[key.String()], err = jsonValue(field.MapValue(), value)
+			return err == nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		data, err := json.Marshal(values)
+		return string(data), err
+	default:
+		return scalarValue(field, value), nil
+	}
+}
+
+// jsonValue formats an element of a repeated field or map as JSON, messages
+// with the settings of serializer.ProtobufToJSON
+func jsonValue(field protoreflect.FieldDescriptor, value protoreflect.Value) (json.RawMessage, error) {
+	if field.Kind() == protoreflect.MessageKind && !isTimestamp(field.Message()) {
+		data, err := serializer.ProtobufToJSONLine(value.Message().Interface())
+		return json.RawMessage(data), err
+	}
+
+	return json.Marshal(scalarValue(field, value))
+}
+
+// scalarValue formats a field that is not repeated, enums by name and
+// timestamps in RFC 3339
+func scalarValue(field protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(value.Bool())
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			return string(enumValue.Name())
+		}
+		return strconv.Itoa(int(value.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(value.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.FormatUint(value.Uint(), 10)
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 32)
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 64)
+	case protoreflect.StringKind:
+		return value.String()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(value.Bytes())
+	case protoreflect.MessageKind:
+		// only timestamps are formatted as a single value
+		fields := field.Message().Fields()
+		seconds := value.Message().Get(fields.ByName("seconds")).Int()
+		nanos := value.Message().Get(fields.ByName("nanos")).Int()
+		return time.Unix(seconds, nanos).UTC().Format(time.RFC3339Nano)
+	default:
+		return value.String()
+	}
+}
+
+func isTimestamp(message protoreflect.MessageDescriptor) bool {
+	return message.FullName() == "google.protobuf.Timestamp"
+}
@@ -0,0 +1,166 @@
+package client_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"laptop-app-using-grpc/client"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newExportedLaptops() []*pb.ExportLaptopsResponse {
+	rated := sample.NewLaptop()
+	rated.UpdatedAt = timestamppb.New(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC))
+
+	pictured := sample.NewLaptop()
+	pictured.Weight = &pb.Laptop_WeightLb{WeightLb: 4.5}
+	pictured.Gpus = nil
+
+	return []*pb.ExportLaptopsResponse{
+		{
+			Laptop: rated,
+			Rating: &pb.LaptopRating{RatedCount: 2, AverageScore: 4.5},
+		},
+		{
+			Laptop: pictured,
+			Images: []*pb.ImageReference{{ImageId: "image-1", ImageType: ".jpg", Size: 1024}},
+		},
+	}
+}
+
+func writeExport(t *testing.T, format string, req *pb.ExportLaptopsRequest, exported []*pb.ExportLaptopsResponse) *bytes.Buffer {
+	var buffer bytes.Buffer
+	writer, err := client.NewExportWriter(&buffer, format, serializer.NoCompression, req)
+	require.NoError(t, err)
+
+	for _, res := range exported {
+		require.NoError(t, writer.Write(res))
+	}
+	require.NoError(t, writer.Flush())
+
+	return &buffer
+}
+
+func TestExportNDJSON(t *testing.T) {
+	t.Parallel()
+
+	exported := newExportedLaptops()
+	buffer := writeExport(t, client.ExportNDJSON, &pb.ExportLaptopsRequest{}, exported)
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	require.Len(t, lines, len(exported))
+	for i, line := range lines {
+		expected, err := serializer.ProtobufToJSONLine(exported[i])
+		require.NoError(t, err)
+		require.Equal(t, expected, line)
+
+		res := &pb.ExportLaptopsResponse{}
+		require.NoError(t, serializer.JSONToProtobufMessage(line, res))
+		require.True(t, proto.Equal(exported[i], res))
+	}
+}
+
+func TestExportDelimited(t *testing.T) {
+	t.Parallel()
+
+	exported := newExportedLaptops()
+	buffer := writeExport(t, client.ExportDelimited, &pb.ExportLaptopsRequest{}, exported)
+
+	reader, err := serializer.NewMessageReader(buffer, serializer.Delimited, serializer.NoCompression)
+	require.NoError(t, err)
+	for _, expected := range exported {
+		res := &pb.ExportLaptopsResponse{}
+		require.NoError(t, reader.Read(res))
+		require.True(t, proto.Equal(expected, res))
+	}
+
+	require.Equal(t, io.EOF, reader.Read(&pb.ExportLaptopsResponse{}))
+}
+
+func TestExportCSV(t *testing.T) {
+	t.Parallel()
+
+	exported := newExportedLaptops()
+	req := &pb.ExportLaptopsRequest{IncludeRatings: true, IncludeImages: true}
+	buffer := writeExport(t, client.ExportCSV, req, exported)
+
+	records, err := csv.NewReader(buffer).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1+len(exported))
+
+	header := records[0]
+	require.Equal(t, "id", header[0])
+	require.Subset(t, header, []string{"cpu.brand", "screen.resolution.width", "weight_kg", "weight_lb", "gpus", "updated_at", "rating.rated_count", "rating.average_score", "images"})
+
+	rows := make([]map[string]string, len(exported))
+	for i, record := range records[1:] {
+		rows[i] = make(map[string]string)
+		for j, value := range record {
+			rows[i][header[j]] = value
+		}
+	}
+
+	rated := exported[0].GetLaptop()
+	require.Equal(t, rated.GetId(), rows[0]["id"])
+	require.Equal(t, rated.GetCpu().GetBrand(), rows[0]["cpu.brand"])
+	require.Equal(t, strconv.FormatUint(uint64(rated.GetCpu().GetCpuCores()), 10), rows[0]["cpu.cpu_cores"])
+	require.Equal(t, rated.GetKeyboard().GetLayout().String(), rows[0]["keyboard.layout"])
+	require.Equal(t, strconv.FormatFloat(rated.GetWeightKg(), 'g', -1, 64), rows[0]["weight_kg"])
+	require.Empty(t, rows[0]["weight_lb"])
+	require.Equal(t, "2024-05-01T12:30:00Z", rows[0]["updated_at"])
+	require.True(t, strings.HasPrefix(rows[0]["gpus"], `[{"brand":`), rows[0]["gpus"])
+	require.Equal(t, "2", rows[0]["rating.rated_count"])
+	require.Equal(t, "4.5", rows[0]["rating.average_score"])
+	require.Equal(t, "[]", rows[0]["images"])
+
+	require.Empty(t, rows[1]["weight_kg"])
+	require.Equal(t, "4.5", rows[1]["weight_lb"])
+	require.Equal(t, "[]", rows[1]["gpus"])
+	require.Empty(t, rows[1]["rating.rated_count"])
+	require.Equal(t, `[{"image_id":"image-1","image_type":".jpg","size":"1024"}]`, rows[1]["images"])
+
+	// ratings and images have no columns unless they are exported
+	buffer = writeExport(t, client.ExportCSV, &pb.ExportLaptopsRequest{}, exported)
+	header, err = csv.NewReader(buffer).Read()
+	require.NoError(t, err)
+	require.NotContains(t, header, "rating.rated_count")
+	require.NotContains(t, header, "images")
+}
+
+func TestExportCompressed(t *testing.T) {
+	t.Parallel()
+
+	exported := newExportedLaptops()
+	for _, format := range client.ExportFormats {
+		var buffer bytes.Buffer
+		writer, err := client.NewExportWriter(&buffer, format, serializer.Gzip, &pb.ExportLaptopsRequest{})
+		require.NoError(t, err)
+		for _, res := range exported {
+			require.NoError(t, writer.Write(res))
+		}
+		require.NoError(t, writer.Flush())
+
+		reader, err := gzip.NewReader(&buffer)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, writeExport(t, format, &pb.ExportLaptopsRequest{}, exported).Bytes(), data, format)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := client.NewExportWriter(io.Discard, "xml", serializer.NoCompression, &pb.ExportLaptopsRequest{})
+	require.EqualError(t, err, `unknown export format "xml", expected one of ndjson, json-array, csv, delimited`)
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/serializer"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LaptopSpec is a laptop read from a spec file
+type LaptopSpec struct {
+	Filename string
+	Laptop   *pb.Laptop
+}
+
+// ReadLaptopSpecs reads every YAML and TOML spec file in a directory, in
+// name order. Other files and subdirectories are skipped. The error joins
+// the errors of all files that cannot be read, so they can be fixed at once.
+func ReadLaptopSpecs(dir string) ([]LaptopSpec, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read spec directory: %w", err)
+	}
+
+	var filenames []string
+	for _, entry := range entries {
+		if !entry.IsDir() && serializer.IsSpecFile(entry.Name()) {
+			filenames = append(filenames, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(filenames)
+
+	specs := make([]LaptopSpec, 0, len(filenames))
+	var errs []error
+	for _, filename := range filenames {
+		laptop := &pb.Laptop{}
+		err := serializer.ReadProtobufFromSpecFile(filename, laptop)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		specs = append(specs, LaptopSpec{Filename: filename, Laptop: laptop})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return specs, nil
+}
@@ -0,0 +1,50 @@
+package client_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"laptop-app-using-grpc/client"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadLaptopSpecs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, serializer.WriteProtobufToSpecFile(laptop2, filepath.Join(dir, "b.toml")))
+	require.NoError(t, serializer.WriteProtobufToSpecFile(laptop1, filepath.Join(dir, "a.yaml")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a spec"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested.yaml"), 0755))
+
+	specs, err := client.ReadLaptopSpecs(dir)
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	require.Equal(t, filepath.Join(dir, "a.yaml"), specs[0].Filename)
+	require.True(t, proto.Equal(laptop1, specs[0].Laptop))
+	require.Equal(t, filepath.Join(dir, "b.toml"), specs[1].Filename)
+	require.True(t, proto.Equal(laptop2, specs[1].Laptop))
+}
+
+func TestReadLaptopSpecsErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, serializer.WriteProtobufToSpecFile(sample.NewLaptop(), filepath.Join(dir, "good.yml")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("ram: lots\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "worse.toml"), []byte("keyboard = { layout = \"dvorak\" }\n"), 0644))
+
+	specs, err := client.ReadLaptopSpecs(dir)
+	require.Error(t, err)
+	require.Nil(t, specs)
+	require.Contains(t, err.Error(), filepath.Join(dir, "bad.yaml")+`: ram: "lots" is not a memory size like 16GB`)
+	require.Contains(t, err.Error(), filepath.Join(dir, "worse.toml")+": keyboard.layout must be one of")
+
+	_, err = client.ReadLaptopSpecs(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"laptop-app-using-grpc/tracing"
+	"sync"
+)
+
+// TracingInterceptor is a client interceptor that starts a span for every
+// call and sends its trace context to the server as traceparent metadata
+type TracingInterceptor struct {
+	tracer *tracing.Tracer
+}
+
+// NewTracingInterceptor returns a new tracing interceptor
+func NewTracingInterceptor(tracer *tracing.Tracer) *TracingInterceptor {
+	return &TracingInterceptor{tracer}
+}
+
+// Unary returns a client interceptor to trace unary RPC
+func (interceptor *TracingInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := interceptor.start(ctx, method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endSpan(span, err)
+
+		return err
+	}
+}
+
+// Stream returns a client interceptor to trace stream RPC, the span ends
+// when the stream fails or the server closes it
+func (interceptor *TracingInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := interceptor.start(ctx, method)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+
+		return &tracedClientStream{ClientStream: stream, span: span, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+func (interceptor *TracingInterceptor) start(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	ctx, span := interceptor.tracer.Start(ctx, method, tracing.KindClient)
+	span.SetAttribute("rpc.method", method)
+
+	ctx = metadata.AppendToOutgoingContext(ctx, tracing.TraceparentHeader, span.SpanContext().Traceparent())
+	return ctx, span
+}
+
+func endSpan(span *tracing.Span, err error) {
+	span.SetAttribute("rpc.grpc.status_code", status.Code(err).String())
+	span.SetError(err)
+	span.End()
+}
+
+// tracedClientStream ends the span of the call once the stream is finished
+type tracedClientStream struct {
+	grpc.ClientStream
+	span *tracing.Span
+	// the stream is finished after the first response when the server does not stream
+	serverStreams bool
+	once          sync.Once
+}
+
+// RecvMsg receives a message and ends the span at the end of the stream
+func (stream *tracedClientStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	if err != nil || !stream.serverStreams {
+		stream.once.Do(func() {
+			if err == io.EOF {
+				endSpan(stream.span, nil)
+				return
+			}
+			endSpan(stream.span, err)
+		})
+	}
+
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"laptop-app-using-grpc/cert"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// certgen writes a self-signed CA plus server and client certificates signed by it
+func main() {
+	outFolder := flag.String("out", "cert", "the folder to write the PEM files to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,0.0.0.0", "comma separated DNS names and IPs of the server")
+	clientName := flag.String("client", "laptop-client", "the common name of the client certificate")
+	flag.Parse()
+
+	err := os.MkdirAll(*outFolder, 0755)
+	if err != nil {
+		log.Fatal("Cannot create output folder: ", err)
+	}
+
+	ca, err := cert.GenerateCA("Laptop App CA")
+	if err != nil {
+		log.Fatal("Cannot generate CA: ", err)
+	}
+	writeKeyPair(ca, *outFolder, "ca")
+
+	server, err := cert.GenerateServer(ca, "laptop-server", strings.Split(*hosts, ","))
+	if err != nil {
+		log.Fatal("Cannot generate server certificate: ", err)
+	}
+	writeKeyPair(server, *outFolder, "server")
+
+	client, err := cert.GenerateClient(ca, *clientName)
+	if err != nil {
+		log.Fatal("Cannot generate client certificate: ", err)
+	}
+	writeKeyPair(client, *outFolder, "client")
+}
+
+func writeKeyPair(keyPair *cert.KeyPair, outFolder string, name string) {
+	certFile := filepath.Join(outFolder, name+"-cert.pem")
+	keyFile := filepath.Join(outFolder, name+"-key.pem")
+
+	err := keyPair.WriteFiles(certFile, keyFile)
+	if err != nil {
+		log.Fatal("Cannot write ", name, " certificate: ", err)
+	}
+
+	log.Printf("Wrote %s and %s", certFile, keyFile)
+}
@@ -5,51 +5,88 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 	"io"
+	"laptop-app-using-grpc/cert"
+	"laptop-app-using-grpc/client"
 	"laptop-app-using-grpc/pb/pb"
 	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"laptop-app-using-grpc/tracing"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
+// number of times a create laptop request is sent before giving up
+const createLaptopAttempts = 3
+
 func createLaptop(laptopClient pb.LaptopServiceClient, laptop *pb.Laptop) {
 	//Removing the generated universal Id with every laptop
+	//The same idempotency key is sent on every retry, so a request that
+	//went through before timing out is not applied twice
 	req := &pb.CreateLaptopRequest{
-		Laptop: laptop,
+		Laptop:         laptop,
+		IdempotencyKey: uuid.New().String(),
 	}
 
-	//Set timeout for connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	var res *pb.CreateLaptopResponse
+	var err error
+	for attempt := 1; attempt <= createLaptopAttempts; attempt++ {
+		res, err = createLaptopOnce(laptopClient, req)
+		if !isRetryable(err) {
+			break
+		}
+
+		log.Printf("Create laptop attempt %d failed: %v", attempt, err)
+	}
 
-	res, err := laptopClient.CreateLaptop(ctx, req)
 	if err != nil {
 		st, ok := status.FromError(err)
 		if ok && st.Code() == codes.AlreadyExists {
 			log.Println("Laptop Already Exists")
 		} else {
 			//Worse
-			log.Fatal("Cannot create Laptop", err)
+			log.Fatal("Cannot create Laptop:\n", client.DescribeError(err))
 		}
 		return
 	}
 	log.Printf("Created Laptop with Id: %s", res.Id)
 }
 
+func createLaptopOnce(laptopClient pb.LaptopServiceClient, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
+	//Set timeout for connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return laptopClient.CreateLaptop(ctx, req)
+}
+
+// isRetryable reports whether a request may be sent again with the same idempotency key
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Unavailable:
+		return true
+	default:
+		return false
+	}
+}
+
 func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores []float64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	stream, err := laptopClient.RateLaptop(ctx)
 	if err != nil {
-		return fmt.Errorf("cannot rate laptop: %v", err)
+		return fmt.Errorf("cannot rate laptop: %w", err)
 	}
 
 	waitResponse := make(chan error)
@@ -63,7 +100,7 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 				return
 			}
 			if err != nil {
-				waitResponse <- fmt.Errorf("cannot receive stream response: %v", err)
+				waitResponse <- fmt.Errorf("cannot receive stream response: %w", err)
 				return
 			}
 
@@ -74,13 +111,18 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 	// send requests
 	for i, laptopID := range laptopIDs {
 		req := &pb.RateLaptopRequest{
-			LaptopId: laptopID,
-			Score:    scores[i],
+			LaptopId:       laptopID,
+			Score:          scores[i],
+			IdempotencyKey: uuid.New().String(),
 		}
 
 		err := stream.Send(req)
 		if err != nil {
-			return fmt.Errorf("cannot send stream request: %v - %v", err, stream.RecvMsg(nil))
+			// the server closed the stream, the receiver gets its status
+			if recvErr := <-waitResponse; recvErr != nil {
+				return recvErr
+			}
+			return fmt.Errorf("cannot send stream request: %w", err)
 		}
 
 		log.Print("sent request: ", req)
@@ -88,7 +130,7 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 
 	err = stream.CloseSend()
 	if err != nil {
-		return fmt.Errorf("cannot close send: %v", err)
+		return fmt.Errorf("cannot close send: %w", err)
 	}
 
 	err = <-waitResponse
@@ -107,7 +149,7 @@ func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
 	// Sending the search request to the client in context of the search laptop stream
 	stream, err := laptopClient.SearchLaptop(ctx, req)
 	if err != nil {
-		log.Fatal("cannot search laptop: ", err)
+		log.Fatal("cannot search laptop:\n", client.DescribeError(err))
 	}
 
 	for {
@@ -118,7 +160,7 @@ func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
 			return
 		}
 		if err != nil {
-			log.Fatal("cannot receive response: ", err)
+			log.Fatal("cannot receive response:\n", client.DescribeError(err))
 		}
 
 		// Printing the details of the response laptop configurations
@@ -148,7 +190,7 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 	// Defining the uploadImage stream from client-side in the context
 	stream, err := laptopClient.UploadImage(ctx)
 	if err != nil {
-		log.Fatal("Cannot upload laptop image file: ", err)
+		log.Fatal("Cannot upload laptop image file:\n", client.DescribeError(err))
 	}
 
 	// Constructing the upload Image request model with the data of the request_info (like laptop id and image type)
@@ -164,7 +206,9 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 	// Sending the request into the client-side stream
 	err = stream.Send(req)
 	if err != nil {
-		log.Fatal("Cannot send image info: ", err)
+		// the server closed the stream, its status explains why
+		_, err = stream.CloseAndRecv()
+		log.Fatal("Cannot send image info:\n", client.DescribeError(err))
 	}
 
 	// Reading the open file at the imagePath and making a buffer of bytes
@@ -190,20 +234,137 @@ func uploadImage(laptopClient pb.LaptopServiceClient, laptopID string, imagePath
 		// Sending the new request consisting og bytes chunk to stream
 		err = stream.Send(req)
 		if err != nil {
-			log.Fatal("Cannot send chunk to server: ", err, stream.RecvMsg(nil))
+			_, err = stream.CloseAndRecv()
+			log.Fatal("Cannot send chunk to server:\n", client.DescribeError(err))
 		}
 	}
 
 	// Closing the client-side stream after receiving the request and sending out a response
 	res, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatal("Cannot receive response: ", err)
+		log.Fatal("Cannot receive response:\n", client.DescribeError(err))
 	}
 
 	// The response prints out the laptop Id and image size
 	log.Printf("Image uploaded with ID: %s, size: %d", res.GetId(), res.GetSize())
 }
 
+// importLaptops sends all laptops on one stream and logs the outcome of the failed ones
+func importLaptops(laptopClient pb.LaptopServiceClient, laptops []*pb.Laptop, allOrNothing bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stream, err := laptopClient.ImportLaptops(ctx)
+	if err != nil {
+		log.Fatal("Cannot import laptops:\n", client.DescribeError(err))
+	}
+
+	for _, laptop := range laptops {
+		err = stream.Send(&pb.ImportLaptopsRequest{Laptop: laptop, AllOrNothing: allOrNothing})
+		if err != nil {
+			// the server closed the stream, its status explains why
+			break
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatal("Cannot import laptops:\n", client.DescribeError(err))
+	}
+
+	for _, result := range res.GetResults() {
+		if result.GetOutcome() != pb.ImportLaptopResult_CREATED {
+			log.Printf("- laptop %d %s: %s %s", result.GetIndex(), result.GetLaptopId(), result.GetOutcome(), strings.Join(result.GetReasons(), "; "))
+		}
+	}
+	log.Printf("Imported laptops: %d created, %d duplicate, %d invalid", res.GetCreatedCount(), res.GetDuplicateCount(), res.GetInvalidCount())
+}
+
+// exportLaptops runs the export subcommand, which writes the catalog or the
+// laptops matching the filter flags to a file or stdout
+func exportLaptops(laptopClient pb.LaptopServiceClient, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", client.ExportNDJSON, "the export format: "+strings.Join(client.ExportFormats, ", "))
+	output := flags.String("output", "-", "the file to write to, - for stdout")
+	compression := flags.String("compression", "", "compress the export with gzip or zstd, by default from the .gz or .zst extension of the output file")
+	includeRatings := flags.Bool("ratings", false, "include the rating of every laptop")
+	includeImages := flags.Bool("images", false, "include the image references of every laptop")
+	maxPrice := flags.Float64("max-price", 0, "only export laptops up to this price in USD, 0 for any price")
+	minCPUCores := flags.Uint("min-cpu-cores", 0, "only export laptops with at least this many CPU cores")
+	minCPUGhz := flags.Float64("min-cpu-ghz", 0, "only export laptops with at least this CPU frequency")
+	minRAMGB := flags.Uint64("min-ram-gb", 0, "only export laptops with at least this much RAM in gigabytes")
+	flags.Parse(args)
+
+	req := &pb.ExportLaptopsRequest{
+		IncludeRatings: *includeRatings,
+		IncludeImages:  *includeImages,
+	}
+
+	//Without filter flags the whole catalog is exported
+	if *maxPrice > 0 || *minCPUCores > 0 || *minCPUGhz > 0 || *minRAMGB > 0 {
+		req.Filter = &pb.Filter{
+			MaxPriceUsd: *maxPrice,
+			MinCpuCores: uint32(*minCPUCores),
+			MinCpuGhz:   *minCPUGhz,
+			MinRam:      &pb.Memory{Value: *minRAMGB, Unit: pb.Memory_GIGABYTE},
+		}
+		if *maxPrice == 0 {
+			req.Filter.MaxPriceUsd = math.MaxFloat64
+		}
+	}
+
+	file := os.Stdout
+	if *output != "-" {
+		var err error
+		file, err = os.Create(*output)
+		if err != nil {
+			log.Fatal("Cannot create export file: ", err)
+		}
+		defer file.Close()
+	}
+
+	exportCompression := serializer.Compression(*compression)
+	if len(*compression) == 0 {
+		exportCompression = serializer.CompressionFromFilename(*output)
+	}
+
+	writer, err := client.NewExportWriter(file, *format, exportCompression, req)
+	if err != nil {
+		log.Fatal("Cannot export laptops: ", err)
+	}
+
+	count, err := client.ExportLaptops(context.Background(), laptopClient, req, writer)
+	if err != nil {
+		log.Fatal("Cannot export laptops:\n", client.DescribeError(err))
+	}
+	log.Printf("Exported %d laptops as %s", count, *format)
+}
+
+// createLaptopsFromSpecs runs the create subcommand, which imports the laptops
+// of the YAML and TOML spec files in a directory
+func createLaptopsFromSpecs(laptopClient pb.LaptopServiceClient, args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := flags.String("dir", ".", "the directory of .yaml, .yml and .toml laptop spec files")
+	allOrNothing := flags.Bool("all-or-nothing", false, "create no laptop unless all of them are valid")
+	flags.Parse(args)
+
+	specs, err := client.ReadLaptopSpecs(*dir)
+	if err != nil {
+		log.Fatal("Cannot read laptop specs:\n", err)
+	}
+	if len(specs) == 0 {
+		log.Fatalf("No laptop spec files in %s", *dir)
+	}
+
+	laptops := make([]*pb.Laptop, len(specs))
+	for i, spec := range specs {
+		log.Printf("- laptop %d: %s", i, spec.Filename)
+		laptops[i] = spec.Laptop
+	}
+
+	importLaptops(laptopClient, laptops, *allOrNothing)
+}
+
 // testCreateLaptop tests the createLaptop method on client-side
 func testCreateLaptop(laptopClient pb.LaptopServiceClient) {
 	createLaptop(laptopClient, sample.NewLaptop())
@@ -218,9 +379,11 @@ func testUploadImage(laptopClient pb.LaptopServiceClient) {
 
 // testSearchLaptop creates laptop with filter and calls searchLaptop with the defined filter
 func testSearchLaptop(laptopClient pb.LaptopServiceClient) {
-	for i := 0; i < 10; i++ {
-		createLaptop(laptopClient, sample.NewLaptop())
+	laptops := make([]*pb.Laptop, 10)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
 	}
+	importLaptops(laptopClient, laptops, false)
 
 	filter := &pb.Filter{
 		MaxPriceUsd: 3000,
@@ -258,21 +421,94 @@ func testRateLaptop(laptopClient pb.LaptopServiceClient) {
 
 		err := rateLaptop(laptopClient, laptopIDs, scores)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatal("Cannot rate laptops:\n", client.DescribeError(err))
 		}
 	}
 }
 
+// authMethods returns the RPCs that need an access token, every RPC of the
+// laptop and API key services, so a new RPC gets the token too
+func authMethods() map[string]bool {
+	methods := map[string]bool{}
+	for _, desc := range []grpc.ServiceDesc{pb.LaptopService_ServiceDesc, pb.ApiKeyService_ServiceDesc} {
+		for _, method := range desc.Methods {
+			methods["/"+desc.ServiceName+"/"+method.MethodName] = true
+		}
+		for _, stream := range desc.Streams {
+			methods["/"+desc.ServiceName+"/"+stream.StreamName] = true
+		}
+	}
+
+	return methods
+}
+
 func main() {
 	serverAddress := flag.String("address", "", "the server address")
+	tlsCA := flag.String("tls-ca", "", "the CA file that signed the server certificate, enables TLS")
+	tlsCert := flag.String("tls-cert", "", "the client certificate file for mutual TLS")
+	tlsKey := flag.String("tls-key", "", "the client private key file for mutual TLS")
+	username := flag.String("username", "", "the user to log in as, enables authentication")
+	password := flag.String("password", "", "the password of the user")
+	apiKey := flag.String("api-key", "", "the API key to call the server with instead of logging in")
+	traceOutput := flag.String("trace-output", "", "export spans as JSON lines to stdout or this file, empty to disable tracing")
 	flag.Parse()
 	log.Printf("dial server %s", *serverAddress)
 
-	conn, err := grpc.Dial(*serverAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	transportCredentials := insecure.NewCredentials()
+	if len(*tlsCA) > 0 {
+		tlsConfig, err := cert.LoadClientTLSConfig(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal("cannot load TLS credentials: ", err)
+		}
+
+		transportCredentials = credentials.NewTLS(tlsConfig)
+	}
+
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(transportCredentials)}
+	if len(*apiKey) > 0 {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(client.NewAPIKeyCredentials(*apiKey)))
+	}
+
+	var unaryInterceptors []grpc.UnaryClientInterceptor
+	var streamInterceptors []grpc.StreamClientInterceptor
+	if len(*traceOutput) > 0 {
+		exporter, err := tracing.NewExporter(*traceOutput)
+		if err != nil {
+			log.Fatal("cannot create trace exporter: ", err)
+		}
+		defer exporter.Close()
+
+		tracingInterceptor := client.NewTracingInterceptor(tracing.NewTracer("laptop-client", exporter))
+		unaryInterceptors = append(unaryInterceptors, tracingInterceptor.Unary())
+		streamInterceptors = append(streamInterceptors, tracingInterceptor.Stream())
+	}
+
+	conn, err := grpc.Dial(*serverAddress, append(dialOptions,
+		grpc.WithChainUnaryInterceptor(unaryInterceptors...),
+		grpc.WithChainStreamInterceptor(streamInterceptors...),
+	)...)
 	if err != nil {
 		log.Fatal("cannot dial server: ", err)
 	}
 
+	if len(*username) > 0 {
+		authClient := client.NewAuthClient(conn, *username, *password)
+		interceptor, err := client.NewAuthInterceptor(authClient, authMethods())
+		if err != nil {
+			log.Fatal("cannot create auth interceptor: ", err)
+		}
+		defer interceptor.Close()
+
+		//Dialing again with the same options so every laptop RPC carries the access token too
+		conn, err = grpc.Dial(*serverAddress, append(dialOptions,
+			grpc.WithChainUnaryInterceptor(append(unaryInterceptors, interceptor.Unary())...),
+			grpc.WithChainStreamInterceptor(append(streamInterceptors, interceptor.Stream())...),
+		)...)
+		if err != nil {
+			log.Fatal("cannot dial server: ", err)
+		}
+	}
+
 	//Starting laptop service (client-side) on grpc connection
 	laptopClient := pb.NewLaptopServiceClient(conn)
 
@@ -289,5 +525,13 @@ func main() {
 	//
 	//searchLaptop(laptopClient, filter)
 	//testUploadImage(laptopClient)
-	testRateLaptop(laptopClient)
+
+	switch flag.Arg(0) {
+	case "export":
+		exportLaptops(laptopClient, flag.Args()[1:])
+	case "create":
+		createLaptopsFromSpecs(laptopClient, flag.Args()[1:])
+	default:
+		testRateLaptop(laptopClient)
+	}
 }
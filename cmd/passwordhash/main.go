@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"os"
+	"strings"
+)
+
+// passwordhash reads a password from stdin and prints its bcrypt hash for
+// the password_hash of a user in the server config or users file
+func main() {
+	cost := flag.Int("cost", bcrypt.DefaultCost, "the bcrypt cost")
+	flag.Parse()
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(password) == 0 {
+		log.Fatal("Cannot read password: ", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) == 0 {
+		log.Fatal("The password is empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		log.Fatal("Cannot hash password: ", err)
+	}
+
+	fmt.Println(string(hash))
+}
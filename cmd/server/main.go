@@ -1,43 +1,498 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"laptop-app-using-grpc/cert"
+	"laptop-app-using-grpc/config"
+	"laptop-app-using-grpc/gateway"
+	"laptop-app-using-grpc/grpcweb"
+	"laptop-app-using-grpc/metrics"
 	"laptop-app-using-grpc/pb/pb"
 	"laptop-app-using-grpc/service"
+	"laptop-app-using-grpc/tracing"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
-	port := flag.Int("port", 0, "the server port")
-	flag.Parse()
-	log.Printf("The server started on port %d", *port)
+	cfg, err := config.Parse(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("Cannot load config: ", err)
+	}
+
+	level, err := service.ParseLogLevel(cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logLevelVar := &slog.LevelVar{}
+	logLevelVar.Set(level)
+
+	logger, err := service.NewLogger(os.Stderr, cfg.Log.Format, logLevelVar)
+	if err != nil {
+		log.Fatal(err)
+	}
+	//The log package writes through the same handler from here on
+	slog.SetDefault(logger)
+	slog.Info("The server started", "host", cfg.Server.Host, "port", cfg.Server.Port)
 
 	//Defining stores
-	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("img")
-	ratingStore := service.NewInMemoryRatingStore()
+	laptopStore, err := newLaptopStore(cfg.Store.Laptop, cfg.Store.DataDir, cfg.Store.SnapshotEvery, cfg.Store.Shards)
+	if err != nil {
+		log.Fatal("Cannot create laptop store: ", err)
+	}
+	imageStore := service.NewDiskImageStore(cfg.Store.ImageDir)
+	ratingStore, err := newRatingStore(cfg.Store.Rating, cfg.Store.DataDir, cfg.Store.SnapshotEvery)
+	if err != nil {
+		log.Fatal("Cannot create rating store: ", err)
+	}
 	//Creating a laptop server service
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	idempotencyStore := service.NewInMemoryIdempotencyStore(cfg.Server.IdempotencyTTL)
+	laptopServer := service.NewLaptopServer(
+		laptopStore,
+		imageStore,
+		ratingStore,
+		service.WithIdempotencyStore(idempotencyStore),
+		service.WithMaxImageSize(cfg.Image.MaxSize),
+		service.WithMaxSearchResults(cfg.Search.MaxResults),
+		service.WithSearchSendTimeout(cfg.Search.SendTimeout),
+	)
+	//Lets a search expire a stream whose client stopped reading
+	serverOptions := []grpc.ServerOption{grpc.InTapHandle(service.ExpirableStreams)}
+	var tlsConfig *tls.Config
+	if len(cfg.TLS.Cert) > 0 {
+		tlsConfig, err = cert.LoadServerTLSConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA)
+		if err != nil {
+			log.Fatal("Cannot load TLS credentials: ", err)
+		}
+
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		slog.Info("TLS enabled", "mutual_tls", len(cfg.TLS.ClientCA) > 0)
+	}
+
+	userStore := service.NewInMemoryUserStore()
+	apiKeyStore, err := newAPIKeyStore(cfg.Store.APIKey, cfg.Store.DataDir, cfg.Store.SnapshotEvery)
+	if err != nil {
+		log.Fatal("Cannot create API key store: ", err)
+	}
+	jwtManager := service.NewJWTManager(cfg.Auth.JWTSecret, cfg.Auth.TokenDuration)
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
+
+	//Tracing runs first, so the server span covers the whole call
+	if len(cfg.Tracing.Output) > 0 {
+		exporter, err := tracing.NewExporter(cfg.Tracing.Output)
+		if err != nil {
+			log.Fatal("Cannot create trace exporter: ", err)
+		}
+		defer exporter.Close()
+
+		tracingInterceptor := service.NewTracingInterceptor(tracing.NewTracer("laptop-server", exporter))
+		unaryInterceptors = append(unaryInterceptors, tracingInterceptor.Unary())
+		streamInterceptors = append(streamInterceptors, tracingInterceptor.Stream())
+	}
+
+	//Logging runs next, so calls rejected by auth or rate limits are logged too
+	loggingInterceptor := service.NewLoggingInterceptor(logger)
+	unaryInterceptors = append(unaryInterceptors, loggingInterceptor.Unary())
+	streamInterceptors = append(streamInterceptors, loggingInterceptor.Stream())
+
+	if cfg.Server.MetricsPort > 0 {
+		registry := newMetricsRegistry(laptopStore, imageStore, ratingStore)
+		metricsInterceptor := service.NewMetricsInterceptor(registry)
+		unaryInterceptors = append(unaryInterceptors, metricsInterceptor.Unary())
+		streamInterceptors = append(streamInterceptors, metricsInterceptor.Stream())
+
+		go serveMetrics(cfg.Server.Host, cfg.Server.MetricsPort, registry)
+	}
+
+	if cfg.Auth.Enabled {
+		users, err := cfg.Auth.LoadUsers()
+		if err != nil {
+			log.Fatal("Cannot load users: ", err)
+		}
+
+		for _, user := range users {
+			err = userStore.Save(user)
+			if err != nil {
+				log.Fatal("Cannot save user ", user.Username, ": ", err)
+			}
+		}
+		slog.Info("Loaded users", "count", len(users))
+
+		authInterceptor := service.NewAuthInterceptor(jwtManager, apiKeyStore, accessibleRoles(), publicMethods())
+		unaryInterceptors = append(unaryInterceptors, authInterceptor.Unary())
+		streamInterceptors = append(streamInterceptors, authInterceptor.Stream())
+	}
+
+	//Rate limits run after auth, so clients are told apart by identity when possible.
+	//The limiter is always installed, so limits can be turned on by a reload.
+	limits, err := cfg.RateLimit.Limits.Parse()
+	if err != nil {
+		log.Fatal("Cannot parse rate limits: ", err)
+	}
+	rateLimiter := service.NewRateLimiter(limits, cfg.RateLimit.MaxStreams)
+	unaryInterceptors = append(unaryInterceptors, rateLimiter.Unary())
+	streamInterceptors = append(streamInterceptors, rateLimiter.Stream())
+
+	interceptorOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	serverOptions = append(serverOptions, interceptorOptions...)
+
+	authServer := service.NewAuthServer(userStore, jwtManager)
+	apiKeyServer := service.NewAPIKeyServer(apiKeyStore)
+	registerServices := func(grpcServer *grpc.Server) {
+		pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+		pb.RegisterAuthServiceServer(grpcServer, authServer)
+		pb.RegisterApiKeyServiceServer(grpcServer, apiKeyServer)
+	}
+
 	//Creating a grpc web server
-	grpcServer := grpc.NewServer()
-	//Adding laptop server in grpc service
-	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	grpcServer := grpc.NewServer(serverOptions...)
+	//Adding the services in grpc server
+	registerServices(grpcServer)
+
+	//Health reflects the readiness of the stores behind every service
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	healthChecker := service.NewHealthChecker(healthServer, cfg.Server.HealthInterval)
+	healthChecker.AddService(pb.LaptopService_ServiceDesc.ServiceName, laptopStore, imageStore, ratingStore)
+	healthChecker.AddService(pb.AuthService_ServiceDesc.ServiceName)
+	healthChecker.AddService(pb.ApiKeyService_ServiceDesc.ServiceName, apiKeyStore)
+	healthChecker.Start()
 
 	reflection.Register(grpcServer)
 
-	address := fmt.Sprintf("0.0.0.0:%d", *port)
+	address := net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		log.Fatal("Cannot start server: ", err)
 	}
 
 	//Initializing grpc server on listener
-	err = grpcServer.Serve(listener)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- grpcServer.Serve(listener)
+	}()
+
+	var drainers []service.Drainer
+	if cfg.Server.GatewayPort > 0 {
+		gatewayAddress := net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.GatewayPort))
+		httpGateway, err := startGateway(gatewayAddress, tlsConfig, interceptorOptions, registerServices, cfg.CORS.AllowedOrigins, serveErr)
+		if err != nil {
+			log.Fatal("Cannot start gateway: ", err)
+		}
+		slog.Info("Serving REST gateway and gRPC-Web", "port", cfg.Server.GatewayPort, "tls", tlsConfig != nil, "cors_origins", cfg.CORS.AllowedOrigins.String())
+
+		//The gateway is drained together with the gRPC server, before the stores behind both are closed
+		drainers = append(drainers, httpGateway)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for shuttingDown := false; !shuttingDown; {
+		select {
+		case err := <-serveErr:
+			log.Fatal("Cannot listen and serve: ", err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reloadConfig(logLevelVar, rateLimiter)
+				continue
+			}
+
+			slog.Info("Shutting down", "signal", sig.String(), "timeout", cfg.Server.ShutdownTimeout)
+			shuttingDown = true
+		}
+	}
+	//A second signal skips the drain
+	signal.Stop(signals)
+
+	err = service.GracefulShutdown(grpcServer, healthChecker, cfg.Server.ShutdownTimeout, drainers, closers(laptopStore, ratingStore, apiKeyStore)...)
+	if err != nil {
+		log.Fatal("Cannot close stores: ", err)
+	}
+	slog.Info("The server stopped")
+}
+
+// httpGateway serves the REST gateway and gRPC-Web, which call the services
+// through an in-process gRPC server. The HTTP port has the same TLS and
+// client certificate checks as the public port. The in-process server has
+// the same tap handle and interceptors as the public one, behind one that
+// makes the address of each HTTP client the peer of its call, so rate limits
+// and logs see the real caller.
+type httpGateway struct {
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	conn       *grpc.ClientConn
+}
+
+// startGateway serves the gateway on address, with TLS when tlsConfig is
+// set, and reports serving errors to serveErr. gRPC-Web calls are told apart
+// from REST calls by their content type.
+func startGateway(
+	address string,
+	tlsConfig *tls.Config,
+	serverOptions []grpc.ServerOption,
+	registerServices func(*grpc.Server),
+	corsOrigins []string,
+	serveErr chan<- error,
+) (*httpGateway, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	//Only the gateway can reach the in-process server, so it may trust the forwarded client address
+	forwardedPeer := service.NewForwardedPeerInterceptor()
+	internalOptions := append([]grpc.ServerOption{
+		grpc.InTapHandle(service.ExpirableStreams),
+		grpc.ChainUnaryInterceptor(forwardedPeer.Unary()),
+		grpc.ChainStreamInterceptor(forwardedPeer.Stream()),
+	}, serverOptions...)
+
+	internalListener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(internalOptions...)
+	registerServices(grpcServer)
+	go grpcServer.Serve(internalListener)
+
+	conn, err := grpc.Dial(
+		"bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return internalListener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		grpcServer.Stop()
+		listener.Close()
+		return nil, fmt.Errorf("cannot connect to in-process server: %w", err)
+	}
+
+	restHandler := gateway.NewGateway(pb.NewLaptopServiceClient(conn), pb.NewAuthServiceClient(conn))
+	grpcWebHandler := grpcweb.NewHandler(conn, corsOrigins)
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if grpcweb.IsGRPCWebRequest(r) {
+				grpcWebHandler.ServeHTTP(w, r)
+				return
+			}
+			restHandler.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	if tlsConfig != nil {
+		//ServeTLS offers HTTP/2 and HTTP/1.1 with the certificates of this config
+		httpServer.TLSConfig = tlsConfig.Clone()
+	}
+	go func() {
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = httpServer.Serve(listener)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	return &httpGateway{
+		httpServer: httpServer,
+		grpcServer: grpcServer,
+		conn:       conn,
+	}, nil
+}
+
+// Shutdown refuses new requests and waits for the active ones until ctx is
+// done, then closes their connections and stops the in-process server
+func (server *httpGateway) Shutdown(ctx context.Context) error {
+	err := server.httpServer.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("gateway requests did not finish in time, closing them")
+		err = server.httpServer.Close()
+	}
+
+	server.conn.Close()
+	server.grpcServer.Stop()
+
+	return err
+}
+
+// reloadConfig loads the config again and applies the settings that can
+// change at runtime, the log level and the rate limits. Other settings need
+// a restart. A broken config is logged and the running settings are kept.
+func reloadConfig(logLevelVar *slog.LevelVar, rateLimiter *service.RateLimiter) {
+	cfg, err := config.Parse(os.Args[0], os.Args[1:])
+	if err != nil {
+		slog.Error("Cannot reload config", "error", err)
+		return
+	}
+
+	limits, err := cfg.RateLimit.Limits.Parse()
+	if err != nil {
+		slog.Error("Cannot reload config", "error", err)
+		return
+	}
+
+	level, err := service.ParseLogLevel(cfg.Log.Level)
+	if err != nil {
+		slog.Error("Cannot reload config", "error", err)
+		return
+	}
+
+	logLevelVar.Set(level)
+	rateLimiter.SetLimits(limits, cfg.RateLimit.MaxStreams)
+	slog.Info("Reloaded config", "log_level", level.String(), "rate_limits", cfg.RateLimit.Limits.String(), "max_streams", cfg.RateLimit.MaxStreams)
+}
+
+// closers returns the stores that have to be closed to flush their writes
+func closers(stores ...interface{}) []io.Closer {
+	var result []io.Closer
+	for _, store := range stores {
+		if closer, ok := store.(io.Closer); ok {
+			result = append(result, closer)
+		}
+	}
+
+	return result
+}
+
+// newMetricsRegistry returns a registry with gauges for the size of every store
+func newMetricsRegistry(
+	laptopStore service.LaptopStore,
+	imageStore service.ImageStore,
+	ratingStore service.RatingStore,
+) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.NewGaugeFunc("laptop_store_laptops", "Number of laptops in the store.", func() float64 {
+		return float64(laptopStore.Count())
+	})
+	registry.NewGaugeFunc("image_store_images", "Number of uploaded laptop images.", func() float64 {
+		count, _ := imageStore.Usage()
+		return float64(count)
+	})
+	registry.NewGaugeFunc("image_store_bytes", "Total size of uploaded laptop images on disk in bytes.", func() float64 {
+		_, size := imageStore.Usage()
+		return float64(size)
+	})
+	registry.NewGaugeFunc("rating_store_ratings", "Total number of laptop ratings.", func() float64 {
+		return float64(ratingStore.Count())
+	})
+
+	return registry
+}
+
+// serveMetrics serves the registry at /metrics on its own HTTP port
+func serveMetrics(host string, port int, registry *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+
+	server := &http.Server{
+		Addr:              net.JoinHostPort(host, strconv.Itoa(port)),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	slog.Info("Serving metrics", "port", port)
+	err := server.ListenAndServe()
 	if err != nil {
-		log.Fatal("Cannot listen and start grpc Server: ", err)
+		log.Fatal("Cannot serve metrics: ", err)
+	}
+}
+
+// accessibleRoles maps every protected RPC to the roles and API key scopes
+// that may call it. An RPC that is neither here nor in publicMethods is denied.
+func accessibleRoles() map[string][]string {
+	laptopServicePath := "/" + pb.LaptopService_ServiceDesc.ServiceName + "/"
+	apiKeyServicePath := "/" + pb.ApiKeyService_ServiceDesc.ServiceName + "/"
+
+	return map[string][]string{
+		laptopServicePath + "CreateLaptop":  {service.RoleAdmin, service.ScopeCatalogWrite},
+		laptopServicePath + "UploadImage":   {service.RoleAdmin, service.ScopeCatalogWrite},
+		laptopServicePath + "RateLaptop":    {service.RoleAdmin, service.RoleUser},
+		laptopServicePath + "SearchLaptop":  {service.RoleAdmin, service.RoleUser, service.ScopeCatalogRead},
+		laptopServicePath + "DownloadImage": {service.RoleAdmin, service.RoleUser, service.ScopeCatalogRead},
+		laptopServicePath + "ImportLaptops": {service.RoleAdmin, service.ScopeCatalogWrite},
+		laptopServicePath + "ExportLaptops": {service.RoleAdmin, service.RoleUser, service.ScopeCatalogRead},
+		apiKeyServicePath + "CreateApiKey":  {service.RoleAdmin},
+		apiKeyServicePath + "ListApiKeys":   {service.RoleAdmin},
+		apiKeyServicePath + "RevokeApiKey":  {service.RoleAdmin},
+	}
+}
+
+// publicMethods are the RPCs anyone may call without credentials
+func publicMethods() []string {
+	return []string{
+		"/" + pb.AuthService_ServiceDesc.ServiceName + "/Login",
+		"/" + healthpb.Health_ServiceDesc.ServiceName + "/Check",
+		"/" + healthpb.Health_ServiceDesc.ServiceName + "/Watch",
+		"/" + reflectionpb.ServerReflection_ServiceDesc.ServiceName + "/ServerReflectionInfo",
+	}
+}
+
+// Creating the laptop store for the chosen backend
+func newLaptopStore(storeType string, dataFolder string, snapshotEvery int, shards int) (service.LaptopStore, error) {
+	switch storeType {
+	case "memory":
+		return service.NewInMemoryLaptopStore(), nil
+	case "sharded":
+		if shards == 0 {
+			shards = runtime.GOMAXPROCS(0)
+		}
+		return service.NewShardedLaptopStore(shards), nil
+	case "disk":
+		return service.NewDiskLaptopStore(dataFolder, snapshotEvery)
+	default:
+		return nil, fmt.Errorf("unknown laptop store: %s", storeType)
+	}
+}
+
+// Creating the rating store for the chosen backend
+func newRatingStore(storeType string, dataFolder string, snapshotEvery int) (service.RatingStore, error) {
+	switch storeType {
+	case "memory":
+		return service.NewInMemoryRatingStore(), nil
+	case "disk":
+		return service.NewDiskRatingStore(dataFolder, snapshotEvery)
+	default:
+		return nil, fmt.Errorf("unknown rating store: %s", storeType)
+	}
+}
+
+// Creating the API key store for the chosen backend
+func newAPIKeyStore(storeType string, dataFolder string, snapshotEvery int) (service.APIKeyStore, error) {
+	switch storeType {
+	case "memory":
+		return service.NewInMemoryAPIKeyStore(), nil
+	case "disk":
+		return service.NewDiskAPIKeyStore(dataFolder, snapshotEvery)
+	default:
+		return nil, fmt.Errorf("unknown API key store: %s", storeType)
 	}
 }
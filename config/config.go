@@ -0,0 +1,492 @@
+// Package config loads the server configuration from defaults, a YAML file,
+// environment variables and command line flags, each overriding the ones
+// before. Every flag -some-name can also be set as LAPTOP_SOME_NAME.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/service"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the environment variable of every flag
+const envPrefix = "LAPTOP_"
+
+// Config is the whole server configuration
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Store     Store     `yaml:"store"`
+	Image     Image     `yaml:"image"`
+	Search    Search    `yaml:"search"`
+	TLS       TLS       `yaml:"tls"`
+	Auth      Auth      `yaml:"auth"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+	Log       Log       `yaml:"log"`
+	Tracing   Tracing   `yaml:"tracing"`
+	CORS      CORS      `yaml:"cors"`
+}
+
+// Server is where and how the server listens
+type Server struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	MetricsPort     int           `yaml:"metrics_port"`
+	GatewayPort     int           `yaml:"gateway_port"`
+	HealthInterval  time.Duration `yaml:"health_interval"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	IdempotencyTTL  time.Duration `yaml:"idempotency_ttl"`
+}
+
+// Store selects the store backends and their paths
+type Store struct {
+	Laptop        string `yaml:"laptop"`
+	Rating        string `yaml:"rating"`
+	APIKey        string `yaml:"api_key"`
+	DataDir       string `yaml:"data_dir"`
+	SnapshotEvery int    `yaml:"snapshot_every"`
+	ImageDir      string `yaml:"image_dir"`
+	Shards        int    `yaml:"shards"`
+}
+
+// Image limits uploaded images
+type Image struct {
+	MaxSize int `yaml:"max_size"`
+}
+
+// Search limits what a laptop search sends to its client
+type Search struct {
+	MaxResults  int           `yaml:"max_results"`
+	SendTimeout time.Duration `yaml:"send_timeout"`
+}
+
+// TLS enables TLS when Cert is set and mutual TLS when ClientCA is set too
+type TLS struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+// Auth enables JWT and API key authentication. The users who can log in
+// are listed in Users and in UsersFile, a YAML file with a users list.
+type Auth struct {
+	Enabled       bool          `yaml:"enabled"`
+	JWTSecret     string        `yaml:"jwt_secret"`
+	TokenDuration time.Duration `yaml:"token_duration"`
+	Users         []User        `yaml:"users"`
+	UsersFile     string        `yaml:"users_file"`
+}
+
+// User is a user who can log in, with a bcrypt hash of the password
+type User struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+	Role         string `yaml:"role"`
+}
+
+// usersFile is the content of the users file
+type usersFile struct {
+	Users []User `yaml:"users"`
+}
+
+// RateLimit holds the token bucket limits and the stream cap per client
+type RateLimit struct {
+	Limits     RateLimits `yaml:"limits"`
+	MaxStreams int        `yaml:"max_streams"`
+}
+
+// Log is the level and format of the server log
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Tracing is where spans are exported to, empty disables tracing
+type Tracing struct {
+	Output string `yaml:"output"`
+}
+
+// CORS lists the browser origins that may make gRPC-Web calls, * for any
+type CORS struct {
+	AllowedOrigins List `yaml:"allowed_origins"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Host:            "0.0.0.0",
+			HealthInterval:  5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+			IdempotencyTTL:  10 * time.Minute,
+		},
+		Store: Store{
+			Laptop:        "memory",
+			Rating:        "memory",
+			APIKey:        "memory",
+			DataDir:       "data",
+			SnapshotEvery: 1000,
+			ImageDir:      "img",
+		},
+		Image: Image{
+			MaxSize: service.DefaultMaxImageSize,
+		},
+		Search: Search{
+			SendTimeout: service.DefaultSearchSendTimeout,
+		},
+		Auth: Auth{
+			TokenDuration: 15 * time.Minute,
+		},
+		RateLimit: RateLimit{
+			Limits: RateLimits{},
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// Parse builds the configuration for the command line args. The file is
+// taken from -config or LAPTOP_CONFIG.
+func Parse(name string, args []string) (*Config, error) {
+	path, err := configPath(name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Default()
+	if len(path) > 0 {
+		err = config.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	// args were checked by the first pass already
+	flags.SetOutput(io.Discard)
+	flags.String("config", path, "")
+	config.bindFlags(flags)
+
+	err = applyEnv(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// configPath parses args once to find the config file, which also reports
+// bad flags and prints the usage
+func configPath(name string, args []string) (string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := flags.String("config", os.Getenv(envName("config")), "the YAML config file")
+	Default().bindFlags(flags)
+
+	err := flags.Parse(args)
+	if err != nil {
+		return "", err
+	}
+
+	return *path, nil
+}
+
+func (config *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(config)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (config *Config) bindFlags(flags *flag.FlagSet) {
+	flags.StringVar(&config.Server.Host, "host", config.Server.Host, "the address the server listens on")
+	flags.IntVar(&config.Server.Port, "port", config.Server.Port, "the server port")
+	flags.IntVar(&config.Server.MetricsPort, "metrics-port", config.Server.MetricsPort, "the HTTP port serving /metrics, zero to disable")
+	flags.IntVar(&config.Server.GatewayPort, "gateway-port", config.Server.GatewayPort, "the HTTP port of the REST gateway and gRPC-Web, zero to disable")
+	flags.DurationVar(&config.Server.HealthInterval, "health-interval", config.Server.HealthInterval, "how often store readiness is checked for the health service")
+	flags.DurationVar(&config.Server.ShutdownTimeout, "shutdown-timeout", config.Server.ShutdownTimeout, "how long active calls may run after SIGINT or SIGTERM")
+	flags.DurationVar(&config.Server.IdempotencyTTL, "idempotency-ttl", config.Server.IdempotencyTTL, "how long responses are remembered by idempotency key")
+
+	flags.StringVar(&config.Store.Laptop, "laptop-store", config.Store.Laptop, "the laptop store backend: memory, sharded or disk")
+	flags.StringVar(&config.Store.Rating, "rating-store", config.Store.Rating, "the rating store backend: memory or disk")
+	flags.StringVar(&config.Store.APIKey, "api-key-store", config.Store.APIKey, "the API key store backend: memory or disk")
+	flags.StringVar(&config.Store.DataDir, "data-dir", config.Store.DataDir, "the folder for disk store logs and snapshots")
+	flags.IntVar(&config.Store.SnapshotEvery, "snapshot-every", config.Store.SnapshotEvery, "the number of writes between disk store snapshots")
+	flags.StringVar(&config.Store.ImageDir, "image-dir", config.Store.ImageDir, "the folder uploaded images are saved to")
+	flags.IntVar(&config.Store.Shards, "laptop-shards", config.Store.Shards, "the number of shards of the sharded laptop store, zero for one per CPU")
+
+	flags.IntVar(&config.Image.MaxSize, "max-image-size", config.Image.MaxSize, "the maximum size of an uploaded image in bytes")
+
+	flags.IntVar(&config.Search.MaxResults, "max-search-results", config.Search.MaxResults, "the most laptops a search sends, zero for no limit")
+	flags.DurationVar(&config.Search.SendTimeout, "search-send-timeout", config.Search.SendTimeout, "how long a search waits for its client to take a result, zero to wait as long as the call lasts")
+
+	flags.StringVar(&config.TLS.Cert, "tls-cert", config.TLS.Cert, "the server certificate file, enables TLS")
+	flags.StringVar(&config.TLS.Key, "tls-key", config.TLS.Key, "the server private key file")
+	flags.StringVar(&config.TLS.ClientCA, "tls-client-ca", config.TLS.ClientCA, "the CA file for client certificates, enables mutual TLS")
+
+	flags.BoolVar(&config.Auth.Enabled, "auth", config.Auth.Enabled, "require a JWT access token for laptop RPCs")
+	flags.StringVar(&config.Auth.JWTSecret, "jwt-secret", config.Auth.JWTSecret, "the secret key to sign access tokens with")
+	flags.DurationVar(&config.Auth.TokenDuration, "token-duration", config.Auth.TokenDuration, "how long an access token is valid")
+	flags.StringVar(&config.Auth.UsersFile, "users-file", config.Auth.UsersFile, "the YAML file of users with bcrypt password hashes")
+
+	flags.Var(&config.RateLimit.Limits, "rate-limits", "per-RPC token bucket limits as Method=rate:burst[:msg], * for the default, msg to also limit stream messages")
+	flags.IntVar(&config.RateLimit.MaxStreams, "max-streams", config.RateLimit.MaxStreams, "the maximum concurrent streams per client, zero for no limit")
+
+	flags.StringVar(&config.Log.Level, "log-level", config.Log.Level, "the minimum log level: debug, info, warn or error")
+	flags.StringVar(&config.Log.Format, "log-format", config.Log.Format, "the log format: text or json")
+
+	flags.StringVar(&config.Tracing.Output, "trace-output", config.Tracing.Output, "export spans as JSON lines to stdout or this file, empty to disable tracing")
+
+	flags.Var(&config.CORS.AllowedOrigins, "cors-origins", "comma separated browser origins allowed to make gRPC-Web calls, * for any")
+}
+
+// applyEnv sets every flag that has its environment variable set
+func applyEnv(flags *flag.FlagSet) error {
+	var errs []error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+
+		err := flags.Set(f.Name, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", envName(f.Name), err))
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// envName turns a flag name like data-dir into LAPTOP_DATA_DIR
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Validate checks the values that are not checked by their types
+func (config *Config) Validate() error {
+	if config.Store.Laptop != "memory" && config.Store.Laptop != "sharded" && config.Store.Laptop != "disk" {
+		return fmt.Errorf("unknown store backend: %s", config.Store.Laptop)
+	}
+
+	if config.Store.Rating != "memory" && config.Store.Rating != "disk" {
+		return fmt.Errorf("unknown store backend: %s", config.Store.Rating)
+	}
+
+	if config.Store.APIKey != "memory" && config.Store.APIKey != "disk" {
+		return fmt.Errorf("unknown store backend: %s", config.Store.APIKey)
+	}
+
+	if config.Store.Shards < 0 {
+		return fmt.Errorf("laptop shards must not be negative: %d", config.Store.Shards)
+	}
+
+	if config.Image.MaxSize <= 0 {
+		return fmt.Errorf("max image size must be positive: %d", config.Image.MaxSize)
+	}
+
+	if config.Search.MaxResults < 0 {
+		return fmt.Errorf("max search results must not be negative: %d", config.Search.MaxResults)
+	}
+
+	if config.Search.SendTimeout < 0 {
+		return fmt.Errorf("search send timeout must not be negative: %s", config.Search.SendTimeout)
+	}
+
+	if len(config.TLS.Cert) > 0 != (len(config.TLS.Key) > 0) {
+		return errors.New("TLS needs both a certificate and a key")
+	}
+
+	if config.Auth.Enabled && len(config.Auth.JWTSecret) == 0 {
+		return errors.New("a JWT secret is required when auth is enabled")
+	}
+
+	if config.Auth.Enabled && len(config.Auth.Users) == 0 && len(config.Auth.UsersFile) == 0 {
+		return errors.New("users are required when auth is enabled, set auth.users or a users file")
+	}
+
+	_, err := service.ParseLogLevel(config.Log.Level)
+	if err != nil {
+		return err
+	}
+
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		return fmt.Errorf("unknown log format: %s", config.Log.Format)
+	}
+
+	_, err = config.RateLimit.Limits.Parse()
+	return err
+}
+
+// LoadUsers returns the users of the config and the users file. It fails
+// for an invalid user, a username that is used twice, or no users at all.
+func (auth Auth) LoadUsers() ([]*service.User, error) {
+	entries := append([]User(nil), auth.Users...)
+	if len(auth.UsersFile) > 0 {
+		data, err := os.ReadFile(auth.UsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read users file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		file := usersFile{}
+		err = decoder.Decode(&file)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("cannot parse users file %s: %w", auth.UsersFile, err)
+		}
+		entries = append(entries, file.Users...)
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("no users are configured")
+	}
+
+	users := make([]*service.User, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if seen[entry.Username] {
+			return nil, fmt.Errorf("user %s is configured twice", entry.Username)
+		}
+		seen[entry.Username] = true
+
+		user, err := service.NewHashedUser(entry.Username, entry.PasswordHash, entry.Role)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// List is a list of strings set from a comma separated flag
+type List []string
+
+// String joins the list with commas
+func (list List) String() string {
+	return strings.Join(list, ",")
+}
+
+// Set replaces the list with the comma separated values
+func (list *List) Set(value string) error {
+	*list = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			*list = append(*list, item)
+		}
+	}
+
+	return nil
+}
+
+// RateLimits maps a method, or * for every other method, to a limit like
+// rate:burst or rate:burst:msg. Methods without a leading slash belong to
+// the laptop service.
+type RateLimits map[string]string
+
+// String formats the limits like the -rate-limits flag
+func (limits RateLimits) String() string {
+	entries := make([]string, 0, len(limits))
+	for method, spec := range limits {
+		entries = append(entries, method+"="+spec)
+	}
+	sort.Strings(entries)
+
+	return strings.Join(entries, ",")
+}
+
+// Set replaces the limits with ones like "RateLaptop=5:10:msg,*=20:40"
+func (limits *RateLimits) Set(value string) error {
+	parsed := RateLimits{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		method, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("missing = in rate limit %q", entry)
+		}
+		parsed[method] = spec
+	}
+
+	*limits = parsed
+	return nil
+}
+
+// Parse returns the token bucket limits keyed by full method name
+func (limits RateLimits) Parse() (map[string]service.RateLimit, error) {
+	laptopServicePath := "/" + pb.LaptopService_ServiceDesc.ServiceName + "/"
+
+	parsed := make(map[string]service.RateLimit)
+	for method, spec := range limits {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "msg") {
+			return nil, fmt.Errorf("rate limit %s=%s is not rate:burst[:msg]", method, spec)
+		}
+
+		rate, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate for %s: %w", method, err)
+		}
+		// a rate of zero never refills the bucket, so calls stop after the burst
+		if rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return nil, fmt.Errorf("rate for %s must be a positive number: %s", method, parts[0])
+		}
+
+		burst, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid burst for %s: %w", method, err)
+		}
+		// a burst of zero would reject every call
+		if burst < 1 {
+			return nil, fmt.Errorf("burst for %s must be at least 1: %d", method, burst)
+		}
+
+		if method != service.DefaultRateLimitKey && !strings.HasPrefix(method, "/") {
+			method = laptopServicePath + method
+		}
+
+		parsed[method] = service.RateLimit{
+			Rate:       rate,
+			Burst:      burst,
+			PerMessage: len(parts) == 3,
+		}
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,201 @@
+package config_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"laptop-app-using-grpc/config"
+	"laptop-app-using-grpc/service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const laptopServicePath = "/vyom1611.laptop_app.LaptopService/"
+
+func TestParseDefaults(t *testing.T) {
+	cfg, err := config.Parse("server", nil)
+	require.NoError(t, err)
+	require.Equal(t, config.Default(), cfg)
+	require.Equal(t, "img", cfg.Store.ImageDir)
+	require.Equal(t, service.DefaultMaxImageSize, cfg.Image.MaxSize)
+}
+
+func TestParsePrecedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 7560
+  shutdown_timeout: 1m
+store:
+  laptop: disk
+  image_dir: /var/lib/laptop/img
+image:
+  max_size: 2048
+search:
+  max_results: 100
+rate_limit:
+  limits:
+    RateLaptop: "5:10:msg"
+  max_streams: 4
+log:
+  level: warn
+`)
+
+	// the environment overrides the file, flags override both
+	t.Setenv("LAPTOP_CONFIG", path)
+	t.Setenv("LAPTOP_PORT", "8000")
+	t.Setenv("LAPTOP_LOG_LEVEL", "debug")
+	t.Setenv("LAPTOP_RATE_LIMITS", "*=20:40")
+	t.Setenv("LAPTOP_SEARCH_SEND_TIMEOUT", "5s")
+
+	cfg, err := config.Parse("server", []string{"-port", "9000", "-max-streams", "8", "-laptop-store", "sharded", "-laptop-shards", "16", "-cors-origins", "https://a.example, https://b.example"})
+	require.NoError(t, err)
+
+	require.Equal(t, 9000, cfg.Server.Port)
+	require.Equal(t, time.Minute, cfg.Server.ShutdownTimeout)
+	require.Equal(t, "sharded", cfg.Store.Laptop)
+	require.Equal(t, 16, cfg.Store.Shards)
+	require.Equal(t, "memory", cfg.Store.Rating)
+	require.Equal(t, "/var/lib/laptop/img", cfg.Store.ImageDir)
+	require.Equal(t, 2048, cfg.Image.MaxSize)
+	require.Equal(t, 100, cfg.Search.MaxResults)
+	require.Equal(t, 5*time.Second, cfg.Search.SendTimeout)
+	require.Equal(t, "debug", cfg.Log.Level)
+	require.Equal(t, 8, cfg.RateLimit.MaxStreams)
+	require.Equal(t, config.RateLimits{"*": "20:40"}, cfg.RateLimit.Limits)
+	require.Equal(t, config.List{"https://a.example", "https://b.example"}, cfg.CORS.AllowedOrigins)
+}
+
+func TestParseConfigFlag(t *testing.T) {
+	path := writeConfig(t, "server:\n  port: 7560\n")
+
+	cfg, err := config.Parse("server", []string{"-config", path})
+	require.NoError(t, err)
+	require.Equal(t, 7560, cfg.Server.Port)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := config.Parse("server", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")})
+	require.Error(t, err)
+
+	path := writeConfig(t, "server:\n  prot: 7560\n")
+	_, err = config.Parse("server", []string{"-config", path})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "prot")
+
+	_, err = config.Parse("server", []string{"-laptop-store", "cloud"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-rating-store", "sharded"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-api-key-store", "sharded"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-laptop-shards", "-1"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-auth"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-auth", "-jwt-secret", "key"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "users")
+
+	_, err = config.Parse("server", []string{"-rate-limits", "RateLaptop=fast"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-search-send-timeout", "-1s"})
+	require.Error(t, err)
+
+	_, err = config.Parse("server", []string{"-unknown-flag"})
+	require.Error(t, err)
+
+	t.Setenv("LAPTOP_PORT", "seventy")
+	_, err = config.Parse("server", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "LAPTOP_PORT")
+}
+
+func TestRateLimitsParse(t *testing.T) {
+	t.Parallel()
+
+	limits := config.RateLimits{}
+	require.NoError(t, limits.Set("RateLaptop=5:10:msg, *=20:40,/other.Service/Call=1:1"))
+	require.Equal(t, "*=20:40,/other.Service/Call=1:1,RateLaptop=5:10:msg", limits.String())
+
+	parsed, err := limits.Parse()
+	require.NoError(t, err)
+	require.Equal(t, map[string]service.RateLimit{
+		laptopServicePath + "RateLaptop": {Rate: 5, Burst: 10, PerMessage: true},
+		service.DefaultRateLimitKey:      {Rate: 20, Burst: 40},
+		"/other.Service/Call":            {Rate: 1, Burst: 1},
+	}, parsed)
+
+	require.Error(t, limits.Set("RateLaptop"))
+	_, err = config.RateLimits{"RateLaptop": "5:10:all"}.Parse()
+	require.Error(t, err)
+
+	for _, spec := range []string{"5:0", "5:-1", "-1:10", "0:10", "NaN:10", "Inf:10"} {
+		_, err = config.RateLimits{"RateLaptop": spec}.Parse()
+		require.Error(t, err, spec)
+	}
+}
+
+func TestAuthLoadUsers(t *testing.T) {
+	t.Parallel()
+
+	// bcrypt hashes of "secret"
+	const adminHash = "$2a$10$ViZntokr2cAT3MiAeMh0n.65s2TIzfSCXeWWN2PS7TvkBT1crKjXy"
+	const userHash = "$2a$10$CAtSTxKz2KoNCTi99Do5YeB2VS9B/u6zb91c5RYVdUn4Mm/dvnQ8y"
+
+	usersFile := writeConfig(t, "users:\n  - username: importer\n    password_hash: \""+userHash+"\"\n    role: user\n")
+	path := writeConfig(t, `
+auth:
+  enabled: true
+  jwt_secret: key
+  users:
+    - username: ops
+      password_hash: "`+adminHash+`"
+      role: admin
+`)
+
+	cfg, err := config.Parse("server", []string{"-config", path, "-users-file", usersFile})
+	require.NoError(t, err)
+
+	users, err := cfg.Auth.LoadUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Equal(t, "ops", users[0].Username)
+	require.Equal(t, service.RoleAdmin, users[0].Role)
+	require.True(t, users[0].IsCorrectPassword("secret"))
+	require.Equal(t, "importer", users[1].Username)
+	require.True(t, users[1].IsCorrectPassword("secret"))
+
+	for name, auth := range map[string]config.Auth{
+		"no users":       {UsersFile: writeConfig(t, "users: []\n")},
+		"plain password": {Users: []config.User{{Username: "ops", PasswordHash: "secret", Role: service.RoleAdmin}}},
+		"unknown role":   {Users: []config.User{{Username: "ops", PasswordHash: adminHash, Role: "root"}}},
+		"duplicate":      {Users: cfg.Auth.Users, UsersFile: writeConfig(t, "users:\n  - username: ops\n    password_hash: \""+userHash+"\"\n    role: user\n")},
+		"missing file":   {UsersFile: filepath.Join(t.TempDir(), "users.yaml")},
+	} {
+		_, err := auth.LoadUsers()
+		require.Error(t, err, name)
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := config.Parse("server", []string{"-config", "server.example.yaml"})
+	require.NoError(t, err)
+	require.Equal(t, 7560, cfg.Server.Port)
+	require.Equal(t, "disk", cfg.Store.Rating)
+	require.Equal(t, config.List{"http://localhost:3000"}, cfg.CORS.AllowedOrigins)
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
@@ -0,0 +1,524 @@
+// Package gateway serves LaptopService as a REST API with JSON bodies, by
+// calling the gRPC service through a client. JSON uses the conventions of
+// the serializer package: original field names and enums as strings.
+// Errors are {"code", "message", "details"} with the status details of the
+// call, and a 429 has a Retry-After header when the call says when to retry.
+//
+// Routes:
+//
+//	POST /v1/login                   log in, the body is a LoginRequest
+//	POST /v1/laptops                 create a laptop, the body is a Laptop
+//	GET  /v1/laptops?max_price_usd=  search laptops, one Laptop per line
+//	POST /v1/laptops/{id}/images     upload the multipart file field "image"
+//	POST /v1/laptops/{id}/ratings    rate a laptop, the body is {"score": 8}
+//	GET  /v1/images/{id}             download an image as the plain body
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/serializer"
+	"laptop-app-using-grpc/service"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the largest JSON body accepted
+const maxBodySize = 1 << 20
+
+// size of the chunks an uploaded image is sent in
+const uploadChunkSize = 32 << 10
+
+// forwardedHeaders are passed on to the gRPC call as metadata
+var forwardedHeaders = []string{"authorization", "x-api-key", "idempotency-key", "traceparent"}
+
+// Gateway is an HTTP handler that translates REST calls to LaptopService
+// RPCs, and logins to AuthService
+type Gateway struct {
+	laptopClient pb.LaptopServiceClient
+	authClient   pb.AuthServiceClient
+}
+
+// NewGateway returns a gateway that calls laptopClient and authClient
+func NewGateway(laptopClient pb.LaptopServiceClient, authClient pb.AuthServiceClient) *Gateway {
+	return &Gateway{laptopClient, authClient}
+}
+
+// ServeHTTP routes the request to its RPC
+func (gateway *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := outgoingContext(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 2 && parts[0] == "v1" && parts[1] == "login":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		gateway.login(ctx, w, r)
+	case len(parts) == 2 && parts[0] == "v1" && parts[1] == "laptops":
+		switch r.Method {
+		case http.MethodPost:
+			gateway.createLaptop(ctx, w, r)
+		case http.MethodGet:
+			gateway.searchLaptops(ctx, w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "laptops" && parts[3] == "images":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		gateway.uploadImage(ctx, w, r, parts[2])
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "laptops" && parts[3] == "ratings":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		gateway.rateLaptop(ctx, w, r, parts[2])
+	case len(parts) == 3 && parts[0] == "v1" && parts[1] == "images":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		gateway.downloadImage(ctx, w, parts[2])
+	default:
+		writeError(w, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+	}
+}
+
+func (gateway *Gateway) login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	req := &pb.LoginRequest{}
+	err := readJSON(w, r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := gateway.authClient.Login(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (gateway *Gateway) createLaptop(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	laptop := &pb.Laptop{}
+	err := readJSON(w, r, laptop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := gateway.laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (gateway *Gateway) searchLaptops(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := gateway.laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: filter})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the status is only known at the end, so errors on the first message
+	// still get a proper HTTP status and later ones become the last line
+	res, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for err == nil {
+		err = writeLine(w, res.GetLaptop())
+		if err != nil {
+			slog.DebugContext(ctx, "cannot write search result", "error", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		res, err = stream.Recv()
+	}
+
+	if err != io.EOF {
+		writeErrorLine(w, err)
+	}
+}
+
+func (gateway *Gateway) uploadImage(ctx context.Context, w http.ResponseWriter, r *http.Request, laptopID string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "expected a multipart form: %v", err))
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeError(w, status.Error(codes.InvalidArgument, `missing the "image" file field`))
+			return
+		}
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err))
+			return
+		}
+
+		if part.FormName() == "image" {
+			gateway.sendImage(ctx, w, laptopID, filepath.Ext(part.FileName()), part)
+			return
+		}
+	}
+}
+
+func (gateway *Gateway) sendImage(ctx context.Context, w http.ResponseWriter, laptopID string, imageType string, image io.Reader) {
+	// cancelling the call on a broken upload keeps the server from saving part of the image
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := gateway.laptopClient.UploadImage(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptopID, ImageType: imageType},
+		},
+	})
+
+	buffer := make([]byte, uploadChunkSize)
+	for err == nil {
+		n, readErr := image.Read(buffer)
+		if n > 0 {
+			err = stream.Send(&pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_ChunkData{ChunkData: buffer[:n]},
+			})
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read image: %v", readErr))
+			return
+		}
+	}
+
+	// a failed send is explained by the status of the stream
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (gateway *Gateway) rateLaptop(ctx context.Context, w http.ResponseWriter, r *http.Request, laptopID string) {
+	req := &pb.RateLaptopRequest{}
+	err := readJSON(w, r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.LaptopId = laptopID
+
+	stream, err := gateway.laptopClient.RateLaptop(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = stream.Send(req)
+	if err == nil {
+		err = stream.CloseSend()
+	}
+
+	// a failed send is explained by the status of the stream
+	res, recvErr := stream.Recv()
+	if recvErr != nil {
+		err = recvErr
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (gateway *Gateway) downloadImage(ctx context.Context, w http.ResponseWriter, imageID string) {
+	stream, err := gateway.laptopClient.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageID})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := stream.Recv()
+	if err == io.EOF {
+		err = status.Error(codes.Internal, "image stream ended without info")
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	contentType := mime.TypeByExtension(res.GetInfo().GetImageType())
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// the status line is sent already, so the connection is
+			// aborted and the client sees a truncated body
+			slog.WarnContext(ctx, "cannot download image", "image_id", imageID, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+
+		_, err = w.Write(res.GetChunkData())
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseFilter reads the search filter from the query. Without max_price_usd
+// the price is not limited.
+func parseFilter(r *http.Request) (*pb.Filter, error) {
+	query := r.URL.Query()
+	filter := &pb.Filter{MaxPriceUsd: math.MaxFloat64}
+
+	var err error
+	parseFloat := func(name string, target *float64) {
+		value := query.Get(name)
+		if len(value) == 0 || err != nil {
+			return
+		}
+
+		*target, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			err = status.Errorf(codes.InvalidArgument, "invalid %s: %v", name, err)
+		}
+	}
+	parseFloat("max_price_usd", &filter.MaxPriceUsd)
+	parseFloat("min_cpu_ghz", &filter.MinCpuGhz)
+
+	if value := query.Get("min_cpu_cores"); len(value) > 0 && err == nil {
+		cores, parseErr := strconv.ParseUint(value, 10, 32)
+		if parseErr != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid min_cpu_cores: %v", parseErr)
+		}
+		filter.MinCpuCores = uint32(cores)
+	}
+
+	if value := query.Get("min_ram"); len(value) > 0 && err == nil {
+		filter.MinRam, err = parseMemory(value)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseMemory parses sizes like 8GIGABYTE or 512MEGABYTE
+func parseMemory(value string) (*pb.Memory, error) {
+	end := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if end <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid min_ram %q, expected a number and a unit like 8GIGABYTE", value)
+	}
+
+	size, err := strconv.ParseUint(value[:end], 10, 64)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid min_ram: %v", err)
+	}
+
+	unit, ok := pb.Memory_Unit_value[strings.ToUpper(value[end:])]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown memory unit in min_ram %q", value)
+	}
+
+	return &pb.Memory{Value: size, Unit: pb.Memory_Unit(unit)}, nil
+}
+
+// outgoingContext passes the forwarded headers and the client address of r on as gRPC metadata
+func outgoingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, header := range forwardedHeaders {
+		if values := r.Header.Values(header); len(values) > 0 {
+			md.Set(header, values...)
+		}
+	}
+	// the client address is always set here, so a client cannot send its own
+	md.Set(service.ForwardedPeerHeader, r.RemoteAddr)
+
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, message proto.Message) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read body: %v", err)
+	}
+
+	err = serializer.JSONToProtobufMessage(string(body), message)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid JSON body: %v", err)
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, message proto.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	writeLine(w, message)
+}
+
+// writeLine writes the message as one line of JSON
+func writeLine(w io.Writer, message proto.Message) error {
+	line, err := serializer.ProtobufToJSONLine(message)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %T: %w", message, err)
+	}
+
+	_, err = io.WriteString(w, line+"\n")
+	return err
+}
+
+// errorBody is the JSON body of a failed call. Every detail is the JSON of
+// a google.protobuf.Any, with its type in "@type".
+type errorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+func newErrorBody(st *status.Status) errorBody {
+	body := errorBody{Code: st.Code().String(), Message: st.Message()}
+	for _, detail := range st.Proto().GetDetails() {
+		line, err := serializer.ProtobufToJSONLine(detail)
+		if err != nil {
+			slog.Warn("cannot marshal error detail", "type", detail.GetTypeUrl(), "error", err)
+			continue
+		}
+		body.Details = append(body.Details, json.RawMessage(line))
+	}
+
+	return body
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeStatus(w, HTTPStatus(st.Code()), st)
+}
+
+func writeStatus(w http.ResponseWriter, code int, st *status.Status) {
+	w.Header().Set("Content-Type", "application/json")
+	if code == http.StatusTooManyRequests {
+		setRetryAfter(w, st)
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(newErrorBody(st))
+}
+
+// setRetryAfter sets the Retry-After header, in whole seconds rounded up,
+// from the RetryInfo detail of st if there is one
+func setRetryAfter(w http.ResponseWriter, st *status.Status) {
+	for _, detail := range st.Details() {
+		retryInfo, ok := detail.(*errdetails.RetryInfo)
+		if !ok {
+			continue
+		}
+
+		seconds := math.Ceil(retryInfo.GetRetryDelay().AsDuration().Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(seconds, 1))))
+		return
+	}
+}
+
+// writeErrorLine ends a newline-delimited stream with the error
+func writeErrorLine(w io.Writer, err error) {
+	st := status.Convert(err)
+	json.NewEncoder(w).Encode(map[string]errorBody{
+		"error": newErrorBody(st),
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeStatus(w, http.StatusMethodNotAllowed, status.New(codes.Unimplemented, "method not allowed"))
+}
+
+// HTTPStatus maps a gRPC status code to the closest HTTP status
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,397 @@
+package gateway_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"laptop-app-using-grpc/gateway"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"laptop-app-using-grpc/service"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGatewayCreateAndSearchLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := startTestGateway(t, laptopStore, service.NewDiskImageStore(t.TempDir()))
+
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+	laptop.PriceUsd = 1500
+	body, err := serializer.ProtobufToJSON(laptop)
+	require.NoError(t, err)
+
+	res, err := http.Post(server.URL+"/v1/laptops", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	created := &pb.CreateLaptopResponse{}
+	requireJSONBody(t, res, created)
+	require.NotEmpty(t, created.GetId())
+
+	cheap := sample.NewLaptop()
+	cheap.PriceUsd = 500
+	require.NoError(t, laptopStore.Save(cheap))
+
+	res, err = http.Get(server.URL + "/v1/laptops?max_price_usd=1000")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], `"price_usd":500`)
+	require.Contains(t, lines[0], `"layout":"`)
+
+	found := &pb.Laptop{}
+	require.NoError(t, serializer.JSONToProtobufMessage(lines[0], found))
+	require.Equal(t, cheap.GetId(), found.GetId())
+}
+
+func TestGatewayUploadDownloadAndRate(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := startTestGateway(t, laptopStore, service.NewDiskImageStore(t.TempDir()))
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	image := bytes.Repeat([]byte("laptop image "), 10000)
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	part, err := writer.CreateFormFile("image", "laptop.jpg")
+	require.NoError(t, err)
+	_, err = part.Write(image)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	res, err := http.Post(server.URL+"/v1/laptops/"+laptop.GetId()+"/images", writer.FormDataContentType(), form)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	uploaded := &pb.UploadImageResponse{}
+	requireJSONBody(t, res, uploaded)
+	require.EqualValues(t, len(image), uploaded.GetSize())
+
+	res, err = http.Get(server.URL + "/v1/images/" + uploaded.GetId())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+	downloaded, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, image, downloaded)
+
+	res, err = http.Post(server.URL+"/v1/laptops/"+laptop.GetId()+"/ratings", "application/json", strings.NewReader(`{"score": 8}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	rated := &pb.RateLaptopResponse{}
+	requireJSONBody(t, res, rated)
+	require.Equal(t, laptop.GetId(), rated.GetLaptopId())
+	require.EqualValues(t, 1, rated.GetRatedCount())
+	require.Equal(t, 8.0, rated.GetAverageScore())
+}
+
+func TestGatewayErrors(t *testing.T) {
+	t.Parallel()
+
+	server := startTestGateway(t, service.NewInMemoryLaptopStore(), service.NewDiskImageStore(t.TempDir()))
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		status string
+	}{
+		{"invalid json", http.MethodPost, "/v1/laptops", "{", http.StatusBadRequest, "InvalidArgument"},
+		{"invalid filter", http.MethodGet, "/v1/laptops?min_ram=8PETABYTE", "", http.StatusBadRequest, "InvalidArgument"},
+		{"missing image", http.MethodGet, "/v1/images/unknown", "", http.StatusNotFound, "NotFound"},
+		{"missing laptop", http.MethodPost, "/v1/laptops/unknown/ratings", `{"score": 8}`, http.StatusNotFound, "NotFound"},
+		{"unknown route", http.MethodGet, "/v1/cpus", "", http.StatusNotFound, "NotFound"},
+		{"wrong method", http.MethodDelete, "/v1/laptops", "", http.StatusMethodNotAllowed, "Unimplemented"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequest(tc.method, server.URL+tc.path, strings.NewReader(tc.body))
+			require.NoError(t, err)
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, tc.code, res.StatusCode)
+
+			var body struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			require.Equal(t, tc.status, body.Code)
+			require.NotEmpty(t, body.Message)
+		})
+	}
+}
+
+func TestGatewayErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewRateLimiter(map[string]service.RateLimit{
+		service.DefaultRateLimitKey: {Rate: 0.1, Burst: 1},
+	}, 0)
+	server := startTestGateway(t, service.NewInMemoryLaptopStore(), nil, grpc.UnaryInterceptor(limiter.Unary()))
+
+	// readError returns the code of the error body and the types of its details
+	readError := func(res *http.Response) (string, []interface{}) {
+		var body struct {
+			Code    string                   `json:"code"`
+			Details []map[string]interface{} `json:"details"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+
+		types := []interface{}{}
+		for _, detail := range body.Details {
+			types = append(types, detail["@type"])
+		}
+		return body.Code, types
+	}
+
+	// the field violations of an invalid laptop are in the details
+	laptop := sample.NewLaptop()
+	laptop.Ram = nil
+	laptopJSON, err := serializer.ProtobufToJSON(laptop)
+	require.NoError(t, err)
+
+	res, err := http.Post(server.URL+"/v1/laptops", "application/json", strings.NewReader(laptopJSON))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	code, types := readError(res)
+	require.Equal(t, "InvalidArgument", code)
+	require.Contains(t, types, "type.googleapis.com/google.rpc.BadRequest")
+
+	// the rate limited call says when to retry
+	res, err = http.Post(server.URL+"/v1/laptops", "application/json", strings.NewReader(laptopJSON))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	require.Equal(t, "10", res.Header.Get("Retry-After"))
+	code, types = readError(res)
+	require.Equal(t, "ResourceExhausted", code)
+	require.Contains(t, types, "type.googleapis.com/google.rpc.RetryInfo")
+}
+
+func TestGatewayLogin(t *testing.T) {
+	t.Parallel()
+
+	server := startTestGateway(t, service.NewInMemoryLaptopStore(), nil)
+
+	res, err := http.Post(server.URL+"/v1/login", "application/json", strings.NewReader(`{"username": "admin1", "password": "secret"}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	login := &pb.LoginResponse{}
+	requireJSONBody(t, res, login)
+	require.NotEmpty(t, login.GetAccessToken())
+
+	res, err = http.Post(server.URL+"/v1/login", "application/json", strings.NewReader(`{"username": "admin1", "password": "wrong"}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestGatewayForwardsClientAddress(t *testing.T) {
+	t.Parallel()
+
+	// the server behind the gateway records the peer of every call
+	forwardedPeer := service.NewForwardedPeerInterceptor()
+	peers := make(chan string, 1)
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		forwardedPeer.Unary(),
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			p, _ := peer.FromContext(ctx)
+			peers <- p.Addr.String()
+			return handler(ctx, req)
+		},
+	))
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil))
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	server := httptest.NewServer(gateway.NewGateway(pb.NewLaptopServiceClient(conn), pb.NewAuthServiceClient(conn)))
+	t.Cleanup(server.Close)
+
+	// the HTTP client remembers the address it calls from
+	clientAddresses := make(chan string, 1)
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+			if err == nil {
+				clientAddresses <- conn.LocalAddr().String()
+			}
+			return conn, err
+		},
+	}}
+
+	body, err := serializer.ProtobufToJSON(sample.NewLaptop())
+	require.NoError(t, err)
+	res, err := httpClient.Post(server.URL+"/v1/laptops", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	require.Equal(t, <-clientAddresses, <-peers)
+}
+
+func TestGatewaySearchClientStopsReading(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < 5000; i++ {
+		require.NoError(t, laptopStore.Save(sample.NewLaptop()))
+	}
+
+	// the server behind the gateway reports how its search ended and whether
+	// the stream was expired, which stops the blocked send, by then
+	searchEnded := make(chan [2]error, 1)
+	grpcServer := grpc.NewServer(
+		grpc.InTapHandle(service.ExpirableStreams),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := handler(srv, stream)
+			searchEnded <- [2]error{err, stream.Context().Err()}
+			return err
+		}),
+	)
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil, service.WithSearchSendTimeout(200*time.Millisecond))
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	// small windows and buffers all the way, so the search blocks soon
+	conn, err := grpc.Dial(
+		listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithInitialWindowSize(1<<16),
+		grpc.WithInitialConnWindowSize(1<<16),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	server := httptest.NewUnstartedServer(gateway.NewGateway(pb.NewLaptopServiceClient(conn), pb.NewAuthServiceClient(conn)))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conn.(*net.TCPConn).SetWriteBuffer(4 << 10)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+			if err == nil {
+				err = conn.(*net.TCPConn).SetReadBuffer(4 << 10)
+			}
+			return conn, err
+		},
+	}}
+
+	res, err := httpClient.Get(server.URL + "/v1/laptops")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the client takes one laptop and stops reading
+	_, err = bufio.NewReader(res.Body).ReadBytes('\n')
+	require.NoError(t, err)
+
+	select {
+	case errs := <-searchEnded:
+		require.Equal(t, codes.DeadlineExceeded, status.Code(errs[0]))
+		require.ErrorIs(t, errs[1], context.DeadlineExceeded)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "search goes on after the HTTP client stopped reading")
+	}
+}
+
+// startTestGateway serves the laptop service and the auth service, with the
+// user admin1 and password secret, behind a gateway
+func startTestGateway(t *testing.T, laptopStore service.LaptopStore, imageStore service.ImageStore, opts ...grpc.ServerOption) *httptest.Server {
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, service.NewInMemoryRatingStore())
+
+	userStore := service.NewInMemoryUserStore()
+	user, err := service.NewUser("admin1", "secret", service.RoleAdmin)
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+	authServer := service.NewAuthServer(userStore, service.NewJWTManager("secret", time.Minute))
+
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterAuthServiceServer(grpcServer, authServer)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	server := httptest.NewServer(gateway.NewGateway(pb.NewLaptopServiceClient(conn), pb.NewAuthServiceClient(conn)))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func requireJSONBody(t *testing.T, res *http.Response, message proto.Message) {
+	require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, serializer.JSONToProtobufMessage(string(body), message))
+}
@@ -0,0 +1,404 @@
+// Package grpcweb lets browsers call gRPC services with the gRPC-Web
+// protocol, without a separate proxy. The handler forwards every call to a
+// gRPC connection without decoding the messages, so it works for any unary
+// or server streaming method the connection serves. Both the binary
+// application/grpc-web and the base64 application/grpc-web-text formats
+// are understood.
+package grpcweb
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"laptop-app-using-grpc/service"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contentType     = "application/grpc-web"
+	contentTypeText = "application/grpc-web-text"
+)
+
+// flag of the frame that carries the trailers
+const trailerFlag = 0x80
+
+// the largest request message accepted
+const maxMessageSize = 4 << 20
+
+// forwardedHeaders are passed on to the call as metadata
+var forwardedHeaders = []string{"authorization", "x-api-key", "idempotency-key", "traceparent"}
+
+// allowedHeaders may be sent by a browser from another origin
+var allowedHeaders = append([]string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout"}, forwardedHeaders...)
+
+// streamDesc lets unary and server streaming calls share one code path
+var streamDesc = &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+
+// Handler is an HTTP handler that serves gRPC-Web calls through a gRPC connection
+type Handler struct {
+	conn           grpc.ClientConnInterface
+	allowedOrigins map[string]bool
+}
+
+// NewHandler returns a handler that calls conn. Browsers on other origins
+// may only call it from allowedOrigins, where * allows every origin.
+func NewHandler(conn grpc.ClientConnInterface, allowedOrigins []string) *Handler {
+	handler := &Handler{
+		conn:           conn,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, origin := range allowedOrigins {
+		handler.allowedOrigins[origin] = true
+	}
+
+	return handler
+}
+
+// IsGRPCWebRequest reports whether r is a gRPC-Web call or its CORS preflight
+func IsGRPCWebRequest(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return strings.Contains(strings.ToLower(r.Header.Get("Access-Control-Request-Headers")), "x-grpc-web")
+	}
+
+	return strings.HasPrefix(r.Header.Get("Content-Type"), contentType)
+}
+
+// ServeHTTP forwards the call in r and writes its responses and status as gRPC-Web frames
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !handler.allowOrigin(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(requestType, contentType) {
+		http.Error(w, "expected a gRPC-Web content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	text := strings.HasPrefix(requestType, contentTypeText)
+
+	var body io.Reader = r.Body
+	if text {
+		body = base64.NewDecoder(base64.StdEncoding, r.Body)
+	}
+
+	messages, err := readMessages(body)
+	if err != nil {
+		writeTrailersOnly(w, text, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	ctx, cancel, err := callContext(r)
+	if err != nil {
+		writeTrailersOnly(w, text, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	defer cancel()
+
+	stream, err := handler.conn.NewStream(ctx, streamDesc, r.URL.Path, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		writeTrailersOnly(w, text, status.Convert(err))
+		return
+	}
+
+	// a failed send is explained by the status of the stream
+	for i := 0; i < len(messages) && err == nil; i++ {
+		err = stream.SendMsg(&messages[i])
+	}
+	if err == nil {
+		stream.CloseSend()
+	}
+
+	// Header waits for the server, so its metadata can still go into the HTTP headers
+	header, err := stream.Header()
+	if err == nil {
+		writeMetadata(w.Header(), header)
+	}
+	w.Header().Set("Content-Type", responseType(text))
+	w.WriteHeader(http.StatusOK)
+
+	writer := newFrameWriter(w, text)
+	for {
+		var message []byte
+		err = stream.RecvMsg(&message)
+		if err != nil {
+			break
+		}
+
+		err = writer.write(0, message)
+		if err != nil {
+			// the browser went away, which cancels the call through its context
+			return
+		}
+	}
+
+	st := status.New(codes.OK, "")
+	if err != io.EOF {
+		st = status.Convert(err)
+	}
+	writer.write(trailerFlag, trailerBlock(st, stream.Trailer()))
+}
+
+// allowOrigin sets the CORS headers for a browser on another origin and
+// reports whether that origin may call the handler
+func (handler *Handler) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+
+	if !handler.allowedOrigins["*"] && !handler.allowedOrigins[origin] {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	w.Header().Set("Access-Control-Expose-Headers", "grpc-status, grpc-message")
+	return true
+}
+
+// readMessages reads the data frames of a request body
+func readMessages(body io.Reader) ([][]byte, error) {
+	reader := bufio.NewReader(body)
+	var messages [][]byte
+
+	for {
+		var prefix [5]byte
+		_, err := io.ReadFull(reader, prefix[:])
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read frame: %w", err)
+		}
+
+		if prefix[0] != 0 {
+			return nil, fmt.Errorf("unsupported frame flags %#x", prefix[0])
+		}
+
+		size := binary.BigEndian.Uint32(prefix[1:])
+		if size > maxMessageSize {
+			return nil, fmt.Errorf("message of %d bytes is larger than %d bytes", size, maxMessageSize)
+		}
+
+		message := make([]byte, size)
+		_, err = io.ReadFull(reader, message)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read frame: %w", err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+// callContext carries the forwarded headers, the client address and the grpc-timeout of r
+func callContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	md := metadata.MD{}
+	for _, header := range forwardedHeaders {
+		if values := r.Header.Values(header); len(values) > 0 {
+			md.Set(header, values...)
+		}
+	}
+	// the client address is always set here, so a client cannot send its own
+	md.Set(service.ForwardedPeerHeader, r.RemoteAddr)
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	value := r.Header.Get("grpc-timeout")
+	if len(value) == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// parseTimeout parses a grpc-timeout like 100m or 5S
+func parseTimeout(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+
+	unit, ok := units[value[len(value)-1]]
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if !ok || err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", value)
+	}
+
+	return time.Duration(amount) * unit, nil
+}
+
+func responseType(text bool) string {
+	if text {
+		return contentTypeText + "+proto"
+	}
+	return contentType + "+proto"
+}
+
+// writeTrailersOnly answers a call that failed before it started with the status in the headers
+func writeTrailersOnly(w http.ResponseWriter, text bool, st *status.Status) {
+	w.Header().Set("Content-Type", responseType(text))
+	w.Header().Set("grpc-status", strconv.Itoa(int(st.Code())))
+	w.Header().Set("grpc-message", encodeMessage(st.Message()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeMetadata copies the response metadata into HTTP headers
+func writeMetadata(header http.Header, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, encodeValue(key, value))
+		}
+	}
+}
+
+// trailerBlock formats the status and the trailers like HTTP/1 headers
+func trailerBlock(st *status.Status, trailer metadata.MD) []byte {
+	var block strings.Builder
+	fmt.Fprintf(&block, "grpc-status:%d\r\n", st.Code())
+	if len(st.Message()) > 0 {
+		fmt.Fprintf(&block, "grpc-message:%s\r\n", encodeMessage(st.Message()))
+	}
+
+	if details := st.Proto(); len(details.GetDetails()) > 0 {
+		data, err := proto.Marshal(details)
+		if err == nil {
+			fmt.Fprintf(&block, "grpc-status-details-bin:%s\r\n", base64.RawStdEncoding.EncodeToString(data))
+		}
+	}
+
+	keys := make([]string, 0, len(trailer))
+	for key := range trailer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range trailer[key] {
+			fmt.Fprintf(&block, "%s:%s\r\n", key, encodeValue(key, value))
+		}
+	}
+
+	return []byte(block.String())
+}
+
+// encodeValue encodes the values of binary metadata keys as base64
+func encodeValue(key string, value string) string {
+	if strings.HasSuffix(key, "-bin") {
+		return base64.RawStdEncoding.EncodeToString([]byte(value))
+	}
+	return value
+}
+
+// encodeMessage percent-encodes grpc-message like the gRPC HTTP/2 protocol
+func encodeMessage(message string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+			continue
+		}
+		encoded.WriteByte(c)
+	}
+
+	return encoded.String()
+}
+
+// frameWriter writes length-prefixed frames and flushes each one, so
+// streamed responses reach the browser as they arrive
+type frameWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	text    bool
+}
+
+func newFrameWriter(w http.ResponseWriter, text bool) *frameWriter {
+	flusher, _ := w.(http.Flusher)
+	return &frameWriter{w, flusher, text}
+}
+
+func (writer *frameWriter) write(flag byte, data []byte) error {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	copy(frame[5:], data)
+
+	// text frames are encoded one by one, padded, so each can be decoded as it arrives
+	if writer.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+
+	_, err := writer.w.Write(frame)
+	if err != nil {
+		return err
+	}
+
+	if writer.flusher != nil {
+		writer.flusher.Flush()
+	}
+	return nil
+}
+
+// rawCodec passes messages through as bytes, so the handler does not need their types
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T as raw bytes", v)
+	}
+	return *message, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal raw bytes into %T", v)
+	}
+	*message = append((*message)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,244 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"laptop-app-using-grpc/grpcweb"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const laptopServicePath = "/vyom1611.laptop_app.LaptopService/"
+
+const allowedOrigin = "https://app.example"
+
+func TestHandlerUnaryCall(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := startTestHandler(t, laptopStore)
+
+	laptop := sample.NewLaptop()
+	res := postCall(t, server, "CreateLaptop", "application/grpc-web+proto", &pb.CreateLaptopRequest{Laptop: laptop})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/grpc-web+proto", res.Header.Get("Content-Type"))
+
+	messages, trailer := readFrames(t, res.Body)
+	require.Len(t, messages, 1)
+	require.Equal(t, "0", trailer["grpc-status"])
+
+	created := &pb.CreateLaptopResponse{}
+	require.NoError(t, proto.Unmarshal(messages[0], created))
+	require.Equal(t, laptop.GetId(), created.GetId())
+	require.Equal(t, 1, laptopStore.Count())
+}
+
+func TestHandlerServerStreamingText(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := startTestHandler(t, laptopStore)
+
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		require.NoError(t, laptopStore.Save(laptop))
+	}
+	expensive := sample.NewLaptop()
+	expensive.PriceUsd = 5000
+	require.NoError(t, laptopStore.Save(expensive))
+
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 2000}}
+	res := postCall(t, server, "SearchLaptop", "application/grpc-web-text", req)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/grpc-web-text+proto", res.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	messages, trailer := readFrames(t, bytes.NewReader(decodeText(t, body)))
+	require.Len(t, messages, 3)
+	require.Equal(t, "0", trailer["grpc-status"])
+
+	for _, message := range messages {
+		found := &pb.SearchLaptopResponse{}
+		require.NoError(t, proto.Unmarshal(message, found))
+		require.NotEqual(t, expensive.GetId(), found.GetLaptop().GetId())
+	}
+}
+
+func TestHandlerErrorStatus(t *testing.T) {
+	t.Parallel()
+
+	server := startTestHandler(t, service.NewInMemoryLaptopStore())
+
+	laptop := sample.NewLaptop()
+	laptop.Id = "invalid-uuid"
+	res := postCall(t, server, "CreateLaptop", "application/grpc-web+proto", &pb.CreateLaptopRequest{Laptop: laptop})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	messages, trailer := readFrames(t, res.Body)
+	require.Empty(t, messages)
+	require.Equal(t, strconv.Itoa(int(codes.InvalidArgument)), trailer["grpc-status"])
+	require.NotEmpty(t, trailer["grpc-message"])
+
+	res = postCall(t, server, "DeleteLaptop", "application/grpc-web+proto", &pb.CreateLaptopRequest{})
+	_, trailer = readFrames(t, res.Body)
+	require.Equal(t, strconv.Itoa(int(codes.Unimplemented)), trailer["grpc-status"])
+
+	// a broken frame fails before the call, so the status is in the headers
+	req, err := http.NewRequest(http.MethodPost, server.URL+laptopServicePath+"CreateLaptop", bytes.NewReader([]byte{0, 0, 0, 0, 9, 1}))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, strconv.Itoa(int(codes.InvalidArgument)), res.Header.Get("grpc-status"))
+}
+
+func TestHandlerCORS(t *testing.T) {
+	t.Parallel()
+
+	server := startTestHandler(t, service.NewInMemoryLaptopStore())
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, server.URL+laptopServicePath+"SearchLaptop", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,authorization")
+		require.True(t, grpcweb.IsGRPCWebRequest(req))
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	res := preflight(allowedOrigin)
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Equal(t, allowedOrigin, res.Header.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, http.MethodPost, res.Header.Get("Access-Control-Allow-Methods"))
+	require.Contains(t, res.Header.Get("Access-Control-Allow-Headers"), "x-grpc-web")
+	require.Contains(t, res.Header.Get("Access-Control-Allow-Headers"), "authorization")
+
+	res = preflight("https://evil.example")
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+
+	req := newCallRequest(t, server, "CreateLaptop", "application/grpc-web+proto", &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	req.Header.Set("Origin", allowedOrigin)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, allowedOrigin, res.Header.Get("Access-Control-Allow-Origin"))
+	require.Contains(t, res.Header.Get("Access-Control-Expose-Headers"), "grpc-status")
+}
+
+func startTestHandler(t *testing.T, laptopStore service.LaptopStore) *httptest.Server {
+	laptopServer := service.NewLaptopServer(laptopStore, service.NewDiskImageStore(t.TempDir()), service.NewInMemoryRatingStore())
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	server := httptest.NewServer(grpcweb.NewHandler(conn, []string{allowedOrigin}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newCallRequest(t *testing.T, server *httptest.Server, method string, contentType string, message proto.Message) *http.Request {
+	data, err := proto.Marshal(message)
+	require.NoError(t, err)
+
+	body := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(data)))
+	copy(body[5:], data)
+	if strings.HasPrefix(contentType, "application/grpc-web-text") {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+laptopServicePath+method, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+
+	return req
+}
+
+func postCall(t *testing.T, server *httptest.Server, method string, contentType string, message proto.Message) *http.Response {
+	res, err := http.DefaultClient.Do(newCallRequest(t, server, method, contentType, message))
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+// readFrames splits a response body into its messages and the trailers of the last frame
+func readFrames(t *testing.T, body io.Reader) ([][]byte, map[string]string) {
+	var messages [][]byte
+	for {
+		var prefix [5]byte
+		_, err := io.ReadFull(body, prefix[:])
+		require.NoError(t, err, "the body must end with a trailer frame")
+
+		data := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		_, err = io.ReadFull(body, data)
+		require.NoError(t, err)
+
+		if prefix[0]&0x80 == 0 {
+			messages = append(messages, data)
+			continue
+		}
+
+		trailer := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\r\n") {
+			key, value, found := strings.Cut(line, ":")
+			require.True(t, found, "invalid trailer line %q", line)
+			trailer[key] = value
+		}
+
+		rest, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.Empty(t, rest, "the trailer frame must be the last one")
+
+		return messages, trailer
+	}
+}
+
+// decodeText decodes a text body, made of separately padded base64 chunks
+func decodeText(t *testing.T, body []byte) []byte {
+	require.Zero(t, len(body)%4)
+
+	var decoded []byte
+	for i := 0; i < len(body); i += 4 {
+		quantum, err := base64.StdEncoding.DecodeString(string(body[i : i+4]))
+		require.NoError(t, err)
+		decoded = append(decoded, quantum...)
+	}
+
+	return decoded
+}
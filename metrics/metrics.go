@@ -0,0 +1,307 @@
+// Package metrics keeps counters, histograms and gauges in memory and writes
+// them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector is a metric family that can write itself
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds every metric that is exported
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// NewCounterVec registers a counter with the given label names
+func (registry *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{
+		family: newFamily(name, help, "counter", labels),
+		values: make(map[string]*counterValue),
+	}
+	registry.register(counter)
+
+	return counter
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds and label names
+func (registry *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	histogram := &HistogramVec{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: sorted,
+		values:  make(map[string]*histogramValue),
+	}
+	registry.register(histogram)
+
+	return histogram
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn at scrape time
+func (registry *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	registry.register(&gaugeFunc{
+		family: newFamily(name, help, "gauge", nil),
+		fn:     fn,
+	})
+}
+
+// Write writes every metric in the text exposition format
+func (registry *Registry) Write(w io.Writer) error {
+	registry.mutex.RLock()
+	collectors := append([]collector(nil), registry.collectors...)
+	registry.mutex.RUnlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	writer := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(writer)
+	}
+
+	return writer.Flush()
+}
+
+// Handler returns an HTTP handler that serves the metrics
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		err := registry.Write(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func (registry *Registry) register(c collector) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.names[c.name()] {
+		panic(fmt.Sprintf("metric %s is already registered", c.name()))
+	}
+
+	registry.names[c.name()] = true
+	registry.collectors = append(registry.collectors, c)
+}
+
+// family is the name, help text, type and label names of a metric
+type family struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func newFamily(name string, help string, kind string, labels []string) family {
+	return family{name, help, kind, append([]string(nil), labels...)}
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.kind)
+}
+
+// key joins label values into a map key, the values are escaped so it is unambiguous
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+
+	return f.formatLabels(values, "", "")
+}
+
+// formatLabels renders {a="x",b="y"} with an optional extra label
+func (f *family) formatLabels(values []string, extraName string, extraValue string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, label := range f.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escapeLabel(values[i])))
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, escapeLabel(extraValue)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	family
+	mutex  sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// Add adds delta to the counter with the given label values
+func (counter *CounterVec) Add(delta float64, labelValues ...string) {
+	key := counter.key(labelValues)
+
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	value := counter.values[key]
+	if value == nil {
+		value = &counterValue{labels: append([]string(nil), labelValues...)}
+		counter.values[key] = value
+	}
+	value.value += delta
+}
+
+// Inc adds one to the counter with the given label values
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *CounterVec) write(w *bufio.Writer) {
+	counter.writeHeader(w)
+
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	for _, key := range sortedKeys(counter.values) {
+		value := counter.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", counter.metricName, key, formatFloat(value.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	family
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records a value in the histogram with the given label values
+func (histogram *HistogramVec) Observe(observed float64, labelValues ...string) {
+	key := histogram.key(labelValues)
+
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	value := histogram.values[key]
+	if value == nil {
+		value = &histogramValue{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(histogram.buckets)),
+		}
+		histogram.values[key] = value
+	}
+
+	for i, bound := range histogram.buckets {
+		if observed <= bound {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += observed
+}
+
+func (histogram *HistogramVec) write(w *bufio.Writer) {
+	histogram.writeHeader(w)
+
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	for _, key := range sortedKeys(histogram.values) {
+		value := histogram.values[key]
+		for i, bound := range histogram.buckets {
+			labels := histogram.formatLabels(value.labels, "le", formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.metricName, labels, value.counts[i])
+		}
+
+		labels := histogram.formatLabels(value.labels, "le", "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.metricName, labels, value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogram.metricName, key, formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogram.metricName, key, value.count)
+	}
+}
+
+// gaugeFunc is a gauge without labels that is read at scrape time
+type gaugeFunc struct {
+	family
+	fn func() float64
+}
+
+func (gauge *gaugeFunc) write(w *bufio.Writer) {
+	gauge.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", gauge.metricName, formatFloat(gauge.fn()))
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+func escapeHelp(help string) string {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	return strings.ReplaceAll(help, "\n", `\n`)
+}
@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"laptop-app-using-grpc/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounterVec("calls_total", "Total calls.", "method", "code")
+	histogram := registry.NewHistogramVec("call_seconds", "Call latency.", []float64{1, 0.1}, "method")
+	registry.NewGaugeFunc("items", "Number of items.", func() float64 { return 42 })
+
+	counter.Inc("Get", "OK")
+	counter.Add(2, "Get", "OK")
+	counter.Inc("Put", "Internal")
+	counter.Inc(`say "hi"`, "OK")
+	histogram.Observe(0.05, "Get")
+	histogram.Observe(0.5, "Get")
+	histogram.Observe(3, "Get")
+
+	var output bytes.Buffer
+	require.NoError(t, registry.Write(&output))
+
+	expected := `# HELP call_seconds Call latency.
+# TYPE call_seconds histogram
+call_seconds_bucket{method="Get",le="0.1"} 1
+call_seconds_bucket{method="Get",le="1"} 2
+call_seconds_bucket{method="Get",le="+Inf"} 3
+call_seconds_sum{method="Get"} 3.55
+call_seconds_count{method="Get"} 3
+# HELP calls_total Total calls.
+# TYPE calls_total counter
+calls_total{method="Get",code="OK"} 3
+calls_total{method="Put",code="Internal"} 1
+calls_total{method="say \"hi\"",code="OK"} 1
+# HELP items Number of items.
+# TYPE items gauge
+items 42
+`
+	require.Equal(t, expected, output.String())
+}
+
+func TestRegistryHandler(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("calls_total", "Total calls.", "method").Inc("Get")
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+	require.Contains(t, recorder.Body.String(), `calls_total{method="Get"} 1`)
+}
+
+func TestRegistryDuplicateName(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("calls_total", "Total calls.")
+
+	require.Panics(t, func() {
+		registry.NewGaugeFunc("calls_total", "Total calls.", func() float64 { return 0 })
+	})
+}
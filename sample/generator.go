@@ -1,7 +1,7 @@
 package sample
 
 import (
-	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"laptop-app-using-grpc/pb/pb"
 )
 
@@ -127,7 +127,7 @@ func NewLaptop() *pb.Laptop {
 		},
 		PriceUsd:    randomFloat64(1000, 3000),
 		ReleaseYear: uint32(randomInt(2012, 2020)),
-		UpdatedAt:   ptypes.TimestampNow(),
+		UpdatedAt:   timestamppb.Now(),
 	}
 
 	return laptop
@@ -0,0 +1,82 @@
+package serializer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"path/filepath"
+)
+
+// Compression is the compression of a message stream
+type Compression string
+
+const (
+	NoCompression Compression = ""
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+// CompressionFromFilename returns the compression of a file by its
+// extension, .gz for gzip and .zst for zstd
+func CompressionFromFilename(filename string) Compression {
+	switch filepath.Ext(filename) {
+	case ".gz":
+		return Gzip
+	case ".zst":
+		return Zstd
+	default:
+		return NoCompression
+	}
+}
+
+// NewCompressedWriter returns a writer that compresses into w. Closing it
+// writes the end of the compressed data but does not close w.
+func NewCompressedWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case NoCompression:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create zstd writer: %w", err)
+		}
+		return encoder, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+// NewDecompressedReader returns a reader that decompresses r. Closing it
+// releases the decompressor but does not close r.
+func NewDecompressedReader(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case NoCompression:
+		return io.NopCloser(r), nil
+	case Gzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read gzip header: %w", err)
+		}
+		return reader, nil
+	case Zstd:
+		// a single decoding goroutine keeps the memory of large streams low
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create zstd reader: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -2,19 +2,37 @@ package serializer
 
 import (
 	"fmt"
-	"github.com/golang/protobuf/proto"
-	"io/ioutil"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-func WriteProtobufToJSONFile(message proto.Message, filename string) error {
-	data, err := ProtobufToJSON(message)
+//Write protocol buffer to JSON file, formatted like ProtobufToJSON
+func WriteProtobufToJSONFile(message proto.Message, filename string, opts ...JSONOption) error {
+	data, err := ProtobufToJSON(message, opts...)
 	if err != nil {
-		return fmt.Errorf("Cannot marshal proto message to JSON")
+		return fmt.Errorf("Cannot marshal proto message to JSON: %w", err)
 	}
 
-	err = ioutil.WriteFile(filename, []byte(data), 0644)
+	err = os.WriteFile(filename, []byte(data), 0644)
 	if err != nil {
-		return fmt.Errorf("Cannot write JSON to file")
+		return fmt.Errorf("Cannot write JSON to file: %w", err)
+	}
+
+	return nil
+}
+
+//Read protocol buffer from JSON file
+func ReadProtobufFromJSONFile(filename string, message proto.Message) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Cannot read JSON data from file: %w", err)
+	}
+
+	err = JSONToProtobufMessage(string(data), message)
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal JSON to proto message: %w", err)
 	}
 
 	return nil
@@ -24,25 +42,83 @@ func WriteProtobufToJSONFile(message proto.Message, filename string) error {
 func WriteProtobufToBinaryFile(message proto.Message, filename string) error {
 	data, err := proto.Marshal(message)
 	if err != nil {
-		return fmt.Errorf("Cannot marshal proto message to binary")
+		return fmt.Errorf("Cannot marshal proto message to binary: %w", err)
 	}
-	err = ioutil.WriteFile(filename, data, 0644)
+	err = os.WriteFile(filename, data, 0644)
 	if err != nil {
-		return fmt.Errorf("Cannot write proto message to file")
+		return fmt.Errorf("Cannot write proto message to file: %w", err)
 	}
 
 	return nil
 }
 
 func ReadProtobufFromBinaryFile(filename string, message proto.Message) error {
-	data, err := ioutil.ReadFile(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return fmt.Errorf("Cannot read binary data from file")
+		return fmt.Errorf("Cannot read binary data from file: %w", err)
 	}
 
 	err = proto.Unmarshal(data, message)
 	if err != nil {
-		return fmt.Errorf("Cannot unmarshal binary to proto message")
+		return fmt.Errorf("Cannot unmarshal binary to proto message: %w", err)
+	}
+
+	return nil
+}
+
+// IsSpecFile reports whether a file is a YAML or TOML spec by its extension
+func IsSpecFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml", ".toml":
+		return true
+	default:
+		return false
+	}
+}
+
+// WriteProtobufToSpecFile writes protocol buffer to a YAML or TOML spec file,
+// chosen by the extension of the file
+func WriteProtobufToSpecFile(message proto.Message, filename string) error {
+	var data string
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		data, err = ProtobufToYAML(message)
+	case ".toml":
+		data, err = ProtobufToTOML(message)
+	default:
+		return fmt.Errorf("%s is not a .yaml, .yml or .toml spec file", filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filename, []byte(data), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write spec to file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadProtobufFromSpecFile reads protocol buffer from a YAML or TOML spec
+// file, chosen by the extension of the file
+func ReadProtobufFromSpecFile(filename string, message proto.Message) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("cannot read spec file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = YAMLToProtobufMessage(string(data), message)
+	case ".toml":
+		err = TOMLToProtobufMessage(string(data), message)
+	default:
+		return fmt.Errorf("%s is not a .yaml, .yml or .toml spec file", filename)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
 	}
 
 	return nil
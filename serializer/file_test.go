@@ -28,4 +28,9 @@ func TestFileSerializer(t *testing.T) {
 
 	err = serializer.WriteProtobufToJSONFile(laptop1, jsonFile)
 	require.NoError(t, err)
+
+	laptop3 := &pb.Laptop{}
+	err = serializer.ReadProtobufFromJSONFile(jsonFile, laptop3)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop1, laptop3))
 }
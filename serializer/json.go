@@ -1,22 +1,143 @@
 package serializer
 
 import (
-	"github.com/golang/protobuf/jsonpb"
-	"github.com/golang/protobuf/proto"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
-func ProtobufToJSON(message proto.Message) (string, error) {
-	marshaller := jsonpb.Marshaler{
-		EnumsAsInts:  false,
-		EmitDefaults: true,
-		Indent:       " ",
-		OrigName:     true,
+// JSONOption changes how a message is converted to JSON. By default fields
+// have their proto names, enums are written by name and fields that are not
+// set are written with their default values.
+type JSONOption func(options *jsonOptions)
+
+type jsonOptions struct {
+	indent  string
+	marshal protojson.MarshalOptions
+}
+
+// WithIndent writes every field on its own line, indented by indent for
+// each level, or the whole message on one line if indent is empty
+func WithIndent(indent string) JSONOption {
+	return func(options *jsonOptions) {
+		options.indent = indent
+	}
+}
+
+// WithEnumNumbers writes enums as numbers instead of names
+func WithEnumNumbers(enumNumbers bool) JSONOption {
+	return func(options *jsonOptions) {
+		options.marshal.UseEnumNumbers = enumNumbers
+	}
+}
+
+// WithEmitUnpopulated writes the fields that are not set, with their
+// default value or null for messages
+func WithEmitUnpopulated(emitUnpopulated bool) JSONOption {
+	return func(options *jsonOptions) {
+		options.marshal.EmitUnpopulated = emitUnpopulated
+	}
+}
+
+// WithProtoNames names fields like cpu_cores in the proto file, or in
+// lowerCamelCase like cpuCores if false
+func WithProtoNames(protoNames bool) JSONOption {
+	return func(options *jsonOptions) {
+		options.marshal.UseProtoNames = protoNames
+	}
+}
+
+// ProtobufToJSON converts a protocol buffer message to JSON, indented by one
+// space unless the options say otherwise
+func ProtobufToJSON(message proto.Message, opts ...JSONOption) (string, error) {
+	data, err := marshalJSON(message, append([]JSONOption{WithIndent(" ")}, opts...)...)
+	return string(data), err
+}
+
+// ProtobufToJSONLine converts a protocol buffer message to JSON on a single
+// line, with the same field names and enums as ProtobufToJSON
+func ProtobufToJSONLine(message proto.Message, opts ...JSONOption) (string, error) {
+	data, err := marshalJSON(message, append(append([]JSONOption{}, opts...), WithIndent(""))...)
+	return string(data), err
+}
+
+// marshalJSON converts a message to JSON formatted like encoding/json, as
+// the deprecated jsonpb package did. protojson adds random whitespace so
+// that nobody relies on its output, which is compacted and indented again.
+func marshalJSON(message proto.Message, opts ...JSONOption) ([]byte, error) {
+	options := &jsonOptions{
+		marshal: protojson.MarshalOptions{
+			UseProtoNames:   true,
+			EmitUnpopulated: true,
+		},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	data, err := options.marshal.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal proto message to JSON: %w", err)
+	}
+
+	var compact bytes.Buffer
+	err = json.Compact(&compact, data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compact JSON: %w", err)
+	}
+
+	// encoding/json escapes <, > and & in strings, protojson does not
+	var escaped bytes.Buffer
+	json.HTMLEscape(&escaped, compact.Bytes())
+	if len(options.indent) == 0 {
+		return escaped.Bytes(), nil
+	}
+
+	var indented bytes.Buffer
+	err = json.Indent(&indented, escaped.Bytes(), "", options.indent)
+	if err != nil {
+		return nil, fmt.Errorf("cannot indent JSON: %w", err)
+	}
+
+	return expandEmptyArrays(indented.Bytes()), nil
+}
+
+// expandEmptyArrays writes the empty arrays of indented JSON over two lines,
+// with the closing bracket at the indentation of the opening line, as jsonpb did
+func expandEmptyArrays(data []byte) []byte {
+	var out bytes.Buffer
+	lineStart := 0
+	inString := false
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString && c == '\\':
+			out.WriteByte(c)
+			i++
+			c = data[i]
+		case c == '"':
+			inString = !inString
+		case c == '\n':
+			lineStart = i + 1
+		case !inString && c == '[' && i+1 < len(data) && data[i+1] == ']':
+			indent := data[lineStart:i]
+			indent = indent[:len(indent)-len(bytes.TrimLeft(indent, " \t"))]
+
+			out.WriteString("[\n")
+			out.Write(indent)
+			continue
+		}
+
+		out.WriteByte(c)
 	}
 
-	return marshaller.MarshalToString(message)
+	return out.Bytes()
 }
 
 // JSONToProtobufMessage converts JSON string to protocol buffer message
 func JSONToProtobufMessage(data string, message proto.Message) error {
-	return jsonpb.UnmarshalString(data, message)
+	return protojson.Unmarshal([]byte(data), message)
 }
@@ -0,0 +1,95 @@
+package serializer_test
+
+import (
+	"github.com/golang/protobuf/jsonpb"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"math"
+	"strings"
+	"testing"
+)
+
+// the JSON the serializer wrote before it moved from jsonpb to protojson
+func jsonpbJSON(t *testing.T, message proto.Message, indent string) string {
+	marshaler := &jsonpb.Marshaler{EmitDefaults: true, Indent: indent, OrigName: true}
+	data, err := marshaler.MarshalToString(protov1.MessageV1(message))
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestProtobufToJSONMatchesJSONPB(t *testing.T) {
+	t.Parallel()
+
+	var messages []proto.Message
+	for i := 0; i < 100; i++ {
+		messages = append(messages, sample.NewLaptop())
+	}
+
+	laptop := sample.NewLaptop()
+	laptop.Brand = "<Dell> & Co"
+	laptop.Name = "XPS   \"15\" [] \\ ünïcode"
+	laptop.Storages = nil
+	laptop.Cpu = nil
+	laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 1e21}
+	laptop.PriceUsd = math.NaN()
+	laptop.Gpus[0].MinGhz = 1e-7
+	laptop.Keyboard.Layout = 42
+	laptop.UpdatedAt = nil
+	messages = append(messages, laptop, &pb.Laptop{}, &pb.ExportLaptopsResponse{
+		Laptop: sample.NewLaptop(),
+		Rating: &pb.LaptopRating{RatedCount: 3, AverageScore: 7.333333333333333},
+		Images: []*pb.ImageReference{{ImageId: "image", ImageType: ".png", Size: math.MaxUint64}},
+	})
+
+	for _, message := range messages {
+		data, err := serializer.ProtobufToJSON(message)
+		require.NoError(t, err)
+		require.Equal(t, jsonpbJSON(t, message, " "), data)
+
+		line, err := serializer.ProtobufToJSONLine(message)
+		require.NoError(t, err)
+		require.Equal(t, jsonpbJSON(t, message, ""), line)
+	}
+}
+
+func TestProtobufToJSONOptions(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Gpus = nil
+	laptop.Keyboard.Layout = pb.Keyboard_QWERTY
+
+	data, err := serializer.ProtobufToJSON(laptop, serializer.WithIndent("  "))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(data, "{\n  \"id\": "), data)
+
+	line, err := serializer.ProtobufToJSONLine(laptop, serializer.WithEnumNumbers(true))
+	require.NoError(t, err)
+	require.Contains(t, line, `"layout":1`)
+
+	line, err = serializer.ProtobufToJSONLine(laptop, serializer.WithEmitUnpopulated(false))
+	require.NoError(t, err)
+	require.NotContains(t, line, `"gpus"`)
+	require.Contains(t, line, `"layout":"QWERTY"`)
+
+	line, err = serializer.ProtobufToJSONLine(laptop, serializer.WithProtoNames(false))
+	require.NoError(t, err)
+	require.Contains(t, line, `"cpuCores":`)
+	require.NotContains(t, line, `"cpu_cores":`)
+
+	// every naming and enum format is read back
+	other := &pb.Laptop{}
+	require.NoError(t, serializer.JSONToProtobufMessage(line, other))
+	require.True(t, proto.Equal(laptop, other))
+
+	line, err = serializer.ProtobufToJSONLine(laptop, serializer.WithEnumNumbers(true), serializer.WithProtoNames(false))
+	require.NoError(t, err)
+	other = &pb.Laptop{}
+	require.NoError(t, serializer.JSONToProtobufMessage(line, other))
+	require.True(t, proto.Equal(laptop, other))
+}
@@ -0,0 +1,599 @@
+package serializer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"laptop-app-using-grpc/pb/pb"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec files are YAML or TOML documents written by hand. They follow the
+// schema of the message with proto field names, and differ from protobuf
+// JSON where that is easier to write:
+//   - fields that are not set are left out
+//   - enums are written by name, in any case, or by number
+//   - memory is a string like "16GB" or "512 GiB"
+//   - a oneof whose members are named like weight_kg and weight_lb is a
+//     string with the unit, like weight: 1.8 kg
+//   - timestamps are RFC 3339 strings or native date-times
+
+// specField is a field of a message in a spec, kept in field order
+type specField struct {
+	name  string
+	value interface{}
+}
+
+// specMessage is a message in a spec
+type specMessage []specField
+
+// memoryUnitSymbols are the units memory is written with
+var memoryUnitSymbols = map[pb.Memory_Unit]string{
+	pb.Memory_BIT:      "bit",
+	pb.Memory_BYTE:     "B",
+	pb.Memory_KILOBYTE: "KB",
+	pb.Memory_MEGABYTE: "MB",
+	pb.Memory_GIGABYTE: "GB",
+	pb.Memory_TERABYTE: "TB",
+}
+
+// memoryUnits are the units memory is read with, in upper case. The store
+// counts a kilobyte as 1024 bytes, so KB and KiB are the same unit.
+var memoryUnits = map[string]pb.Memory_Unit{
+	"BIT": pb.Memory_BIT, "BITS": pb.Memory_BIT,
+	"B": pb.Memory_BYTE, "BYTE": pb.Memory_BYTE, "BYTES": pb.Memory_BYTE,
+	"K": pb.Memory_KILOBYTE, "KB": pb.Memory_KILOBYTE, "KIB": pb.Memory_KILOBYTE, "KILOBYTE": pb.Memory_KILOBYTE, "KILOBYTES": pb.Memory_KILOBYTE,
+	"M": pb.Memory_MEGABYTE, "MB": pb.Memory_MEGABYTE, "MIB": pb.Memory_MEGABYTE, "MEGABYTE": pb.Memory_MEGABYTE, "MEGABYTES": pb.Memory_MEGABYTE,
+	"G": pb.Memory_GIGABYTE, "GB": pb.Memory_GIGABYTE, "GIB": pb.Memory_GIGABYTE, "GIGABYTE": pb.Memory_GIGABYTE, "GIGABYTES": pb.Memory_GIGABYTE,
+	"T": pb.Memory_TERABYTE, "TB": pb.Memory_TERABYTE, "TIB": pb.Memory_TERABYTE, "TERABYTE": pb.Memory_TERABYTE, "TERABYTES": pb.Memory_TERABYTE,
+}
+
+var memoryPattern = regexp.MustCompile(`^\s*([0-9]+)\s*([A-Za-z]+)\s*$`)
+
+var quantityPattern = regexp.MustCompile(`^\s*([-+]?[0-9.]+(?:[eE][-+]?[0-9]+)?)\s*([A-Za-z]+)\s*$`)
+
+var memoryDescriptor = (&pb.Memory{}).ProtoReflect().Descriptor()
+
+// ParseMemory reads memory written like "16GB", "512 GiB" or "1tb"
+func ParseMemory(text string) (*pb.Memory, error) {
+	match := memoryPattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil, fmt.Errorf("%q is not a memory size like 16GB", text)
+	}
+
+	value, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a memory size like 16GB: %w", text, err)
+	}
+
+	unit, ok := memoryUnits[strings.ToUpper(match[2])]
+	if !ok {
+		return nil, fmt.Errorf("%q has an unknown memory unit %q", text, match[2])
+	}
+
+	return &pb.Memory{Value: value, Unit: unit}, nil
+}
+
+// FormatMemory writes memory like "16GB", or returns false for an unknown unit
+func FormatMemory(memory *pb.Memory) (string, bool) {
+	symbol, ok := memoryUnitSymbols[memory.GetUnit()]
+	if !ok {
+		return "", false
+	}
+
+	return strconv.FormatUint(memory.GetValue(), 10) + symbol, true
+}
+
+// messageToSpec converts the fields of a message that are set
+func messageToSpec(message protoreflect.Message) specMessage {
+	var spec specMessage
+
+	fields := message.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if !message.Has(field) {
+			continue
+		}
+
+		value := message.Get(field)
+		if oneof, suffix, ok := quantityOneof(field); ok {
+			quantity := formatNumber(field, value) + " " + suffix
+			spec = append(spec, specField{name: string(oneof.Name()), value: quantity})
+			continue
+		}
+
+		spec = append(spec, specField{name: string(field.Name()), value: fieldToSpec(field, value)})
+	}
+
+	return spec
+}
+
+func fieldToSpec(field protoreflect.FieldDescriptor, value protoreflect.Value) interface{} {
+	switch {
+	case field.IsList():
+		list := value.List()
+		values := make([]interface{}, list.Len())
+		for i := range values {
+			values[i] = singularToSpec(field, list.Get(i))
+		}
+		return values
+	case field.IsMap():
+		values := make(map[string]interface{})
+		value.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			values[key.String()] = singularToSpec(field.MapValue(), value)
+			return true
+		})
+		return values
+	default:
+		return singularToSpec(field, value)
+	}
+}
+
+func singularToSpec(field protoreflect.FieldDescriptor, value protoreflect.Value) interface{} {
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		message := value.Message()
+		switch {
+		case isTimestamp(field.Message()):
+			fields := field.Message().Fields()
+			return time.Unix(message.Get(fields.ByName("seconds")).Int(), message.Get(fields.ByName("nanos")).Int()).UTC()
+		case field.Message() == memoryDescriptor:
+			if text, ok := FormatMemory(message.Interface().(*pb.Memory)); ok {
+				return text
+			}
+		}
+		return messageToSpec(message)
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			return string(enumValue.Name())
+		}
+		return int64(value.Enum())
+	case protoreflect.BoolKind:
+		return value.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return value.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return value.Uint()
+	case protoreflect.FloatKind:
+		// written with the digits of the float32, not of its float64 value
+		number, _ := strconv.ParseFloat(formatNumber(field, value), 64)
+		return number
+	case protoreflect.DoubleKind:
+		return value.Float()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(value.Bytes())
+	default:
+		return value.String()
+	}
+}
+
+// quantityOneof reports whether a field is a number in a oneof whose
+// members are all numbers named after the oneof and a unit, and returns
+// the unit of the field
+func quantityOneof(field protoreflect.FieldDescriptor) (protoreflect.OneofDescriptor, string, bool) {
+	oneof := field.ContainingOneof()
+	if oneof == nil {
+		return nil, "", false
+	}
+
+	prefix := string(oneof.Name()) + "_"
+	members := oneof.Fields()
+	for i := 0; i < members.Len(); i++ {
+		member := members.Get(i)
+		if !isNumber(member) || !strings.HasPrefix(string(member.Name()), prefix) || member.IsList() {
+			return nil, "", false
+		}
+	}
+
+	return oneof, strings.TrimPrefix(string(field.Name()), prefix), true
+}
+
+func isNumber(field protoreflect.FieldDescriptor) bool {
+	switch field.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind, protoreflect.StringKind, protoreflect.BytesKind,
+		protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	default:
+		return true
+	}
+}
+
+func formatNumber(field protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	switch field.Kind() {
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 32)
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 64)
+	default:
+		return value.String()
+	}
+}
+
+// specToMessage sets the fields of a message from a decoded spec. Unknown
+// fields and values of the wrong type are errors with the path of the field.
+func specToMessage(path string, spec interface{}, message protoreflect.Message) error {
+	fields, ok := spec.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be a table of fields, got %s", pathName(path), describe(spec))
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	descriptor := message.Descriptor()
+	for _, name := range names {
+		value := fields[name]
+		fieldPath := joinPath(path, name)
+
+		field := descriptor.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			field = descriptor.Fields().ByJSONName(name)
+		}
+		if field == nil {
+			oneof := descriptor.Oneofs().ByName(protoreflect.Name(name))
+			if oneof == nil {
+				return fmt.Errorf("%s is not a field of %s", fieldPath, descriptor.Name())
+			}
+
+			err := setQuantity(fieldPath, oneof, value, message)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if oneof := field.ContainingOneof(); oneof != nil {
+			if set := message.WhichOneof(oneof); set != nil {
+				return fmt.Errorf("%s cannot be set together with %s", fieldPath, set.Name())
+			}
+		}
+
+		err := setField(fieldPath, field, value, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setQuantity sets the member of a oneof named by the unit of a quantity
+func setQuantity(path string, oneof protoreflect.OneofDescriptor, value interface{}, message protoreflect.Message) error {
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s must be a number with a unit, got %s", path, describe(value))
+	}
+
+	match := quantityPattern.FindStringSubmatch(text)
+	if match == nil {
+		return fmt.Errorf("%s must be a number with a unit, got %q", path, text)
+	}
+
+	var units []string
+	members := oneof.Fields()
+	for i := 0; i < members.Len(); i++ {
+		member := members.Get(i)
+		_, unit, ok := quantityOneof(member)
+		if !ok {
+			break
+		}
+		if strings.EqualFold(unit, match[2]) {
+			number, err := strconv.ParseFloat(match[1], 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number with a unit, got %q", path, text)
+			}
+			return setField(path, member, number, message)
+		}
+		units = append(units, unit)
+	}
+
+	if len(units) == 0 {
+		return fmt.Errorf("%s is not a field of %s", path, message.Descriptor().Name())
+	}
+	return fmt.Errorf("%s has an unknown unit %q, expected one of %s", path, match[2], strings.Join(units, ", "))
+}
+
+func setField(path string, field protoreflect.FieldDescriptor, value interface{}, message protoreflect.Message) error {
+	switch {
+	case field.IsList():
+		values, err := specList(path, value)
+		if err != nil {
+			return err
+		}
+
+		list := message.Mutable(field).List()
+		for i, element := range values {
+			elementPath := fmt.Sprintf("%s[%d]", path, i)
+			if field.Kind() == protoreflect.MessageKind {
+				elementValue := list.NewElement()
+				err := specToSingularMessage(elementPath, field, element, elementValue.Message())
+				if err != nil {
+					return err
+				}
+				list.Append(elementValue)
+				continue
+			}
+
+			scalar, err := specToScalar(elementPath, field, element)
+			if err != nil {
+				return err
+			}
+			list.Append(scalar)
+		}
+		return nil
+	case field.IsMap():
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a table, got %s", path, describe(value))
+		}
+
+		values := message.Mutable(field).Map()
+		for key, entry := range entries {
+			entryPath := joinPath(path, key)
+			mapKey, err := specToScalar(entryPath, field.MapKey(), key)
+			if err != nil {
+				return err
+			}
+
+			if field.MapValue().Kind() == protoreflect.MessageKind {
+				entryValue := values.NewValue()
+				err := specToSingularMessage(entryPath, field.MapValue(), entry, entryValue.Message())
+				if err != nil {
+					return err
+				}
+				values.Set(mapKey.MapKey(), entryValue)
+				continue
+			}
+
+			scalar, err := specToScalar(entryPath, field.MapValue(), entry)
+			if err != nil {
+				return err
+			}
+			values.Set(mapKey.MapKey(), scalar)
+		}
+		return nil
+	case field.Kind() == protoreflect.MessageKind:
+		return specToSingularMessage(path, field, value, message.Mutable(field).Message())
+	default:
+		scalar, err := specToScalar(path, field, value)
+		if err != nil {
+			return err
+		}
+		message.Set(field, scalar)
+		return nil
+	}
+}
+
+func specList(path string, value interface{}) ([]interface{}, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		return value, nil
+	case []map[string]interface{}:
+		values := make([]interface{}, len(value))
+		for i, element := range value {
+			values[i] = element
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list, got %s", path, describe(value))
+	}
+}
+
+// specToSingularMessage fills a message, which may be a timestamp or memory
+// written as a string
+func specToSingularMessage(path string, field protoreflect.FieldDescriptor, value interface{}, message protoreflect.Message) error {
+	switch {
+	case isTimestamp(field.Message()):
+		timestamp, err := specTime(path, value)
+		if err != nil {
+			return err
+		}
+
+		fields := field.Message().Fields()
+		message.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(timestamp.Unix()))
+		message.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(timestamp.Nanosecond())))
+		return nil
+	case field.Message() == memoryDescriptor:
+		if text, ok := value.(string); ok {
+			memory, err := ParseMemory(text)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+
+			proto.Merge(message.Interface(), memory)
+			return nil
+		}
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("%s must be memory with a unit like 16GB, got %s", path, describe(value))
+		}
+	}
+
+	return specToMessage(path, value, message)
+}
+
+func specTime(path string, value interface{}) (time.Time, error) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, nil
+	case string:
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s must be an RFC 3339 date-time: %w", path, err)
+		}
+		return timestamp, nil
+	default:
+		return time.Time{}, fmt.Errorf("%s must be a date-time, got %s", path, describe(value))
+	}
+}
+
+func specToScalar(path string, field protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		if value, ok := value.(bool); ok {
+			return protoreflect.ValueOfBool(value), nil
+		}
+	case protoreflect.EnumKind:
+		return specToEnum(path, field.Enum(), value)
+	case protoreflect.StringKind:
+		if value, ok := value.(string); ok {
+			return protoreflect.ValueOfString(value), nil
+		}
+		return protoreflect.Value{}, fmt.Errorf("%s must be a string, got %s, quote it", path, describe(value))
+	case protoreflect.BytesKind:
+		if value, ok := value.(string); ok {
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return protoreflect.Value{}, fmt.Errorf("%s must be base64: %w", path, err)
+			}
+			return protoreflect.ValueOfBytes(data), nil
+		}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		if number, ok := specFloat(value); ok {
+			if field.Kind() == protoreflect.FloatKind {
+				return protoreflect.ValueOfFloat32(float32(number)), nil
+			}
+			return protoreflect.ValueOfFloat64(number), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if number, ok := specInt(value); ok && number >= math.MinInt32 && number <= math.MaxInt32 {
+			return protoreflect.ValueOfInt32(int32(number)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if number, ok := specInt(value); ok {
+			return protoreflect.ValueOfInt64(number), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if number, ok := specUint(value); ok && number <= math.MaxUint32 {
+			return protoreflect.ValueOfUint32(uint32(number)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if number, ok := specUint(value); ok {
+			return protoreflect.ValueOfUint64(number), nil
+		}
+	}
+
+	return protoreflect.Value{}, fmt.Errorf("%s must be a %s, got %s", path, kindName(field.Kind()), describe(value))
+}
+
+func specToEnum(path string, enum protoreflect.EnumDescriptor, value interface{}) (protoreflect.Value, error) {
+	values := enum.Values()
+
+	if name, ok := value.(string); ok {
+		for i := 0; i < values.Len(); i++ {
+			if strings.EqualFold(string(values.Get(i).Name()), strings.TrimSpace(name)) {
+				return protoreflect.ValueOfEnum(values.Get(i).Number()), nil
+			}
+		}
+	} else if number, ok := specInt(value); ok && values.ByNumber(protoreflect.EnumNumber(number)) != nil {
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(number)), nil
+	}
+
+	names := make([]string, values.Len())
+	for i := range names {
+		names[i] = string(values.Get(i).Name())
+	}
+	return protoreflect.Value{}, fmt.Errorf("%s must be one of %s, got %v", path, strings.Join(names, ", "), value)
+}
+
+func specFloat(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	default:
+		return 0, false
+	}
+}
+
+func specInt(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case int:
+		return int64(value), true
+	case int64:
+		return value, true
+	case uint64:
+		return int64(value), value <= math.MaxInt64
+	case float64:
+		return int64(value), value == math.Trunc(value) && math.Abs(value) < 1<<63
+	default:
+		return 0, false
+	}
+}
+
+func specUint(value interface{}) (uint64, bool) {
+	switch value := value.(type) {
+	case uint64:
+		return value, true
+	case float64:
+		return uint64(value), value >= 0 && value == math.Trunc(value) && value < 1<<64
+	default:
+		number, ok := specInt(value)
+		return uint64(number), ok && number >= 0
+	}
+}
+
+func kindName(kind protoreflect.Kind) string {
+	switch kind {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return "number"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "whole number of at least 0"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "whole number"
+	case protoreflect.BytesKind:
+		return "base64 string"
+	default:
+		return kind.String()
+	}
+}
+
+func describe(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "nothing"
+	case string:
+		return strconv.Quote(value)
+	case map[string]interface{}:
+		return "a table"
+	case []interface{}, []map[string]interface{}:
+		return "a list"
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func joinPath(parent string, name string) string {
+	if len(parent) == 0 {
+		return name
+	}
+
+	return parent + "." + name
+}
+
+func pathName(path string) string {
+	if len(path) == 0 {
+		return "the spec"
+	}
+
+	return path
+}
+
+func isTimestamp(message protoreflect.MessageDescriptor) bool {
+	return message.FullName() == "google.protobuf.Timestamp"
+}
@@ -0,0 +1,243 @@
+package serializer_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// specLaptop is the laptop of the spec files in testdata
+func specLaptop() *pb.Laptop {
+	return &pb.Laptop{
+		Brand: "Lenovo",
+		Name:  "ThinkPad X1 Carbon",
+		Cpu: &pb.CPU{
+			Brand:      "Intel",
+			Name:       "Core i7-1165G7",
+			CpuCores:   4,
+			CpuThreads: 8,
+			MinGhz:     2.8,
+			MaxGhz:     4.7,
+		},
+		Ram: &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE},
+		Gpus: []*pb.GPU{{
+			Brand:  "Intel",
+			Name:   "Iris Xe",
+			MinGhz: 0.4,
+			MaxGhz: 1.3,
+			Memory: &pb.Memory{Value: 512, Unit: pb.Memory_MEGABYTE},
+		}},
+		Storages: []*pb.Storage{
+			{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}},
+			{Driver: pb.Storage_HHD, Memory: &pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}},
+		},
+		Screen: &pb.Screen{
+			SizeInch:   14,
+			Resolution: &pb.Screen_Resolution{Width: 1920, Height: 1200},
+			Panel:      pb.Screen_IPS,
+		},
+		Keyboard:    &pb.Keyboard{Layout: pb.Keyboard_QWERTY, Backlit: true},
+		Weight:      &pb.Laptop_WeightKg{WeightKg: 1.13},
+		PriceUsd:    1899.99,
+		ReleaseYear: 2021,
+		UpdatedAt:   timestamppb.New(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)),
+	}
+}
+
+func TestReadProtobufFromSpecFile(t *testing.T) {
+	t.Parallel()
+
+	for _, filename := range []string{"testdata/laptop.yaml", "testdata/laptop.toml"} {
+		laptop := &pb.Laptop{}
+		err := serializer.ReadProtobufFromSpecFile(filename, laptop)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(specLaptop(), laptop), "%s: %v", filename, laptop)
+	}
+}
+
+func TestSpecRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	laptops := []*pb.Laptop{specLaptop(), {}, {Weight: &pb.Laptop_WeightLb{WeightLb: 2.5}, Ram: &pb.Memory{Value: 3, Unit: pb.Memory_UNKNOWN}}}
+	for i := 0; i < 20; i++ {
+		laptops = append(laptops, sample.NewLaptop())
+	}
+
+	for _, laptop := range laptops {
+		data, err := serializer.ProtobufToYAML(laptop)
+		require.NoError(t, err)
+
+		fromYAML := &pb.Laptop{}
+		err = serializer.YAMLToProtobufMessage(data, fromYAML)
+		require.NoError(t, err, data)
+		require.True(t, proto.Equal(laptop, fromYAML), data)
+
+		data, err = serializer.ProtobufToTOML(laptop)
+		require.NoError(t, err)
+
+		fromTOML := &pb.Laptop{}
+		err = serializer.TOMLToProtobufMessage(data, fromTOML)
+		require.NoError(t, err, data)
+		require.True(t, proto.Equal(laptop, fromTOML), data)
+	}
+}
+
+func TestProtobufToYAML(t *testing.T) {
+	t.Parallel()
+
+	laptop := &pb.Laptop{
+		Brand:     "Apple",
+		Ram:       &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE},
+		Storages:  []*pb.Storage{{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}}},
+		Screen:    &pb.Screen{SizeInch: 13.3},
+		Weight:    &pb.Laptop_WeightLb{WeightLb: 3},
+		UpdatedAt: timestamppb.New(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)),
+	}
+
+	data, err := serializer.ProtobufToYAML(laptop)
+	require.NoError(t, err)
+	require.Equal(t, `brand: Apple
+ram: 16GB
+storages:
+  - driver: SSD
+    memory: 512GB
+screen:
+  size_inch: 13.3
+weight: 3 lb
+updated_at: 2024-05-01T12:30:00Z
+`, data)
+}
+
+func TestSpecErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{
+			name: "unknown field",
+			yaml: "cpu:\n  cores: 4\n",
+			err:  "cpu.cores is not a field of CPU",
+		},
+		{
+			name: "unknown enum",
+			yaml: "keyboard:\n  layout: dvorak\n",
+			err:  "keyboard.layout must be one of UNKNOWN, QWERTY, QWERTZ, AZERTY, got dvorak",
+		},
+		{
+			name: "memory without unit",
+			yaml: "ram: 16\n",
+			err:  "ram must be memory with a unit like 16GB, got 16",
+		},
+		{
+			name: "unknown memory unit",
+			yaml: "gpus:\n  - memory: 8 PB\n",
+			err:  `gpus[0].memory: "8 PB" has an unknown memory unit "PB"`,
+		},
+		{
+			name: "unknown weight unit",
+			yaml: "weight: 2 st\n",
+			err:  `weight has an unknown unit "st", expected one of kg, lb`,
+		},
+		{
+			name: "two weights",
+			yaml: "weight_kg: 1\nweight_lb: 2\n",
+			err:  "weight_lb cannot be set together with weight_kg",
+		},
+		{
+			name: "negative count",
+			yaml: "cpu:\n  cpu_cores: -1\n",
+			err:  "cpu.cpu_cores must be a whole number of at least 0, got -1",
+		},
+		{
+			name: "unquoted string",
+			yaml: "name: 2024\n",
+			err:  "name must be a string, got 2024, quote it",
+		},
+		{
+			name: "not a table",
+			yaml: "- brand: Dell\n",
+			err:  "the spec must be a table of fields, got a list",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := serializer.YAMLToProtobufMessage(tc.yaml, &pb.Laptop{})
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]*pb.Memory{
+		"16GB":        {Value: 16, Unit: pb.Memory_GIGABYTE},
+		"512 GiB":     {Value: 512, Unit: pb.Memory_GIGABYTE},
+		" 1 tb ":      {Value: 1, Unit: pb.Memory_TERABYTE},
+		"64 bits":     {Value: 64, Unit: pb.Memory_BIT},
+		"8 bytes":     {Value: 8, Unit: pb.Memory_BYTE},
+		"256KiB":      {Value: 256, Unit: pb.Memory_KILOBYTE},
+		"4 M":         {Value: 4, Unit: pb.Memory_MEGABYTE},
+		"2 gigabytes": {Value: 2, Unit: pb.Memory_GIGABYTE},
+	}
+
+	for text, expected := range testCases {
+		memory, err := serializer.ParseMemory(text)
+		require.NoError(t, err, text)
+		require.True(t, proto.Equal(expected, memory), text)
+
+		if formatted, ok := serializer.FormatMemory(memory); ok {
+			again, err := serializer.ParseMemory(formatted)
+			require.NoError(t, err)
+			require.True(t, proto.Equal(memory, again), formatted)
+		}
+	}
+
+	for _, text := range []string{"", "GB", "16", "1.5GB", "-1GB", "16 PB"} {
+		_, err := serializer.ParseMemory(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestSpecFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	laptop := sample.NewLaptop()
+
+	for _, name := range []string{"laptop.yaml", "laptop.yml", "laptop.toml"} {
+		filename := filepath.Join(dir, name)
+		require.True(t, serializer.IsSpecFile(filename))
+
+		err := serializer.WriteProtobufToSpecFile(laptop, filename)
+		require.NoError(t, err)
+
+		other := &pb.Laptop{}
+		err = serializer.ReadProtobufFromSpecFile(filename, other)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, other))
+	}
+
+	filename := filepath.Join(dir, "laptop.json")
+	require.False(t, serializer.IsSpecFile(filename))
+	require.Error(t, serializer.WriteProtobufToSpecFile(laptop, filename))
+
+	filename = filepath.Join(dir, "broken.toml")
+	require.NoError(t, os.WriteFile(filename, []byte("ram = 16\n"), 0644))
+	err := serializer.ReadProtobufFromSpecFile(filename, &pb.Laptop{})
+	require.EqualError(t, err, filename+": ram must be memory with a unit like 16GB, got 16")
+}
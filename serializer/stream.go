@@ -0,0 +1,342 @@
+package serializer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"io"
+)
+
+// StreamFormat is the encoding of a sequence of messages
+type StreamFormat string
+
+const (
+	// Delimited writes every message as binary protobuf, prefixed with its
+	// size as a varint
+	Delimited StreamFormat = "delimited"
+	// NDJSON writes every message as JSON on its own line
+	NDJSON StreamFormat = "ndjson"
+	// JSONArray writes the messages as one JSON array, a message per line
+	JSONArray StreamFormat = "json-array"
+)
+
+// StreamFormats lists every stream format
+var StreamFormats = []StreamFormat{Delimited, NDJSON, JSONArray}
+
+// DefaultMaxMessageSize is the largest delimited message a MessageReader
+// accepts unless configured otherwise, 64 megabytes
+const DefaultMaxMessageSize = 64 << 20
+
+func checkFormat(format StreamFormat) error {
+	for _, known := range StreamFormats {
+		if format == known {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown stream format %q", format)
+}
+
+// MessageWriter writes a sequence of messages to an io.Writer. JSON uses the
+// field names and enums of ProtobufToJSON, on a single line per message.
+type MessageWriter struct {
+	format     StreamFormat
+	compressor io.WriteCloser
+	writer     *bufio.Writer
+	count      int
+}
+
+// NewMessageWriter returns a writer of messages in the format, compressed
+// into w. Close must be called after the last message.
+func NewMessageWriter(w io.Writer, format StreamFormat, compression Compression) (*MessageWriter, error) {
+	err := checkFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	compressor, err := NewCompressedWriter(w, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageWriter{
+		format:     format,
+		compressor: compressor,
+		writer:     bufio.NewWriter(compressor),
+	}, nil
+}
+
+// Write writes the next message
+func (writer *MessageWriter) Write(message proto.Message) error {
+	var err error
+	switch writer.format {
+	case Delimited:
+		err = writer.writeDelimited(message)
+	case NDJSON:
+		err = writer.writeJSON(message, "")
+	case JSONArray:
+		separator := ",\n"
+		if writer.count == 0 {
+			separator = "[\n"
+		}
+		err = writer.writeJSON(message, separator)
+	}
+	if err != nil {
+		return err
+	}
+
+	writer.count++
+	return nil
+}
+
+func (writer *MessageWriter) writeDelimited(message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("cannot marshal proto message to binary: %w", err)
+	}
+
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(data)))
+
+	_, err = writer.writer.Write(size[:n])
+	if err != nil {
+		return fmt.Errorf("cannot write message size: %w", err)
+	}
+
+	_, err = writer.writer.Write(data)
+	if err != nil {
+		return fmt.Errorf("cannot write message: %w", err)
+	}
+
+	return nil
+}
+
+func (writer *MessageWriter) writeJSON(message proto.Message, prefix string) error {
+	_, err := writer.writer.WriteString(prefix)
+	if err != nil {
+		return fmt.Errorf("cannot write message: %w", err)
+	}
+
+	data, err := marshalJSON(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.writer.Write(data)
+	if err != nil {
+		return fmt.Errorf("cannot write message: %w", err)
+	}
+
+	if writer.format == NDJSON {
+		err = writer.writer.WriteByte('\n')
+		if err != nil {
+			return fmt.Errorf("cannot write message: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close ends the stream, writes the buffered messages and the end of the
+// compressed data. It does not close the underlying writer.
+func (writer *MessageWriter) Close() error {
+	if writer.format == JSONArray {
+		end := "\n]\n"
+		if writer.count == 0 {
+			end = "[]\n"
+		}
+
+		_, err := writer.writer.WriteString(end)
+		if err != nil {
+			return fmt.Errorf("cannot write end of JSON array: %w", err)
+		}
+	}
+
+	err := writer.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("cannot write messages: %w", err)
+	}
+
+	err = writer.compressor.Close()
+	if err != nil {
+		return fmt.Errorf("cannot end compressed stream: %w", err)
+	}
+
+	return nil
+}
+
+// MessageReader reads a sequence of messages from an io.Reader, holding one
+// message in memory at a time
+type MessageReader struct {
+	format         StreamFormat
+	decompressor   io.ReadCloser
+	reader         *bufio.Reader
+	decoder        *json.Decoder
+	maxMessageSize uint64
+	buffer         []byte
+
+	// whether the opening and closing brackets of a JSON array were read
+	started bool
+	ended   bool
+}
+
+// MessageReaderOption configures optional behaviour of a message reader
+type MessageReaderOption func(reader *MessageReader)
+
+// WithMaxMessageSize sets the largest delimited message in bytes that is read,
+// a larger size is reported as an error instead of being allocated
+func WithMaxMessageSize(size int) MessageReaderOption {
+	return func(reader *MessageReader) {
+		reader.maxMessageSize = uint64(size)
+	}
+}
+
+// NewMessageReader returns a reader of messages in the format, decompressed
+// from r. Close releases the decompressor.
+func NewMessageReader(r io.Reader, format StreamFormat, compression Compression, opts ...MessageReaderOption) (*MessageReader, error) {
+	err := checkFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressor, err := NewDecompressedReader(r, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &MessageReader{
+		format:         format,
+		decompressor:   decompressor,
+		reader:         bufio.NewReader(decompressor),
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+	if format != Delimited {
+		reader.decoder = json.NewDecoder(reader.reader)
+	}
+
+	for _, opt := range opts {
+		opt(reader)
+	}
+
+	return reader, nil
+}
+
+// Read reads the next message into message. It returns io.EOF after the
+// last message, and io.ErrUnexpectedEOF for a stream that ends in the
+// middle of a message.
+func (reader *MessageReader) Read(message proto.Message) error {
+	proto.Reset(message)
+
+	switch reader.format {
+	case Delimited:
+		return reader.readDelimited(message)
+	case NDJSON:
+		return reader.readJSON(message)
+	default:
+		return reader.readJSONArrayElement(message)
+	}
+}
+
+func (reader *MessageReader) readDelimited(message proto.Message) error {
+	size, err := binary.ReadUvarint(reader.reader)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read message size: %w", err)
+	}
+	if size > reader.maxMessageSize {
+		return fmt.Errorf("message of %d bytes is larger than the limit of %d bytes", size, reader.maxMessageSize)
+	}
+
+	if uint64(cap(reader.buffer)) < size {
+		reader.buffer = make([]byte, size)
+	}
+	data := reader.buffer[:size]
+
+	_, err = io.ReadFull(reader.reader, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read message: %w", err)
+	}
+
+	err = proto.Unmarshal(data, message)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal binary to proto message: %w", err)
+	}
+
+	return nil
+}
+
+func (reader *MessageReader) readJSON(message proto.Message) error {
+	var data json.RawMessage
+	err := reader.decoder.Decode(&data)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read JSON: %w", err)
+	}
+
+	err = JSONToProtobufMessage(string(data), message)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal JSON to proto message: %w", err)
+	}
+
+	return nil
+}
+
+func (reader *MessageReader) readJSONArrayElement(message proto.Message) error {
+	if !reader.started {
+		err := reader.readDelim('[')
+		if err != nil {
+			return err
+		}
+		reader.started = true
+	}
+
+	if reader.ended {
+		return io.EOF
+	}
+
+	if !reader.decoder.More() {
+		err := reader.readDelim(']')
+		if err != nil {
+			return err
+		}
+
+		reader.ended = true
+		return io.EOF
+	}
+
+	err := reader.readJSON(message)
+	if err == io.EOF {
+		return fmt.Errorf("cannot read JSON array: %w", io.ErrUnexpectedEOF)
+	}
+
+	return err
+}
+
+func (reader *MessageReader) readDelim(delim json.Delim) error {
+	token, err := reader.decoder.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read JSON array: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("cannot read JSON array: expected %v, got %v", delim, token)
+	}
+
+	return nil
+}
+
+// Close releases the decompressor, it does not close the underlying reader
+func (reader *MessageReader) Close() error {
+	return reader.decompressor.Close()
+}
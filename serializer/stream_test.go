@@ -0,0 +1,151 @@
+package serializer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/serializer"
+	"strings"
+	"testing"
+)
+
+func writeMessages(t *testing.T, format serializer.StreamFormat, compression serializer.Compression, laptops []*pb.Laptop) *bytes.Buffer {
+	var buffer bytes.Buffer
+	writer, err := serializer.NewMessageWriter(&buffer, format, compression)
+	require.NoError(t, err)
+
+	for _, laptop := range laptops {
+		require.NoError(t, writer.Write(laptop))
+	}
+	require.NoError(t, writer.Close())
+
+	return &buffer
+}
+
+func readMessages(t *testing.T, r io.Reader, format serializer.StreamFormat, compression serializer.Compression) ([]*pb.Laptop, error) {
+	reader, err := serializer.NewMessageReader(r, format, compression)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	var laptops []*pb.Laptop
+	for {
+		laptop := &pb.Laptop{}
+		err := reader.Read(laptop)
+		if err == io.EOF {
+			return laptops, nil
+		}
+		if err != nil {
+			return laptops, err
+		}
+
+		laptops = append(laptops, laptop)
+	}
+}
+
+func TestMessageStream(t *testing.T) {
+	t.Parallel()
+
+	laptops := make([]*pb.Laptop, 100)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+
+	compressions := []serializer.Compression{serializer.NoCompression, serializer.Gzip, serializer.Zstd}
+	for _, format := range serializer.StreamFormats {
+		for _, compression := range compressions {
+			format, compression := format, compression
+			t.Run(fmt.Sprintf("%s %s", format, compression), func(t *testing.T) {
+				t.Parallel()
+
+				for _, count := range []int{0, 1, len(laptops)} {
+					buffer := writeMessages(t, format, compression, laptops[:count])
+
+					read, err := readMessages(t, buffer, format, compression)
+					require.NoError(t, err)
+					require.Len(t, read, count)
+					for i, laptop := range read {
+						require.True(t, proto.Equal(laptops[i], laptop))
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMessageStreamJSON(t *testing.T) {
+	t.Parallel()
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop()}
+
+	buffer := writeMessages(t, serializer.NDJSON, serializer.NoCompression, laptops)
+	lines := strings.Split(buffer.String(), "\n")
+	require.Len(t, lines, 3)
+	for i, laptop := range laptops {
+		expected, err := serializer.ProtobufToJSONLine(laptop)
+		require.NoError(t, err)
+		require.Equal(t, expected, lines[i])
+	}
+
+	// a JSON array is valid JSON as a whole
+	buffer = writeMessages(t, serializer.JSONArray, serializer.NoCompression, laptops)
+	var values []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &values))
+	require.Len(t, values, 2)
+	require.Equal(t, laptops[1].GetId(), values[1]["id"])
+
+	buffer = writeMessages(t, serializer.JSONArray, serializer.NoCompression, nil)
+	require.Equal(t, "[]\n", buffer.String())
+}
+
+func TestMessageStreamTruncated(t *testing.T) {
+	t.Parallel()
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop()}
+
+	for _, format := range serializer.StreamFormats {
+		buffer := writeMessages(t, format, serializer.NoCompression, laptops)
+		data := buffer.Bytes()[:buffer.Len()-20]
+
+		read, err := readMessages(t, bytes.NewReader(data), format, serializer.NoCompression)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, string(format))
+		require.Len(t, read, 1, string(format))
+	}
+
+	_, err := readMessages(t, strings.NewReader(""), serializer.JSONArray, serializer.NoCompression)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = readMessages(t, strings.NewReader(`{"id": "1"}`), serializer.JSONArray, serializer.NoCompression)
+	require.EqualError(t, err, "cannot read JSON array: expected [, got {")
+}
+
+func TestMessageStreamMaxMessageSize(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	buffer := writeMessages(t, serializer.Delimited, serializer.NoCompression, []*pb.Laptop{laptop})
+
+	reader, err := serializer.NewMessageReader(buffer, serializer.Delimited, serializer.NoCompression, serializer.WithMaxMessageSize(10))
+	require.NoError(t, err)
+
+	err = reader.Read(&pb.Laptop{})
+	require.EqualError(t, err, fmt.Sprintf("message of %d bytes is larger than the limit of 10 bytes", proto.Size(laptop)))
+}
+
+func TestMessageStreamUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := serializer.NewMessageWriter(io.Discard, "xml", serializer.NoCompression)
+	require.EqualError(t, err, `unknown stream format "xml"`)
+
+	_, err = serializer.NewMessageReader(strings.NewReader(""), serializer.NDJSON, "lz4")
+	require.EqualError(t, err, `unknown compression "lz4"`)
+
+	require.Equal(t, serializer.Gzip, serializer.CompressionFromFilename("catalog.ndjson.gz"))
+	require.Equal(t, serializer.Zstd, serializer.CompressionFromFilename("catalog.bin.zst"))
+	require.Equal(t, serializer.NoCompression, serializer.CompressionFromFilename("catalog.csv"))
+}
@@ -0,0 +1,60 @@
+package serializer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufToTOML converts a protocol buffer message to a TOML spec. TOML
+// writes the fields of a table before its subtables, each in name order.
+func ProtobufToTOML(message proto.Message) (string, error) {
+	var buffer bytes.Buffer
+	err := toml.NewEncoder(&buffer).Encode(specToTOMLValue(messageToSpec(message.ProtoReflect())))
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal proto message to TOML: %w", err)
+	}
+
+	return buffer.String(), nil
+}
+
+// TOMLToProtobufMessage converts a TOML spec to a protocol buffer message
+func TOMLToProtobufMessage(data string, message proto.Message) error {
+	var spec map[string]interface{}
+	_, err := toml.Decode(data, &spec)
+	if err != nil {
+		return fmt.Errorf("cannot parse TOML: %w", err)
+	}
+
+	proto.Reset(message)
+	return specToMessage("", spec, message.ProtoReflect())
+}
+
+// specToTOMLValue converts messages to tables, and lists of messages to
+// arrays of tables
+func specToTOMLValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case specMessage:
+		table := make(map[string]interface{}, len(value))
+		for _, field := range value {
+			table[field.name] = specToTOMLValue(field.value)
+		}
+		return table
+	case []interface{}:
+		tables := make([]map[string]interface{}, 0, len(value))
+		for _, element := range value {
+			table, ok := specToTOMLValue(element).(map[string]interface{})
+			if !ok {
+				return value
+			}
+			tables = append(tables, table)
+		}
+		if len(tables) == 0 {
+			return value
+		}
+		return tables
+	default:
+		return value
+	}
+}
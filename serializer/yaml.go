@@ -0,0 +1,74 @@
+package serializer
+
+import (
+	"bytes"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// ProtobufToYAML converts a protocol buffer message to a YAML spec, with the
+// fields that are set in the order of the schema
+func ProtobufToYAML(message proto.Message) (string, error) {
+	node, err := specToYAMLNode(messageToSpec(message.ProtoReflect()))
+	if err != nil {
+		return "", fmt.Errorf("cannot convert proto message to YAML: %w", err)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	err = encoder.Encode(node)
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal proto message to YAML: %w", err)
+	}
+
+	return buffer.String(), nil
+}
+
+// YAMLToProtobufMessage converts a YAML spec to a protocol buffer message
+func YAMLToProtobufMessage(data string, message proto.Message) error {
+	var spec interface{}
+	err := yaml.Unmarshal([]byte(data), &spec)
+	if err != nil {
+		return fmt.Errorf("cannot parse YAML: %w", err)
+	}
+
+	proto.Reset(message)
+	return specToMessage("", spec, message.ProtoReflect())
+}
+
+func specToYAMLNode(value interface{}) (*yaml.Node, error) {
+	switch value := value.(type) {
+	case specMessage:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, field := range value {
+			child, err := specToYAMLNode(field.value)
+			if err != nil {
+				return nil, err
+			}
+
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.name}
+			node.Content = append(node.Content, key, child)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, element := range value {
+			child, err := specToYAMLNode(element)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	default:
+		node := &yaml.Node{}
+		err := node.Encode(value)
+		return node, err
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"laptop-app-using-grpc/pb/pb"
+)
+
+// APIKeyServer is the server for managing API keys
+type APIKeyServer struct {
+	apiKeyStore APIKeyStore
+}
+
+// NewAPIKeyServer returns a new API key server
+func NewAPIKeyServer(apiKeyStore APIKeyStore) *APIKeyServer {
+	return &APIKeyServer{apiKeyStore}
+}
+
+// CreateApiKey is a unary RPC to create an API key with scoped permissions
+func (server *APIKeyServer) CreateApiKey(ctx context.Context, req *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	if len(req.GetName()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "API key name is required")
+	}
+	if len(req.GetScopes()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "at least one scope is required")
+	}
+	for _, scope := range req.GetScopes() {
+		if !IsKnownScope(scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown scope: %s", scope)
+		}
+	}
+
+	apiKey, key, err := NewAPIKey(req.GetName(), req.GetScopes())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate API key: %v", err)
+	}
+
+	err = server.apiKeyStore.Save(apiKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save API key: %v", err)
+	}
+
+	res := &pb.CreateApiKeyResponse{
+		ApiKey: toPbAPIKey(apiKey),
+		Key:    key,
+	}
+	return res, nil
+}
+
+// ListApiKeys is a unary RPC to list every API key without its secret
+func (server *APIKeyServer) ListApiKeys(ctx context.Context, req *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	apiKeys, err := server.apiKeyStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list API keys: %v", err)
+	}
+
+	res := &pb.ListApiKeysResponse{}
+	for _, apiKey := range apiKeys {
+		res.ApiKeys = append(res.ApiKeys, toPbAPIKey(apiKey))
+	}
+	return res, nil
+}
+
+// RevokeApiKey is a unary RPC to revoke an API key
+func (server *APIKeyServer) RevokeApiKey(ctx context.Context, req *pb.RevokeApiKeyRequest) (*pb.RevokeApiKeyResponse, error) {
+	apiKey, err := server.apiKeyStore.Revoke(req.GetId())
+	if errors.Is(err, ErrorNotFound) {
+		return nil, status.Errorf(codes.NotFound, "API key %s does not exist", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke API key: %v", err)
+	}
+
+	return &pb.RevokeApiKeyResponse{ApiKey: toPbAPIKey(apiKey)}, nil
+}
+
+func toPbAPIKey(apiKey *APIKey) *pb.ApiKey {
+	return &pb.ApiKey{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: timestamppb.New(apiKey.CreatedAt),
+		Revoked:   apiKey.Revoked,
+	}
+}
@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"laptop-app-using-grpc/client"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"testing"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestAuthServer(t)
+	adminConn := newTestAuthConn(t, serverAddress, "admin1", "secret")
+	apiKeyClient := pb.NewApiKeyServiceClient(adminConn)
+
+	_, err := apiKeyClient.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{
+		Name:   "bad-scope",
+		Scopes: []string{"catalog:delete"},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := apiKeyClient.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{
+		Name:   "search-importer",
+		Scopes: []string{service.ScopeCatalogRead},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, created.GetKey())
+
+	laptopClient := newTestAPIKeyLaptopClient(t, serverAddress, created.GetKey())
+
+	// a read-only key may search but not create
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NotEqual(t, codes.PermissionDenied, status.Code(err))
+	require.NotEqual(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// a read-only key cannot manage keys either
+	_, err = pb.NewApiKeyServiceClient(newTestAPIKeyConn(t, serverAddress, created.GetKey())).ListApiKeys(
+		context.Background(), &pb.ListApiKeysRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	listed, err := apiKeyClient.ListApiKeys(context.Background(), &pb.ListApiKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, listed.GetApiKeys(), 1)
+	require.Equal(t, created.GetApiKey().GetId(), listed.GetApiKeys()[0].GetId())
+
+	revoked, err := apiKeyClient.RevokeApiKey(context.Background(), &pb.RevokeApiKeyRequest{Id: created.GetApiKey().GetId()})
+	require.NoError(t, err)
+	require.True(t, revoked.GetApiKey().GetRevoked())
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = apiKeyClient.RevokeApiKey(context.Background(), &pb.RevokeApiKeyRequest{Id: "missing"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAPIKeyWriteScope(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestAuthServer(t)
+	adminConn := newTestAuthConn(t, serverAddress, "admin1", "secret")
+
+	created, err := pb.NewApiKeyServiceClient(adminConn).CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{
+		Name:   "catalog-writer",
+		Scopes: []string{service.ScopeCatalogWrite},
+	})
+	require.NoError(t, err)
+
+	laptopClient := newTestAPIKeyLaptopClient(t, serverAddress, created.GetKey())
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	// a tampered secret is rejected
+	laptopClient = newTestAPIKeyLaptopClient(t, serverAddress, created.GetKey()+"0")
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func newTestAPIKeyLaptopClient(t *testing.T, serverAddress string, key string) pb.LaptopServiceClient {
+	return pb.NewLaptopServiceClient(newTestAPIKeyConn(t, serverAddress, key))
+}
+
+func newTestAPIKeyConn(t *testing.T, serverAddress string, key string) *grpc.ClientConn {
+	conn, err := grpc.Dial(
+		serverAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(client.NewAPIKeyCredentials(key)),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can be granted, they take the place of roles in the
+// per-RPC authorization map
+const (
+	ScopeCatalogRead  = "catalog:read"
+	ScopeCatalogWrite = "catalog:write"
+)
+
+// prefix of every API key, so leaked keys are easy to recognize
+const apiKeyPrefix = "lak"
+
+// ErrorNotFound is returned when an item does not exist in a store
+var ErrorNotFound = errors.New("not found")
+
+// APIKey contains the information of an API key, the secret is only kept as a hash
+type APIKey struct {
+	ID           string
+	Name         string
+	HashedSecret string
+	Scopes       []string
+	CreatedAt    time.Time
+	Revoked      bool
+}
+
+// NewAPIKey generates an API key with the given scopes. It returns the key
+// and the secret key string, which is the only time the secret is available.
+func NewAPIKey(name string, scopes []string) (*APIKey, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate API key id: %w", err)
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate API key secret: %w", err)
+	}
+
+	apiKey := &APIKey{
+		ID:           id,
+		Name:         name,
+		HashedSecret: hashSecret(secret),
+		Scopes:       append([]string(nil), scopes...),
+		CreatedAt:    time.Now(),
+	}
+
+	return apiKey, fmt.Sprintf("%s_%s_%s", apiKeyPrefix, id, secret), nil
+}
+
+// IsCorrectSecret checks the secret against the stored hash in constant time
+func (apiKey *APIKey) IsCorrectSecret(secret string) bool {
+	hashed := hashSecret(secret)
+	return subtle.ConstantTimeCompare([]byte(hashed), []byte(apiKey.HashedSecret)) == 1
+}
+
+// Clone returns a clone of this API key
+func (apiKey *APIKey) Clone() *APIKey {
+	other := *apiKey
+	other.Scopes = append([]string(nil), apiKey.Scopes...)
+	return &other
+}
+
+// ParseAPIKey splits a key string into its id and secret
+func ParseAPIKey(key string) (string, string, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return "", "", fmt.Errorf("malformed API key")
+	}
+
+	return parts[1], parts[2], nil
+}
+
+// IsKnownScope reports whether scope can be granted to an API key
+func IsKnownScope(scope string) bool {
+	return scope == ScopeCatalogRead || scope == ScopeCatalogWrite
+}
+
+// APIKeyStore is an interface to store API keys
+type APIKeyStore interface {
+	// Save saves an API key to the store
+	Save(apiKey *APIKey) error
+	// Find finds an API key by id
+	Find(id string) (*APIKey, error)
+	// List returns every API key, including revoked ones
+	List() ([]*APIKey, error)
+	// Revoke marks an API key as revoked and returns it
+	Revoke(id string) (*APIKey, error)
+	// Ready returns an error while the store cannot serve requests
+	Ready() error
+}
+
+// InMemoryAPIKeyStore stores API keys in memory
+type InMemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+}
+
+// NewInMemoryAPIKeyStore returns a new in-memory API key store
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys: make(map[string]*APIKey),
+	}
+}
+
+// Save saves an API key to the store
+func (store *InMemoryAPIKeyStore) Save(apiKey *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.keys[apiKey.ID] != nil {
+		return ErrorAlreadyExists
+	}
+
+	store.keys[apiKey.ID] = apiKey.Clone()
+	return nil
+}
+
+// Find finds an API key by id
+func (store *InMemoryAPIKeyStore) Find(id string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKey := store.keys[id]
+	if apiKey == nil {
+		return nil, nil
+	}
+
+	return apiKey.Clone(), nil
+}
+
+// List returns every API key ordered by creation time
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	apiKeys := make([]*APIKey, 0, len(store.keys))
+	for _, apiKey := range store.keys {
+		apiKeys = append(apiKeys, apiKey.Clone())
+	}
+
+	sortAPIKeys(apiKeys)
+	return apiKeys, nil
+}
+
+// Revoke marks an API key as revoked and returns it
+func (store *InMemoryAPIKeyStore) Revoke(id string) (*APIKey, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	apiKey := store.keys[id]
+	if apiKey == nil {
+		return nil, ErrorNotFound
+	}
+
+	apiKey.Revoked = true
+	return apiKey.Clone(), nil
+}
+
+// Ready always succeeds for the in-memory store
+func (store *InMemoryAPIKeyStore) Ready() error {
+	return nil
+}
+
+func sortAPIKeys(apiKeys []*APIKey) {
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+}
+
+func hashSecret(secret string) string {
+	// the secret is 256 random bits, so a fast hash is enough, unlike passwords
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	buffer := make([]byte, size)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// metadata keys that carry the caller's credentials
+const (
+	authorizationHeader = "authorization"
+	apiKeyHeader        = "x-api-key"
+)
+
+// Identity is the authenticated caller of an RPC
+type Identity struct {
+	Name  string
+	Roles []string
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of ctx that carries the identity
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the caller if it was authenticated
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// AuthInterceptor is a server interceptor for authentication and authorization
+type AuthInterceptor struct {
+	jwtManager      *JWTManager
+	apiKeyStore     APIKeyStore
+	accessibleRoles map[string][]string
+	publicMethods   map[string]bool
+}
+
+// NewAuthInterceptor returns a new auth interceptor. Callers authenticate with
+// a JWT access token or, when apiKeyStore is set, with an API key whose scopes
+// count as roles. accessibleRoles maps the full method name of an RPC to the
+// roles allowed to call it, publicMethods can be called without credentials
+// and every other method is denied.
+func NewAuthInterceptor(jwtManager *JWTManager, apiKeyStore APIKeyStore, accessibleRoles map[string][]string, publicMethods []string) *AuthInterceptor {
+	public := make(map[string]bool, len(publicMethods))
+	for _, method := range publicMethods {
+		public[method] = true
+	}
+
+	return &AuthInterceptor{jwtManager, apiKeyStore, accessibleRoles, public}
+}
+
+// Unary returns a server interceptor function to authenticate and authorize unary RPC
+func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor function to authenticate and authorize stream RPC
+func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &contextServerStream{stream, ctx})
+	}
+}
+
+// authorize checks the access token of the request against the roles of the
+// method and returns a context that carries the caller's identity
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	if interceptor.publicMethods[method] {
+		return ctx, nil
+	}
+
+	accessibleRoles, ok := interceptor.accessibleRoles[method]
+	if !ok {
+		// a method nobody listed is not reachable, so a new RPC is never public by mistake
+		return ctx, status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx, status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	}
+
+	identity, err := interceptor.authenticate(md)
+	if err != nil {
+		return ctx, err
+	}
+
+	if !hasAnyRole(identity, accessibleRoles) {
+		return ctx, status.Errorf(codes.PermissionDenied, "no permission to access this RPC")
+	}
+
+	return ContextWithIdentity(ctx, identity), nil
+}
+
+// authenticate returns the identity behind the API key or access token in md
+func (interceptor *AuthInterceptor) authenticate(md metadata.MD) (*Identity, error) {
+	if values := md[apiKeyHeader]; len(values) > 0 && interceptor.apiKeyStore != nil {
+		return interceptor.authenticateAPIKey(values[0])
+	}
+
+	values := md[authorizationHeader]
+	if len(values) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+	}
+
+	accessToken := strings.TrimPrefix(values[0], "Bearer ")
+	claims, err := interceptor.jwtManager.Verify(accessToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+	}
+
+	identity := &Identity{
+		Name:  claims.Username,
+		Roles: []string{claims.Role},
+	}
+	return identity, nil
+}
+
+func (interceptor *AuthInterceptor) authenticateAPIKey(key string) (*Identity, error) {
+	id, secret, err := ParseAPIKey(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "API key is invalid: %v", err)
+	}
+
+	apiKey, err := interceptor.apiKeyStore.Find(id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find API key: %v", err)
+	}
+
+	if apiKey == nil || apiKey.Revoked || !apiKey.IsCorrectSecret(secret) {
+		return nil, status.Errorf(codes.Unauthenticated, "API key is invalid or revoked")
+	}
+
+	identity := &Identity{
+		Name:  "apikey:" + apiKey.ID,
+		Roles: apiKey.Scopes,
+	}
+	return identity, nil
+}
+
+func hasAnyRole(identity *Identity, accessibleRoles []string) bool {
+	for _, role := range accessibleRoles {
+		for _, identityRole := range identity.Roles {
+			if role == identityRole {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// contextServerStream is a server stream with a replaced context
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced context of the stream
+func (stream *contextServerStream) Context() context.Context {
+	return stream.ctx
+}
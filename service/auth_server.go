@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"laptop-app-using-grpc/pb/pb"
+)
+
+// AuthServer is the server for authentication
+type AuthServer struct {
+	userStore  UserStore
+	jwtManager *JWTManager
+}
+
+// NewAuthServer returns a new auth server
+func NewAuthServer(userStore UserStore, jwtManager *JWTManager) *AuthServer {
+	return &AuthServer{userStore, jwtManager}
+}
+
+// Login is a unary RPC to login user
+func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	user, err := server.userStore.Find(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	// the same error for unknown users and wrong passwords, so usernames cannot be probed
+	if user == nil || !user.IsCorrectPassword(req.GetPassword()) {
+		return nil, status.Errorf(codes.NotFound, "incorrect username/password")
+	}
+
+	token, expiresAt, err := server.jwtManager.Generate(user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	res := &pb.LoginResponse{
+		AccessToken: token,
+		ExpiresAt:   timestamppb.New(expiresAt),
+	}
+	return res, nil
+}
@@ -0,0 +1,199 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"laptop-app-using-grpc/client"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	laptopServicePath = "/vyom1611.laptop_app.LaptopService/"
+	apiKeyServicePath = "/vyom1611.laptop_app.ApiKeyService/"
+)
+
+func TestAuthLoginAndRoles(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestAuthServer(t)
+
+	testCases := []struct {
+		name     string
+		username string
+		password string
+		code     codes.Code
+	}{
+		{name: "admin_can_create", username: "admin1", password: "secret", code: codes.OK},
+		{name: "user_cannot_create", username: "user1", password: "secret", code: codes.PermissionDenied},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := newTestAuthConn(t, serverAddress, tc.username, tc.password)
+			laptopClient := pb.NewLaptopServiceClient(conn)
+
+			req := &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}
+			_, err := laptopClient.CreateLaptop(context.Background(), req)
+			require.Equal(t, tc.code, status.Code(err))
+
+			// both roles may search
+			stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{}})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			require.NotEqual(t, codes.PermissionDenied, status.Code(err))
+			require.NotEqual(t, codes.Unauthenticated, status.Code(err))
+		})
+	}
+}
+
+func TestAuthRejectsMissingToken(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestAuthServer(t)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}
+	_, err := laptopClient.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthLoginWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestAuthServer(t)
+	conn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, _, err = client.NewAuthClient(conn, "admin1", "wrong").Login()
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, _, err = client.NewAuthClient(conn, "nobody", "secret").Login()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAuthDeniesUnlistedMethod(t *testing.T) {
+	t.Parallel()
+
+	// RateLaptop is neither protected nor public on the test server
+	serverAddress := startTestAuthServer(t)
+	conn := newTestAuthConn(t, serverAddress, "admin1", "secret")
+
+	stream, err := pb.NewLaptopServiceClient(conn).RateLaptop(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthInterceptorClose(t *testing.T) {
+	t.Parallel()
+
+	// the server hands out short tokens and counts the logins
+	logins := atomic.Int32{}
+	userStore := service.NewInMemoryUserStore()
+	user, err := service.NewUser("admin1", "secret", service.RoleAdmin)
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		logins.Add(1)
+		return handler(ctx, req)
+	}))
+	pb.RegisterAuthServiceServer(grpcServer, service.NewAuthServer(userStore, service.NewJWTManager("test-secret", time.Second)))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// a token for one second is refreshed after half of it, unless closed
+	interceptor, err := client.NewAuthInterceptor(client.NewAuthClient(conn, "admin1", "secret"), nil)
+	require.NoError(t, err)
+	require.NoError(t, interceptor.Close())
+
+	time.Sleep(time.Second)
+	require.Equal(t, int32(1), logins.Load())
+}
+
+func startTestAuthServer(t *testing.T) string {
+	userStore := service.NewInMemoryUserStore()
+	for username, role := range map[string]string{"admin1": service.RoleAdmin, "user1": service.RoleUser} {
+		user, err := service.NewUser(username, "secret", role)
+		require.NoError(t, err)
+		require.NoError(t, userStore.Save(user))
+	}
+
+	jwtManager := service.NewJWTManager("test-secret", time.Minute)
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	authInterceptor := service.NewAuthInterceptor(jwtManager, apiKeyStore, map[string][]string{
+		laptopServicePath + "CreateLaptop": {service.RoleAdmin, service.ScopeCatalogWrite},
+		laptopServicePath + "SearchLaptop": {service.RoleAdmin, service.RoleUser, service.ScopeCatalogRead},
+		apiKeyServicePath + "CreateApiKey": {service.RoleAdmin},
+		apiKeyServicePath + "ListApiKeys":  {service.RoleAdmin},
+		apiKeyServicePath + "RevokeApiKey": {service.RoleAdmin},
+	}, []string{"/vyom1611.laptop_app.AuthService/Login"})
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(authInterceptor.Unary()),
+		grpc.StreamInterceptor(authInterceptor.Stream()),
+	)
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterAuthServiceServer(grpcServer, service.NewAuthServer(userStore, jwtManager))
+	pb.RegisterApiKeyServiceServer(grpcServer, service.NewAPIKeyServer(apiKeyStore))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	return listener.Addr().String()
+}
+
+func newTestAuthConn(t *testing.T, serverAddress string, username string, password string) *grpc.ClientConn {
+	authConn, err := grpc.Dial(serverAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { authConn.Close() })
+
+	interceptor, err := client.NewAuthInterceptor(
+		client.NewAuthClient(authConn, username, password),
+		map[string]bool{
+			laptopServicePath + "CreateLaptop": true,
+			laptopServicePath + "SearchLaptop": true,
+			apiKeyServicePath + "CreateApiKey": true,
+			apiKeyServicePath + "ListApiKeys":  true,
+			apiKeyServicePath + "RevokeApiKey": true,
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { interceptor.Close() })
+
+	conn, err := grpc.Dial(
+		serverAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(interceptor.Unary()),
+		grpc.WithStreamInterceptor(interceptor.Stream()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	apiKeyLogFile      = "api_keys.log"
+	apiKeySnapshotFile = "api_keys.snapshot"
+)
+
+// DiskAPIKeyStore serves API keys from memory and persists every created or
+// revoked key to an append-only log on disk, which is replayed when the store
+// is opened. Like in memory, only the hash of a secret is written.
+type DiskAPIKeyStore struct {
+	*InMemoryAPIKeyStore
+
+	mutex         sync.Mutex
+	dataFolder    string
+	log           *recordLog
+	pending       int
+	snapshotEvery int
+}
+
+// NewDiskAPIKeyStore opens the API key store in dataFolder and replays its
+// snapshot and log. A snapshot is taken after every snapshotEvery writes,
+// zero disables automatic snapshots.
+func NewDiskAPIKeyStore(dataFolder string, snapshotEvery int) (*DiskAPIKeyStore, error) {
+	err := os.MkdirAll(dataFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create data folder: %w", err)
+	}
+
+	store := &DiskAPIKeyStore{
+		InMemoryAPIKeyStore: NewInMemoryAPIKeyStore(),
+		dataFolder:          dataFolder,
+		snapshotEvery:       snapshotEvery,
+	}
+
+	err = store.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	store.log, err = openRecordLog(store.logPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Save persists the API key and adds it to the store
+func (store *DiskAPIKeyStore) Save(apiKey *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	found, err := store.InMemoryAPIKeyStore.Find(apiKey.ID)
+	if err != nil {
+		return err
+	}
+	if found != nil {
+		return ErrorAlreadyExists
+	}
+
+	err = store.append(apiKey)
+	if err != nil {
+		return err
+	}
+
+	err = store.InMemoryAPIKeyStore.Save(apiKey)
+	if err != nil {
+		return err
+	}
+
+	store.pending++
+	store.snapshotIfDue()
+	return nil
+}
+
+// Revoke persists the revocation of an API key and returns the key
+func (store *DiskAPIKeyStore) Revoke(id string) (*APIKey, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	found, err := store.InMemoryAPIKeyStore.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrorNotFound
+	}
+
+	found.Revoked = true
+	err = store.append(found)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := store.InMemoryAPIKeyStore.Revoke(id)
+	if err != nil {
+		return nil, err
+	}
+
+	store.pending++
+	store.snapshotIfDue()
+	return revoked, nil
+}
+
+// Ready reports whether the log takes writes
+func (store *DiskAPIKeyStore) Ready() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.log.Ready()
+}
+
+// Snapshot writes every API key to disk and empties the log
+func (store *DiskAPIKeyStore) Snapshot() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.snapshot()
+}
+
+// Close takes a final snapshot and closes the log file
+func (store *DiskAPIKeyStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return store.log.Close()
+}
+
+// append writes the latest state of an API key to the log
+func (store *DiskAPIKeyStore) append(apiKey *APIKey) error {
+	record, err := json.Marshal(apiKey)
+	if err != nil {
+		return fmt.Errorf("cannot marshal API key: %w", err)
+	}
+
+	return store.log.Append(record)
+}
+
+// snapshotIfDue takes a snapshot once enough writes are pending. The writes
+// are already in the log, so a failed snapshot is logged and tried again on
+// the next write instead of failing the write.
+func (store *DiskAPIKeyStore) snapshotIfDue() {
+	if store.snapshotEvery <= 0 || store.pending < store.snapshotEvery {
+		return
+	}
+
+	err := store.snapshot()
+	if err != nil {
+		slog.Error("cannot take API key snapshot", "error", err)
+	}
+}
+
+func (store *DiskAPIKeyStore) snapshot() error {
+	apiKeys, err := store.InMemoryAPIKeyStore.List()
+	if err != nil {
+		return err
+	}
+
+	records := make([][]byte, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		records[i], err = json.Marshal(apiKey)
+		if err != nil {
+			return fmt.Errorf("cannot marshal API key: %w", err)
+		}
+	}
+
+	err = writeRecordFile(store.snapshotPath(), records)
+	if err != nil {
+		return err
+	}
+
+	err = store.log.Reset()
+	if err != nil {
+		return err
+	}
+
+	store.pending = 0
+	return nil
+}
+
+func (store *DiskAPIKeyStore) replay() error {
+	// every record is the latest state of a key, so a later record replaces
+	// an earlier one, including records that are already in the snapshot
+	restore := func(record []byte) error {
+		apiKey := &APIKey{}
+		err := json.Unmarshal(record, apiKey)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal API key: %w", err)
+		}
+
+		store.InMemoryAPIKeyStore.keys[apiKey.ID] = apiKey
+		return nil
+	}
+
+	_, err := readRecords(store.snapshotPath(), restore)
+	if err != nil {
+		return err
+	}
+
+	end, err := readRecords(store.logPath(), func(record []byte) error {
+		store.pending++
+		return restore(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	// drop a record that was only partly written before a crash
+	return truncateFile(store.logPath(), end)
+}
+
+func (store *DiskAPIKeyStore) logPath() string {
+	return filepath.Join(store.dataFolder, apiKeyLogFile)
+}
+
+func (store *DiskAPIKeyStore) snapshotPath() string {
+	return filepath.Join(store.dataFolder, apiKeySnapshotFile)
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"laptop-app-using-grpc/pb/pb"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	laptopLogFile      = "laptops.log"
+	laptopSnapshotFile = "laptops.snapshot"
+)
+
+// DiskLaptopStore serves laptops from memory and persists every saved laptop
+// to an append-only log on disk, which is replayed when the store is opened
+type DiskLaptopStore struct {
+	*InMemoryLaptopStore
+
+	mutex         sync.Mutex
+	dataFolder    string
+	log           *recordLog
+	pending       int
+	snapshotEvery int
+}
+
+// NewDiskLaptopStore opens the laptop store in dataFolder and replays its
+// snapshot and log. A snapshot is taken after every snapshotEvery saves,
+// zero disables automatic snapshots.
+func NewDiskLaptopStore(dataFolder string, snapshotEvery int) (*DiskLaptopStore, error) {
+	err := os.MkdirAll(dataFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create data folder: %w", err)
+	}
+
+	store := &DiskLaptopStore{
+		InMemoryLaptopStore: NewInMemoryLaptopStore(),
+		dataFolder:          dataFolder,
+		snapshotEvery:       snapshotEvery,
+	}
+
+	err = store.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	store.log, err = openRecordLog(store.logPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Save persists the laptop and adds it to the store
+func (store *DiskLaptopStore) Save(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	found, err := store.InMemoryLaptopStore.Find(laptop.Id)
+	if err != nil {
+		return err
+	}
+	if found != nil {
+		return ErrorAlreadyExists
+	}
+
+	record, err := proto.Marshal(laptop)
+	if err != nil {
+		return fmt.Errorf("cannot marshal laptop: %w", err)
+	}
+
+	err = store.log.Append(record)
+	if err != nil {
+		return err
+	}
+
+	err = store.InMemoryLaptopStore.Save(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.pending++
+	store.snapshotIfDue()
+
+	return nil
+}
+
+// SaveBatch persists the new laptops with one log write and adds them to the
+// store. The records of a batch are written and synced together, but a crash
+// in the middle of the write keeps the records that reached the disk.
+func (store *DiskLaptopStore) SaveBatch(laptops []*pb.Laptop, allOrNothing bool) ([]error, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	errs := store.InMemoryLaptopStore.checkBatch(laptops)
+	if allOrNothing && hasError(errs) {
+		return errs, nil
+	}
+
+	var records [][]byte
+	var saved []*pb.Laptop
+	for i, laptop := range laptops {
+		if errs[i] != nil {
+			continue
+		}
+
+		record, err := proto.Marshal(laptop)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal laptop: %w", err)
+		}
+		records = append(records, record)
+		saved = append(saved, laptop)
+	}
+
+	err := store.log.AppendBatch(records)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = store.InMemoryLaptopStore.SaveBatch(saved, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store.pending += len(saved)
+	store.snapshotIfDue()
+
+	return errs, nil
+}
+
+// Ready reports whether the log takes writes
+func (store *DiskLaptopStore) Ready() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.log.Ready()
+}
+
+// Snapshot writes every laptop to disk and empties the log
+func (store *DiskLaptopStore) Snapshot() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.snapshot()
+}
+
+// Close takes a final snapshot and closes the log file
+func (store *DiskLaptopStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return store.log.Close()
+}
+
+// snapshotIfDue takes a snapshot once enough saves are pending. The saves
+// are already in the log, so a failed snapshot is logged and tried again
+// on the next save instead of failing the save.
+func (store *DiskLaptopStore) snapshotIfDue() {
+	if store.snapshotEvery <= 0 || store.pending < store.snapshotEvery {
+		return
+	}
+
+	err := store.snapshot()
+	if err != nil {
+		slog.Error("cannot take laptop snapshot", "error", err)
+	}
+}
+
+func (store *DiskLaptopStore) snapshot() error {
+	var records [][]byte
+	var err error
+
+	store.InMemoryLaptopStore.forEach(func(laptop *pb.Laptop) {
+		if err != nil {
+			return
+		}
+
+		var record []byte
+		record, err = proto.Marshal(laptop)
+		records = append(records, record)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot marshal laptop: %w", err)
+	}
+
+	err = writeRecordFile(store.snapshotPath(), records)
+	if err != nil {
+		return err
+	}
+
+	err = store.log.Reset()
+	if err != nil {
+		return err
+	}
+
+	store.pending = 0
+	return nil
+}
+
+func (store *DiskLaptopStore) replay() error {
+	// laptops are saved once per id, so a log record that is already in
+	// the snapshot is simply skipped as a duplicate
+	restore := func(record []byte) error {
+		laptop := &pb.Laptop{}
+		err := proto.Unmarshal(record, laptop)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal laptop: %w", err)
+		}
+
+		err = store.InMemoryLaptopStore.Save(laptop)
+		if err == ErrorAlreadyExists {
+			return nil
+		}
+		return err
+	}
+
+	_, err := readRecords(store.snapshotPath(), restore)
+	if err != nil {
+		return err
+	}
+
+	end, err := readRecords(store.logPath(), func(record []byte) error {
+		store.pending++
+		return restore(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	// drop a record that was only partly written before a crash
+	return truncateFile(store.logPath(), end)
+}
+
+func (store *DiskLaptopStore) logPath() string {
+	return filepath.Join(store.dataFolder, laptopLogFile)
+}
+
+func (store *DiskLaptopStore) snapshotPath() string {
+	return filepath.Join(store.dataFolder, laptopSnapshotFile)
+}
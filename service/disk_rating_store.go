@@ -0,0 +1,240 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	ratingLogFile      = "ratings.log"
+	ratingSnapshotFile = "ratings.snapshot"
+)
+
+// DiskRatingStore keeps laptop ratings in memory and persists every rating
+// to an append-only log on disk, which is replayed when the store is opened
+type DiskRatingStore struct {
+	mutex         sync.Mutex
+	rating        map[string]*Rating
+	dataFolder    string
+	log           *recordLog
+	seq           uint64
+	pending       int
+	snapshotEvery int
+}
+
+// ratingEvent is a single rating written to the log
+type ratingEvent struct {
+	Seq      uint64  `json:"seq"`
+	LaptopID string  `json:"laptop_id"`
+	Score    float64 `json:"score"`
+}
+
+// ratingSnapshot is the full rating state up to and including event Seq
+type ratingSnapshot struct {
+	Seq     uint64             `json:"seq"`
+	Ratings map[string]*Rating `json:"ratings"`
+}
+
+// NewDiskRatingStore opens the rating store in dataFolder and replays its
+// snapshot and log. A snapshot is taken after every snapshotEvery ratings,
+// zero disables automatic snapshots.
+func NewDiskRatingStore(dataFolder string, snapshotEvery int) (*DiskRatingStore, error) {
+	err := os.MkdirAll(dataFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create data folder: %w", err)
+	}
+
+	store := &DiskRatingStore{
+		rating:        make(map[string]*Rating),
+		dataFolder:    dataFolder,
+		snapshotEvery: snapshotEvery,
+	}
+
+	err = store.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	store.log, err = openRecordLog(store.logPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Add persists a laptop score and returns the updated rating
+func (store *DiskRatingStore) Add(laptopId string, score float64) (*Rating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	event := ratingEvent{
+		Seq:      store.seq + 1,
+		LaptopID: laptopId,
+		Score:    score,
+	}
+
+	record, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal rating event: %w", err)
+	}
+
+	err = store.log.Append(record)
+	if err != nil {
+		return nil, err
+	}
+
+	rating := store.apply(event)
+	store.pending++
+	store.snapshotIfDue()
+
+	return &Rating{Count: rating.Count, Sum: rating.Sum}, nil
+}
+
+// Find returns a copy of the rating of a laptop, or nil if it has not been rated
+func (store *DiskRatingStore) Find(laptopId string) (*Rating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return copyRating(store.rating[laptopId]), nil
+}
+
+// Count returns the total number of ratings of all laptops
+func (store *DiskRatingStore) Count() uint64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return countRatings(store.rating)
+}
+
+// Ready reports whether the log takes writes
+func (store *DiskRatingStore) Ready() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.log.Ready()
+}
+
+// Snapshot writes the current ratings to disk and empties the log
+func (store *DiskRatingStore) Snapshot() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.snapshot()
+}
+
+// Close takes a final snapshot and closes the log file
+func (store *DiskRatingStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return store.log.Close()
+}
+
+// snapshotIfDue takes a snapshot once enough ratings are pending. The
+// ratings are already in the log, so a failed snapshot is logged and tried
+// again on the next rating instead of failing the rating.
+func (store *DiskRatingStore) snapshotIfDue() {
+	if store.snapshotEvery <= 0 || store.pending < store.snapshotEvery {
+		return
+	}
+
+	err := store.snapshot()
+	if err != nil {
+		slog.Error("cannot take rating snapshot", "error", err)
+	}
+}
+
+func (store *DiskRatingStore) snapshot() error {
+	record, err := json.Marshal(ratingSnapshot{
+		Seq:     store.seq,
+		Ratings: store.rating,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot marshal rating snapshot: %w", err)
+	}
+
+	err = writeRecordFile(store.snapshotPath(), [][]byte{record})
+	if err != nil {
+		return err
+	}
+
+	// events up to seq are in the snapshot now, replay skips them even
+	// if the process dies before the log is emptied
+	err = store.log.Reset()
+	if err != nil {
+		return err
+	}
+
+	store.pending = 0
+	return nil
+}
+
+func (store *DiskRatingStore) replay() error {
+	_, err := readRecords(store.snapshotPath(), func(record []byte) error {
+		snapshot := ratingSnapshot{}
+		err := json.Unmarshal(record, &snapshot)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal rating snapshot: %w", err)
+		}
+
+		store.seq = snapshot.Seq
+		if snapshot.Ratings != nil {
+			store.rating = snapshot.Ratings
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	end, err := readRecords(store.logPath(), func(record []byte) error {
+		event := ratingEvent{}
+		err := json.Unmarshal(record, &event)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal rating event: %w", err)
+		}
+
+		if event.Seq > store.seq {
+			store.apply(event)
+			store.pending++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// drop a record that was only partly written before a crash
+	return truncateFile(store.logPath(), end)
+}
+
+func (store *DiskRatingStore) apply(event ratingEvent) *Rating {
+	rating := store.rating[event.LaptopID]
+	if rating == nil {
+		rating = &Rating{}
+		store.rating[event.LaptopID] = rating
+	}
+
+	rating.Count++
+	rating.Sum += event.Score
+	store.seq = event.Seq
+	return rating
+}
+
+func (store *DiskRatingStore) logPath() string {
+	return filepath.Join(store.dataFolder, ratingLogFile)
+}
+
+func (store *DiskRatingStore) snapshotPath() string {
+	return filepath.Join(store.dataFolder, ratingSnapshotFile)
+}
@@ -0,0 +1,289 @@
+package service_test
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskRatingStoreReplay(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	store, err := service.NewDiskRatingStore(dataFolder, 2)
+	require.NoError(t, err)
+
+	// three ratings: two end up in a snapshot, one stays in the log
+	laptopID := sample.NewLaptop().GetId()
+	for _, score := range []float64{8, 7, 9} {
+		_, err := store.Add(laptopID, score)
+		require.NoError(t, err)
+	}
+
+	// reopening without Close replays snapshot and log like after a crash
+	reopened, err := service.NewDiskRatingStore(dataFolder, 2)
+	require.NoError(t, err)
+
+	rating, err := reopened.Add(laptopID, 6)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), rating.Count)
+	require.Equal(t, float64(30), rating.Sum)
+	require.NoError(t, reopened.Close())
+
+	reopened, err = service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	rating, err = reopened.Add(laptopID, 10)
+	require.NoError(t, err)
+	require.Equal(t, uint32(5), rating.Count)
+	require.Equal(t, float64(40), rating.Sum)
+	require.Equal(t, uint64(5), reopened.Count())
+}
+
+func TestDiskRatingStoreTornWrite(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	store, err := service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	laptopID := sample.NewLaptop().GetId()
+	_, err = store.Add(laptopID, 5)
+	require.NoError(t, err)
+
+	// simulate a crash in the middle of writing the next record
+	file, err := os.OpenFile(filepath.Join(dataFolder, "ratings.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0x40, '{', '"'})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	rating, err := reopened.Add(laptopID, 7)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+	require.Equal(t, float64(12), rating.Sum)
+}
+
+func TestDiskRatingStoreCorruptSize(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	store, err := service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	laptopID := sample.NewLaptop().GetId()
+	_, err = store.Add(laptopID, 5)
+	require.NoError(t, err)
+
+	// a flipped bit turns the size of the next record into a terabyte
+	size := binary.AppendUvarint(nil, 1<<40)
+	file, err := os.OpenFile(filepath.Join(dataFolder, "ratings.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.Write(append(size, '{', '"'))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	rating, err := reopened.Add(laptopID, 7)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+
+	// the corrupt tail was cut off, so the new record replays too
+	reopened, err = service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), reopened.Count())
+}
+
+func TestDiskLaptopStoreReplay(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	store, err := service.NewDiskLaptopStore(dataFolder, 2)
+	require.NoError(t, err)
+
+	laptop1 := sample.NewLaptop()
+	laptop2 := sample.NewLaptop()
+	laptop3 := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2, laptop3} {
+		require.NoError(t, store.Save(laptop))
+	}
+
+	reopened, err := service.NewDiskLaptopStore(dataFolder, 2)
+	require.NoError(t, err)
+
+	for _, laptop := range []*pb.Laptop{laptop1, laptop2, laptop3} {
+		other, err := reopened.Find(laptop.GetId())
+		require.NoError(t, err)
+		require.NotNil(t, other)
+		requireSameLaptop(t, laptop, other)
+	}
+	require.Equal(t, 3, reopened.Count())
+
+	err = reopened.Save(laptop2)
+	require.ErrorIs(t, err, service.ErrorAlreadyExists)
+	require.NoError(t, reopened.Close())
+}
+
+func TestLaptopStoreSaveBatch(t *testing.T) {
+	t.Parallel()
+
+	diskStore, err := service.NewDiskLaptopStore(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	stores := map[string]service.LaptopStore{
+		"memory":  service.NewInMemoryLaptopStore(),
+		"disk":    diskStore,
+		"sharded": service.NewShardedLaptopStore(4),
+	}
+
+	for name, store := range stores {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			existing := sample.NewLaptop()
+			require.NoError(t, store.Save(existing))
+
+			// nothing is saved when one laptop is a duplicate
+			laptop1 := sample.NewLaptop()
+			laptop2 := sample.NewLaptop()
+			errs, err := store.SaveBatch([]*pb.Laptop{laptop1, existing, laptop2}, true)
+			require.NoError(t, err)
+			require.Equal(t, []error{nil, service.ErrorAlreadyExists, nil}, errs)
+			require.Equal(t, 1, store.Count())
+
+			// without allOrNothing the new laptops are saved, a repeated ID counts as a duplicate
+			errs, err = store.SaveBatch([]*pb.Laptop{laptop1, existing, laptop2, laptop1}, false)
+			require.NoError(t, err)
+			require.Equal(t, []error{nil, service.ErrorAlreadyExists, nil, service.ErrorAlreadyExists}, errs)
+			require.Equal(t, 3, store.Count())
+
+			found, err := store.Find(laptop2.GetId())
+			require.NoError(t, err)
+			requireSameLaptop(t, laptop2, found)
+		})
+	}
+}
+
+func TestDiskLaptopStoreSaveBatchReplay(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	store, err := service.NewDiskLaptopStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	errs, err := store.SaveBatch(laptops, true)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, nil, nil}, errs)
+
+	// reopening without Close replays the batch from the log
+	reopened, err := service.NewDiskLaptopStore(dataFolder, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, reopened.Count())
+
+	for _, laptop := range laptops {
+		other, err := reopened.Find(laptop.GetId())
+		require.NoError(t, err)
+		requireSameLaptop(t, laptop, other)
+	}
+	require.NoError(t, reopened.Close())
+}
+
+func TestDiskAPIKeyStoreReplay(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	store, err := service.NewDiskAPIKeyStore(dataFolder, 2)
+	require.NoError(t, err)
+
+	// three writes: two end up in a snapshot, the revocation stays in the log
+	readKey, readSecret, err := service.NewAPIKey("search", []string{service.ScopeCatalogRead})
+	require.NoError(t, err)
+	writeKey, _, err := service.NewAPIKey("importer", []string{service.ScopeCatalogWrite})
+	require.NoError(t, err)
+	require.NoError(t, store.Save(readKey))
+	require.NoError(t, store.Save(writeKey))
+	_, err = store.Revoke(writeKey.ID)
+	require.NoError(t, err)
+
+	// reopening without Close replays snapshot and log like after a crash
+	reopened, err := service.NewDiskAPIKeyStore(dataFolder, 2)
+	require.NoError(t, err)
+
+	apiKeys, err := reopened.List()
+	require.NoError(t, err)
+	require.Len(t, apiKeys, 2)
+
+	_, secret, err := service.ParseAPIKey(readSecret)
+	require.NoError(t, err)
+	found, err := reopened.Find(readKey.ID)
+	require.NoError(t, err)
+	require.True(t, found.IsCorrectSecret(secret))
+	require.Equal(t, []string{service.ScopeCatalogRead}, found.Scopes)
+	require.False(t, found.Revoked)
+
+	found, err = reopened.Find(writeKey.ID)
+	require.NoError(t, err)
+	require.True(t, found.Revoked)
+
+	require.ErrorIs(t, reopened.Save(readKey), service.ErrorAlreadyExists)
+	require.NoError(t, reopened.Close())
+
+	// only the hashes of the secrets are on disk
+	data, err := os.ReadFile(filepath.Join(dataFolder, "api_keys.snapshot"))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), secret)
+}
+
+func TestDiskStoreFailedSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+
+	// a folder in place of the temporary snapshot file makes every snapshot fail
+	for _, name := range []string{"laptops.snapshot.tmp", "ratings.snapshot.tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dataFolder, name), 0755))
+	}
+
+	laptopStore, err := service.NewDiskLaptopStore(dataFolder, 1)
+	require.NoError(t, err)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	errs, err := laptopStore.SaveBatch([]*pb.Laptop{sample.NewLaptop()}, true)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil}, errs)
+
+	ratingStore, err := service.NewDiskRatingStore(dataFolder, 1)
+	require.NoError(t, err)
+
+	_, err = ratingStore.Add(laptop.GetId(), 8)
+	require.NoError(t, err)
+
+	// the writes are still replayed from the log
+	reopenedLaptops, err := service.NewDiskLaptopStore(dataFolder, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, reopenedLaptops.Count())
+
+	reopenedRatings, err := service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), reopenedRatings.Count())
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"laptop-app-using-grpc/validation"
+)
+
+// ErrorDomain is the ErrorInfo domain of the laptop service errors
+const ErrorDomain = "laptop-app.vyom1611"
+
+// Reasons in the ErrorInfo of the laptop service errors, stable enough for
+// clients to switch on
+const (
+	ReasonInvalidLaptop      = "INVALID_LAPTOP"
+	ReasonLaptopNotFound     = "LAPTOP_NOT_FOUND"
+	ReasonImageNotFound      = "IMAGE_NOT_FOUND"
+	ReasonLaptopExists       = "LAPTOP_ALREADY_EXISTS"
+	ReasonImageTooLarge      = "IMAGE_TOO_LARGE"
+	ReasonImportTooLarge     = "IMPORT_TOO_LARGE"
+	ReasonStoreFailure       = "STORE_FAILURE"
+	ReasonStreamFailure      = "STREAM_FAILURE"
+	ReasonRequestCancelled   = "REQUEST_CANCELLED"
+	ReasonDeadlineExceeded   = "DEADLINE_EXCEEDED"
+	ReasonSendTimeout        = "SEND_TIMEOUT"
+	ReasonIDGenerationFailed = "ID_GENERATION_FAILED"
+	ReasonIdempotencyReused  = "IDEMPOTENCY_KEY_REUSED"
+)
+
+// Resource types in the ResourceInfo of the laptop service errors
+const (
+	resourceLaptop = "vyom1611.laptop_app.Laptop"
+	resourceImage  = "vyom1611.laptop_app.Image"
+)
+
+// detailedError returns a status error with an ErrorInfo for reason, the
+// metadata given as key, value pairs, followed by the other details
+func detailedError(code codes.Code, reason string, metadata []string, message string, details ...proto.Message) error {
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain}
+	for i := 0; i+1 < len(metadata); i += 2 {
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string)
+		}
+		info.Metadata[metadata[i]] = metadata[i+1]
+	}
+
+	// built like status.WithDetails, which still takes APIv1 messages
+	st := status.New(code, message).Proto()
+	for _, detail := range append([]proto.Message{info}, details...) {
+		packed, err := anypb.New(detail)
+		if err != nil {
+			return status.Error(code, message)
+		}
+		st.Details = append(st.Details, packed)
+	}
+
+	return status.ErrorProto(st)
+}
+
+// invalidArgumentError rejects a request with one violation per invalid field
+func invalidArgumentError(reason string, message string, violations ...*errdetails.BadRequest_FieldViolation) error {
+	return detailedError(codes.InvalidArgument, reason, nil, message, &errdetails.BadRequest{FieldViolations: violations})
+}
+
+func fieldViolation(field string, format string, args ...interface{}) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: fmt.Sprintf(format, args...)}
+}
+
+// validationError rejects a request whose field at path broke domain rules,
+// or returns nil if there are no violations
+func validationError(path string, violations validation.Violations) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	fieldViolations := make([]*errdetails.BadRequest_FieldViolation, len(violations))
+	for i, violation := range violations {
+		fieldViolations[i] = fieldViolation(validation.Path(path, violation.Path), "%s", violation.Description)
+	}
+
+	first := violations[0]
+	message := fmt.Sprintf("invalid %s: %s %s", path, validation.Path(path, first.Path), first.Description)
+	if len(violations) > 1 {
+		message += fmt.Sprintf(" and %d more", len(violations)-1)
+	}
+
+	return invalidArgumentError(ReasonInvalidLaptop, message, fieldViolations...)
+}
+
+// resourceError reports a missing or conflicting resource of resourceType
+func resourceError(code codes.Code, reason string, resourceType string, name string, description string) error {
+	return detailedError(code, reason, nil, description, &errdetails.ResourceInfo{
+		ResourceType: resourceType,
+		ResourceName: name,
+		Description:  description,
+	})
+}
+
+// storeError reports a failed store call, which is not the client's fault
+func storeError(store string, message string, err error) error {
+	return detailedError(codes.Internal, ReasonStoreFailure, []string{"store", store}, fmt.Sprintf("%s: %v", message, err))
+}
+
+// streamError reports a failed send or receive on the call's stream
+func streamError(message string, err error) error {
+	return detailedError(codes.Unknown, ReasonStreamFailure, nil, fmt.Sprintf("%s: %v", message, err))
+}
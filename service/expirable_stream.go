@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc/tap"
+	"sync"
+)
+
+// ExpirableStreams is a tap handle for grpc.InTapHandle that lets a handler
+// end its own call early with expireStream. grpc-go gives a handler no other
+// way to unblock a Send waiting on a client that stopped reading: the send
+// only returns when the context of the stream is done.
+func ExpirableStreams(ctx context.Context, info *tap.Info) (context.Context, error) {
+	expirable := &expirableContext{Context: ctx, done: make(chan struct{})}
+	context.AfterFunc(ctx, func() {
+		expirable.end(ctx.Err())
+	})
+
+	return expirable, nil
+}
+
+// expireStream ends the call of ctx with context.DeadlineExceeded, so its
+// blocked and later sends fail. It returns false when the server has no
+// ExpirableStreams tap handle.
+func expireStream(ctx context.Context) bool {
+	expirable, ok := ctx.Value(expirableContextKey{}).(*expirableContext)
+	if !ok {
+		return false
+	}
+
+	expirable.end(context.DeadlineExceeded)
+	return true
+}
+
+type expirableContextKey struct{}
+
+// expirableContext is done when its parent is or when it is expired
+type expirableContext struct {
+	context.Context
+	mutex sync.Mutex
+	done  chan struct{}
+	err   error
+}
+
+func (ctx *expirableContext) end(err error) {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
+
+	if ctx.err == nil {
+		ctx.err = err
+		close(ctx.done)
+	}
+}
+
+func (ctx *expirableContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *expirableContext) Err() error {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
+
+	return ctx.err
+}
+
+func (ctx *expirableContext) Value(key interface{}) interface{} {
+	if key == (expirableContextKey{}) {
+		return ctx
+	}
+
+	return ctx.Context.Value(key)
+}
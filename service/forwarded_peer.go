@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/netip"
+)
+
+// ForwardedPeerHeader is the metadata key the HTTP gateway sends the address
+// of its client in
+const ForwardedPeerHeader = "x-forwarded-peer"
+
+// ForwardedPeerInterceptor makes the address in ForwardedPeerHeader the peer
+// of the call, so logs and rate limits see the HTTP client of the gateway
+// instead of the gateway itself. Any client could send the header, so the
+// interceptor only belongs on a server that only the gateway can reach, in
+// front of every other interceptor.
+type ForwardedPeerInterceptor struct{}
+
+// NewForwardedPeerInterceptor returns a new forwarded peer interceptor
+func NewForwardedPeerInterceptor() *ForwardedPeerInterceptor {
+	return &ForwardedPeerInterceptor{}
+}
+
+// Unary returns a server interceptor that replaces the peer of unary RPC
+func (interceptor *ForwardedPeerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(forwardedPeerContext(ctx), req)
+	}
+}
+
+// Stream returns a server interceptor that replaces the peer of streaming RPC
+func (interceptor *ForwardedPeerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, &contextServerStream{stream, forwardedPeerContext(stream.Context())})
+	}
+}
+
+// forwardedPeerContext returns ctx with the forwarded peer, or ctx itself
+// when the call has no forwarded address
+func forwardedPeerContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	values := md.Get(ForwardedPeerHeader)
+	if len(values) == 0 {
+		return ctx
+	}
+
+	addrPort, err := netip.ParseAddrPort(values[0])
+	if err != nil {
+		return ctx
+	}
+
+	forwarded := &peer.Peer{Addr: net.TCPAddrFromAddrPort(addrPort)}
+	if p, ok := peer.FromContext(ctx); ok {
+		forwarded.AuthInfo = p.AuthInfo
+	}
+
+	return peer.NewContext(ctx, forwarded)
+}
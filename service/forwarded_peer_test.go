@@ -0,0 +1,53 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"testing"
+)
+
+func TestForwardedPeerRateLimits(t *testing.T) {
+	t.Parallel()
+
+	forwardedPeer := service.NewForwardedPeerInterceptor()
+	rateLimiter := service.NewRateLimiter(map[string]service.RateLimit{
+		laptopServicePath + "CreateLaptop": {Rate: 0.001, Burst: 1},
+	}, 0)
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(forwardedPeer.Unary(), rateLimiter.Unary()),
+		grpc.ChainStreamInterceptor(forwardedPeer.Stream(), rateLimiter.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	create := func(address string) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), service.ForwardedPeerHeader, address)
+		_, err := laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		return err
+	}
+
+	// every forwarded client has a bucket of its own, though all calls share one connection
+	require.NoError(t, create("203.0.113.1:50000"))
+	require.Equal(t, codes.ResourceExhausted, status.Code(create("203.0.113.1:50001")))
+	require.NoError(t, create("203.0.113.2:50000"))
+	require.NoError(t, create("[2001:db8::1]:50000"))
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ReadinessChecker is implemented by everything a service needs to be ready
+type ReadinessChecker interface {
+	Ready() error
+}
+
+// HealthChecker polls the readiness of every service's dependencies and
+// reports it through the standard gRPC health service. The empty service
+// name is SERVING only when every service is.
+type HealthChecker struct {
+	server   *health.Server
+	interval time.Duration
+
+	mutex    sync.Mutex
+	services map[string][]ReadinessChecker
+	serving  map[string]bool
+	stopped  bool
+	done     chan struct{}
+}
+
+// NewHealthChecker returns a health checker that updates server every interval.
+// Every service is NOT_SERVING until it is checked for the first time.
+func NewHealthChecker(server *health.Server, interval time.Duration) *HealthChecker {
+	server.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	return &HealthChecker{
+		server:   server,
+		interval: interval,
+		services: make(map[string][]ReadinessChecker),
+		serving:  make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+}
+
+// AddService registers a service whose status depends on checkers
+func (checker *HealthChecker) AddService(service string, checkers ...ReadinessChecker) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	checker.services[service] = checkers
+	checker.server.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+}
+
+// Start checks every service now and then in the background until Shutdown
+func (checker *HealthChecker) Start() {
+	checker.Check()
+
+	go func() {
+		ticker := time.NewTicker(checker.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				checker.Check()
+			case <-checker.done:
+				return
+			}
+		}
+	}()
+}
+
+// Check updates the status of every service once
+func (checker *HealthChecker) Check() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	if checker.stopped {
+		return
+	}
+
+	allServing := true
+	for service, checkers := range checker.services {
+		serving := true
+		for _, c := range checkers {
+			err := c.Ready()
+			if err != nil {
+				serving = false
+				if checker.serving[service] {
+					slog.Warn("service is not ready", "service", service, "error", err)
+				}
+				break
+			}
+		}
+
+		if serving && !checker.serving[service] {
+			slog.Info("service is ready", "service", service)
+		}
+
+		checker.serving[service] = serving
+		checker.server.SetServingStatus(service, servingStatus(serving))
+		allServing = allServing && serving
+	}
+
+	checker.server.SetServingStatus("", servingStatus(allServing))
+}
+
+// Shutdown stops checking and sets every service to NOT_SERVING for good
+func (checker *HealthChecker) Shutdown() {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	if checker.stopped {
+		return
+	}
+
+	checker.stopped = true
+	close(checker.done)
+	checker.server.Shutdown()
+}
+
+func servingStatus(serving bool) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if serving {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,85 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/service"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	t.Parallel()
+
+	dataFolder := t.TempDir()
+	imageFolder := filepath.Join(t.TempDir(), "img")
+	require.NoError(t, os.Mkdir(imageFolder, 0755))
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(imageFolder)
+	ratingStore, err := service.NewDiskRatingStore(dataFolder, 0)
+	require.NoError(t, err)
+
+	healthServer := health.NewServer()
+	healthChecker := service.NewHealthChecker(healthServer, time.Hour)
+	laptopService := pb.LaptopService_ServiceDesc.ServiceName
+	authService := pb.AuthService_ServiceDesc.ServiceName
+	healthChecker.AddService(laptopService, laptopStore, imageStore, ratingStore)
+	healthChecker.AddService(authService)
+
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	healthClient := healthpb.NewHealthClient(conn)
+
+	requireStatus := func(service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+		res, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, expected, res.GetStatus(), service)
+	}
+
+	// nothing is serving before the first check
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(laptopService, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	healthChecker.Start()
+	requireStatus("", healthpb.HealthCheckResponse_SERVING)
+	requireStatus(laptopService, healthpb.HealthCheckResponse_SERVING)
+
+	// an image folder that cannot be written to takes the laptop service down
+	require.NoError(t, os.RemoveAll(imageFolder))
+	healthChecker.Check()
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(laptopService, healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(authService, healthpb.HealthCheckResponse_SERVING)
+
+	require.NoError(t, os.Mkdir(imageFolder, 0755))
+	healthChecker.Check()
+	requireStatus(laptopService, healthpb.HealthCheckResponse_SERVING)
+
+	// so does a closed rating store
+	require.NoError(t, ratingStore.Close())
+	healthChecker.Check()
+	requireStatus(laptopService, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	healthChecker.Shutdown()
+	healthChecker.Check()
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(authService, healthpb.HealthCheckResponse_NOT_SERVING)
+}
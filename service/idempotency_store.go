@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyKeyReused is returned when a key is sent again with a different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+
+// IdempotencyStore remembers the responses of requests by their idempotency key
+type IdempotencyStore interface {
+	// Do runs fn once for the key and returns its response. While the key is
+	// remembered, later calls with the same request return a copy of that
+	// response without running fn again, with replayed set to true, and calls
+	// with a different request fail with ErrIdempotencyKeyReused. Failed calls
+	// are not remembered. Waiting for a running call with the same key stops
+	// when ctx is done.
+	Do(ctx context.Context, key string, request proto.Message, fn func() (proto.Message, error)) (response proto.Message, replayed bool, err error)
+}
+
+// InMemoryIdempotencyStore remembers responses in memory for a fixed TTL
+type InMemoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotencyEntry
+	sweepAt time.Time
+}
+
+// idempotencyEntry is the outcome of the first call with a key
+type idempotencyEntry struct {
+	done    chan struct{}
+	request []byte
+	// completed is set when fn succeeded, whatever its response, and the
+	// entry is then kept until expiresAt
+	completed bool
+	response  proto.Message
+	err       error
+	expiresAt time.Time
+}
+
+// NewInMemoryIdempotencyStore returns a store that remembers responses for ttl
+func NewInMemoryIdempotencyStore(ttl time.Duration) *InMemoryIdempotencyStore {
+	return &InMemoryIdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// Do runs fn once per key and replays its response until the key expires
+func (store *InMemoryIdempotencyStore) Do(ctx context.Context, key string, request proto.Message, fn func() (proto.Message, error)) (proto.Message, bool, error) {
+	fingerprint, err := requestFingerprint(request)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for {
+		store.mutex.Lock()
+		now := time.Now()
+		store.sweep(now)
+
+		entry := store.entries[key]
+		if entry != nil && entry.completed && now.After(entry.expiresAt) {
+			delete(store.entries, key)
+			entry = nil
+		}
+
+		if entry == nil {
+			entry = &idempotencyEntry{done: make(chan struct{}), request: fingerprint}
+			store.entries[key] = entry
+			store.mutex.Unlock()
+
+			return store.run(key, entry, fn)
+		}
+		store.mutex.Unlock()
+
+		if !bytes.Equal(entry.request, fingerprint) {
+			return nil, false, ErrIdempotencyKeyReused
+		}
+
+		// the first call may still be running, so wait for its outcome
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+
+		if entry.err == nil {
+			return proto.Clone(entry.response), true, nil
+		}
+		// the first call failed and was forgotten, try again
+	}
+}
+
+func (store *InMemoryIdempotencyStore) run(key string, entry *idempotencyEntry, fn func() (proto.Message, error)) (proto.Message, bool, error) {
+	response, err := fn()
+
+	store.mutex.Lock()
+	if err != nil {
+		delete(store.entries, key)
+		entry.err = err
+	} else {
+		entry.completed = true
+		entry.response = proto.Clone(response)
+		entry.expiresAt = time.Now().Add(store.ttl)
+	}
+	store.mutex.Unlock()
+	close(entry.done)
+
+	return response, false, err
+}
+
+// sweep drops expired entries at most once per TTL
+func (store *InMemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Before(store.sweepAt) {
+		return
+	}
+
+	for key, entry := range store.entries {
+		if entry.completed && now.After(entry.expiresAt) {
+			delete(store.entries, key)
+		}
+	}
+
+	store.sweepAt = now.Add(store.ttl)
+}
+
+// requestFingerprint hashes the request, so a reused key can be told apart from a retry
+func requestFingerprint(request proto.Message) ([]byte, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal request: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
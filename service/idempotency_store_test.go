@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/service"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStoreReplay(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryIdempotencyStore(time.Minute)
+	request := &pb.RateLaptopRequest{LaptopId: "laptop", Score: 8}
+
+	calls := int32(0)
+	create := func() (proto.Message, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return &pb.RateLaptopResponse{RatedCount: uint32(n)}, nil
+	}
+
+	// concurrent duplicates wait for the first call and share its response
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, _, err := store.Do(context.Background(), "key", request, create)
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(1), res.(*pb.RateLaptopResponse).GetRatedCount())
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	_, replayed, err := store.Do(context.Background(), "key", request, create)
+	require.NoError(t, err)
+	require.True(t, replayed)
+
+	_, replayed, err = store.Do(context.Background(), "other-key", request, create)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyStoreFailureAndExpiry(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryIdempotencyStore(50 * time.Millisecond)
+	request := &pb.CreateLaptopRequest{IdempotencyKey: "key"}
+
+	_, _, err := store.Do(context.Background(), "key", request, func() (proto.Message, error) {
+		return nil, errors.New("failed")
+	})
+	require.Error(t, err)
+
+	// a failed call is forgotten, so the retry runs
+	_, replayed, err := store.Do(context.Background(), "key", request, func() (proto.Message, error) {
+		return &pb.CreateLaptopResponse{Id: "first"}, nil
+	})
+	require.NoError(t, err)
+	require.False(t, replayed)
+
+	time.Sleep(100 * time.Millisecond)
+
+	res, replayed, err := store.Do(context.Background(), "key", request, func() (proto.Message, error) {
+		return &pb.CreateLaptopResponse{Id: "second"}, nil
+	})
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, "second", res.(*pb.CreateLaptopResponse).GetId())
+}
+
+func TestIdempotencyStoreNilResponseExpiry(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryIdempotencyStore(50 * time.Millisecond)
+	request := &pb.CreateLaptopRequest{IdempotencyKey: "key"}
+
+	calls := int32(0)
+	empty := func() (proto.Message, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	}
+
+	// a call without a response is remembered like any other
+	_, _, err := store.Do(context.Background(), "key", request, empty)
+	require.NoError(t, err)
+	_, replayed, err := store.Do(context.Background(), "key", request, empty)
+	require.NoError(t, err)
+	require.True(t, replayed)
+
+	// and expires like any other
+	time.Sleep(100 * time.Millisecond)
+
+	_, replayed, err = store.Do(context.Background(), "key", request, empty)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyStoreReusedKey(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryIdempotencyStore(time.Minute)
+	create := func() (proto.Message, error) {
+		return &pb.RateLaptopResponse{RatedCount: 1}, nil
+	}
+
+	_, _, err := store.Do(context.Background(), "key", &pb.RateLaptopRequest{LaptopId: "laptop", Score: 8}, create)
+	require.NoError(t, err)
+
+	_, _, err = store.Do(context.Background(), "key", &pb.RateLaptopRequest{LaptopId: "laptop", Score: 2}, create)
+	require.ErrorIs(t, err, service.ErrIdempotencyKeyReused)
+}
+
+func TestIdempotencyStoreWaitCancelled(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryIdempotencyStore(time.Minute)
+	request := &pb.RateLaptopRequest{LaptopId: "laptop", Score: 8}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go store.Do(context.Background(), "key", request, func() (proto.Message, error) {
+		close(started)
+		<-release
+		return &pb.RateLaptopResponse{RatedCount: 1}, nil
+	})
+	<-started
+	defer close(release)
+
+	// the duplicate stops waiting for the first call when its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err := store.Do(ctx, "key", request, func() (proto.Message, error) {
+		return nil, errors.New("must not run")
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -4,13 +4,25 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/google/uuid"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 )
 
 //ImageStore is interface for storing laptop images
 type ImageStore interface {
 	Save(laptopId string, imageType string, imageData bytes.Buffer) (string, error)
+	//Find returns the info of an image, or nil if there is no such image
+	Find(imageID string) (*ImageInfo, error)
+	//FindByLaptop returns the info of every image of a laptop, ordered by image ID
+	FindByLaptop(laptopID string) ([]*ImageInfo, error)
+	//Usage returns the number of images and their total size in bytes
+	Usage() (int, int64)
+	//Ready returns an error while the store cannot save images
+	Ready() error
 }
 
 //DiskImageStore stores images on disk and its info on memory
@@ -18,21 +30,71 @@ type DiskImageStore struct {
 	mutex       sync.RWMutex
 	imageFolder string
 	images      map[string]*ImageInfo
+	totalSize   int64
 }
 
 //ImageInfo contains information of laptop image
 type ImageInfo struct {
+	ID       string
 	LaptopID string
 	Type     string
 	Path     string
+	Size     int64
 }
 
-//NewDiskImageStore returns a new DiskImageStore
+//NewDiskImageStore returns a new DiskImageStore with the images already in
+//the image folder. The laptop of an image is not kept on disk, so those
+//images count for Usage and Find but not for FindByLaptop.
 func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	return &DiskImageStore{
+	store := &DiskImageStore{
 		imageFolder: imageFolder,
 		images:      make(map[string]*ImageInfo),
 	}
+
+	err := store.scan()
+	if err != nil {
+		slog.Warn("cannot scan image folder", "folder", imageFolder, "error", err)
+	}
+
+	return store
+}
+
+//scan adds the images in the image folder, which are named by their image ID and type
+func (store *DiskImageStore) scan() error {
+	entries, err := os.ReadDir(store.imageFolder)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		imageType := filepath.Ext(entry.Name())
+		imageID := strings.TrimSuffix(entry.Name(), imageType)
+		if parsed, err := uuid.Parse(imageID); err != nil || parsed.String() != imageID {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		store.images[imageID] = &ImageInfo{
+			ID:   imageID,
+			Type: imageType,
+			Path: fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType),
+			Size: info.Size(),
+		}
+		store.totalSize += info.Size()
+	}
+
+	return nil
 }
 
 //Save saves a new laptop to the store
@@ -52,7 +114,7 @@ func (store *DiskImageStore) Save(
 		return "", fmt.Errorf("Cannot create image file: %w", err)
 	}
 
-	_, err = imageData.WriteTo(file)
+	size, err := imageData.WriteTo(file)
 	if err != nil {
 		return "", fmt.Errorf("Cannot write image to file: %w", err)
 	}
@@ -61,10 +123,65 @@ func (store *DiskImageStore) Save(
 	defer store.mutex.Unlock()
 
 	store.images[imageID.String()] = &ImageInfo{
+		ID:       imageID.String(),
 		LaptopID: laptopID,
 		Type:     imageType,
 		Path:     imagePath,
+		Size:     size,
 	}
+	store.totalSize += size
 
 	return imageID.String(), nil
 }
+
+//Find returns a copy of the image info by its id
+func (store *DiskImageStore) Find(imageID string) (*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return nil, nil
+	}
+
+	other := *info
+	return &other, nil
+}
+
+//FindByLaptop returns copies of the info of every image of a laptop, ordered by image ID
+func (store *DiskImageStore) FindByLaptop(laptopID string) ([]*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var infos []*ImageInfo
+	for _, info := range store.images {
+		if info.LaptopID == laptopID {
+			other := *info
+			infos = append(infos, &other)
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+//Usage returns the number of images and their total size in bytes
+func (store *DiskImageStore) Usage() (int, int64) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.images), store.totalSize
+}
+
+//Ready checks that a file can be created in the image folder
+func (store *DiskImageStore) Ready() error {
+	file, err := os.CreateTemp(store.imageFolder, ".ready-*")
+	if err != nil {
+		return fmt.Errorf("image folder is not writable: %w", err)
+	}
+
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,42 @@
+package service_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"laptop-app-using-grpc/service"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskImageStoreScan(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+
+	store := service.NewDiskImageStore(imageFolder)
+	imageID, err := store.Save("laptop", ".jpg", *bytes.NewBufferString("image data"))
+	require.NoError(t, err)
+
+	// files that are not named by an image ID are not images
+	require.NoError(t, os.WriteFile(filepath.Join(imageFolder, "notes.txt"), []byte("notes"), 0644))
+
+	// a new store, like after a restart, finds the image on disk
+	reopened := service.NewDiskImageStore(imageFolder)
+	count, size := reopened.Usage()
+	require.Equal(t, 1, count)
+	require.Equal(t, int64(len("image data")), size)
+
+	info, err := reopened.Find(imageID)
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	require.Equal(t, ".jpg", info.Type)
+
+	data, err := os.ReadFile(info.Path)
+	require.NoError(t, err)
+	require.Equal(t, "image data", string(data))
+
+	empty := service.NewDiskImageStore(filepath.Join(imageFolder, "missing"))
+	count, _ = empty.Usage()
+	require.Zero(t, count)
+}
@@ -2,20 +2,28 @@ package service_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"io"
 	"laptop-app-using-grpc/pb/pb"
 	"laptop-app-using-grpc/sample"
 	"laptop-app-using-grpc/serializer"
 	"laptop-app-using-grpc/service"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // Unit-Tests for all RPCs created and used on client-side
@@ -133,6 +141,239 @@ func TestClientSearchLaptop(t *testing.T) {
 	require.Equal(t, len(expectedIDs), found)
 }
 
+// searchRecorder counts the laptops a search visits and sends the error it ends with
+type searchRecorder struct {
+	*service.InMemoryLaptopStore
+	visited atomic.Int64
+	done    chan error
+}
+
+func newSearchRecorder(t *testing.T, laptops int) *searchRecorder {
+	store := &searchRecorder{InMemoryLaptopStore: service.NewInMemoryLaptopStore(), done: make(chan error, 1)}
+	for i := 0; i < laptops; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	return store
+}
+
+func (store *searchRecorder) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	err := store.InMemoryLaptopStore.Search(ctx, filter, func(laptop *pb.Laptop) error {
+		store.visited.Add(1)
+		return found(laptop)
+	})
+
+	store.done <- err
+	return err
+}
+
+func (store *searchRecorder) requireSearchEnded(t *testing.T) error {
+	select {
+	case err := <-store.done:
+		return err
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "search goes on after the client stopped reading")
+		return nil
+	}
+}
+
+// newSlowSearchClient returns a client with small flow control windows, so a
+// server sending to it blocks soon after it stops reading
+func newSlowSearchClient(t *testing.T, serverAddress string) pb.LaptopServiceClient {
+	conn, err := grpc.Dial(
+		serverAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithInitialWindowSize(1<<16),
+		grpc.WithInitialConnWindowSize(1<<16),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewLaptopServiceClient(conn)
+}
+
+func TestClientSearchLaptopSendTimeout(t *testing.T) {
+	t.Parallel()
+
+	const laptops = 3000
+	store := newSearchRecorder(t, laptops)
+	serverAddress := startTestLaptopServer(t, store, nil, nil, service.WithSearchSendTimeout(200*time.Millisecond))
+	laptopClient := newSlowSearchClient(t, serverAddress)
+
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: math.MaxFloat64}}
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	// the client takes one laptop and stops reading
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	require.Error(t, store.requireSearchEnded(t))
+	visited := store.visited.Load()
+	require.Less(t, visited, int64(laptops))
+
+	// the results sent before the timeout are still delivered, then the error
+	received := 1
+	for {
+		_, err = stream.Recv()
+		if err != nil {
+			break
+		}
+		received++
+	}
+	// the expired send ends the call, so the detail of the timeout stays in the server logs
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Less(t, int64(received), visited)
+}
+
+func TestClientSearchLaptopSendTimeoutNoLateSend(t *testing.T) {
+	t.Parallel()
+
+	const laptops = 3000
+	store := newSearchRecorder(t, laptops)
+	sends := &sendTracker{}
+	grpcServer := grpc.NewServer(
+		grpc.InTapHandle(service.ExpirableStreams),
+		grpc.StreamInterceptor(sends.Stream),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(store, nil, nil, service.WithSearchSendTimeout(200*time.Millisecond)))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	laptopClient := newSlowSearchClient(t, listener.Addr().String())
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: math.MaxFloat64}}
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	// the client takes one laptop and stops reading until the handler is gone
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.Error(t, store.requireSearchEnded(t))
+	sends.requireReturned(t)
+
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.False(t, sends.late.Load(), "a send ran after the handler returned")
+}
+
+// sendTracker records whether a send of a stream was still running, or
+// started, after its handler returned
+type sendTracker struct {
+	active   atomic.Int32
+	returned atomic.Bool
+	late     atomic.Bool
+}
+
+func (tracker *sendTracker) Stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, &trackedServerStream{stream, tracker})
+	if tracker.active.Load() > 0 {
+		tracker.late.Store(true)
+	}
+	tracker.returned.Store(true)
+
+	return err
+}
+
+func (tracker *sendTracker) requireReturned(t *testing.T) {
+	require.Eventually(t, tracker.returned.Load, time.Second, 10*time.Millisecond)
+}
+
+type trackedServerStream struct {
+	grpc.ServerStream
+	tracker *sendTracker
+}
+
+func (stream *trackedServerStream) SendMsg(m interface{}) error {
+	stream.tracker.active.Add(1)
+	defer stream.tracker.active.Add(-1)
+
+	if stream.tracker.returned.Load() {
+		stream.tracker.late.Store(true)
+	}
+	return stream.ServerStream.SendMsg(m)
+}
+
+func TestClientSearchLaptopClientGone(t *testing.T) {
+	t.Parallel()
+
+	const laptops = 3000
+	store := newSearchRecorder(t, laptops)
+	serverAddress := startTestLaptopServer(t, store, nil, nil, service.WithSearchSendTimeout(0))
+	laptopClient := newSlowSearchClient(t, serverAddress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: math.MaxFloat64}}
+	stream, err := laptopClient.SearchLaptop(ctx, req)
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// the client stops reading, then goes away while the server is blocked
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	require.Error(t, store.requireSearchEnded(t))
+	require.Less(t, store.visited.Load(), int64(laptops))
+}
+
+func TestClientSearchLaptopMaxResults(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		laptops   int
+		received  int
+		truncated bool
+	}{
+		{name: "more laptops", laptops: 20, received: 5, truncated: true},
+		{name: "as many laptops", laptops: 5, received: 5},
+		{name: "fewer laptops", laptops: 3, received: 3},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := newSearchRecorder(t, tc.laptops)
+			serverAddress := startTestLaptopServer(t, store, nil, nil, service.WithMaxSearchResults(5))
+			laptopClient := newTestLaptopClient(t, serverAddress)
+
+			req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: math.MaxFloat64}}
+			stream, err := laptopClient.SearchLaptop(context.Background(), req)
+			require.NoError(t, err)
+
+			received := 0
+			for {
+				_, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				received++
+			}
+
+			require.Equal(t, tc.received, received)
+			err = store.requireSearchEnded(t)
+			if tc.truncated {
+				// the scan stops with an error at the first laptop over the limit
+				require.Error(t, err)
+				require.Equal(t, metadata.MD{service.SearchTruncatedTrailer: []string{"true"}}, stream.Trailer())
+				require.Equal(t, int64(6), store.visited.Load())
+			} else {
+				require.NoError(t, err)
+				require.Empty(t, stream.Trailer())
+			}
+		})
+	}
+}
+
 func TestClientUploadImage(t *testing.T) {
 	t.Parallel()
 
@@ -142,6 +383,8 @@ func TestClientUploadImage(t *testing.T) {
 	// Creating a laptop and image store for testing
 	laptopStore := service.NewInMemoryLaptopStore()
 	imageStore := service.NewDiskImageStore(testImageFolder)
+	// the images already in the folder are counted too
+	countBefore, sizeBefore := imageStore.Usage()
 
 	laptop := sample.NewLaptop()
 
@@ -214,12 +457,257 @@ func TestClientUploadImage(t *testing.T) {
 	require.NotZero(t, laptop.GetId())
 	require.EqualValues(t, size, res.GetSize())
 
+	count, totalSize := imageStore.Usage()
+	require.Equal(t, countBefore+1, count)
+	require.EqualValues(t, sizeBefore+int64(size), totalSize)
+
 	savedImagePath := fmt.Sprintf("%s/%s%s", testImageFolder, res.GetId(), imageType)
 
 	require.FileExists(t, savedImagePath)
 	require.NoError(t, os.Remove(savedImagePath))
 }
 
+func TestClientUploadImageTooLarge(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, service.NewDiskImageStore(t.TempDir()), nil, service.WithMaxImageSize(10))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"}},
+	}))
+
+	// The send may fail once the server gave up, the status explains why
+	_ = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: make([]byte, 11)},
+	})
+	_, err = stream.CloseAndRecv()
+	st := requireErrorReason(t, err, codes.InvalidArgument, service.ReasonImageTooLarge)
+
+	info := requireDetail[*errdetails.ErrorInfo](t, st)
+	require.Equal(t, map[string]string{"size": "11", "max_size": "10"}, info.GetMetadata())
+	badRequest := requireDetail[*errdetails.BadRequest](t, st)
+	require.Equal(t, "chunk_data", badRequest.GetFieldViolations()[0].GetField())
+}
+
+func TestClientDownloadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(t.TempDir())
+
+	// The image spans several chunks
+	image := bytes.Repeat([]byte("laptop image "), 10000)
+	imageID, err := imageStore.Save("laptop-1", ".jpg", *bytes.NewBuffer(image))
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: imageID})
+	require.NoError(t, err)
+
+	// The info comes first, then the data
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "laptop-1", res.GetInfo().GetLaptopId())
+	require.Equal(t, ".jpg", res.GetInfo().GetImageType())
+
+	var downloaded []byte
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.NotEmpty(t, res.GetChunkData())
+		downloaded = append(downloaded, res.GetChunkData()...)
+	}
+	require.Equal(t, image, downloaded)
+
+	stream, err = laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{ImageId: "unknown"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	st := requireErrorReason(t, err, codes.NotFound, service.ReasonImageNotFound)
+	require.Equal(t, "unknown", requireDetail[*errdetails.ResourceInfo](t, st).GetResourceName())
+}
+
+func TestClientImportLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(existing))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	noID := sample.NewLaptop()
+	noID.Id = ""
+	invalid := sample.NewLaptop()
+	invalid.Cpu.CpuThreads = invalid.Cpu.CpuCores - 1
+	invalid.PriceUsd = -1
+
+	res := importTestLaptops(t, laptopClient, false, sample.NewLaptop(), existing, noID, invalid)
+	require.EqualValues(t, 2, res.GetCreatedCount())
+	require.EqualValues(t, 1, res.GetDuplicateCount())
+	require.EqualValues(t, 1, res.GetInvalidCount())
+	require.Equal(t, 3, laptopStore.Count())
+
+	results := res.GetResults()
+	require.Len(t, results, 4)
+	require.Equal(t, pb.ImportLaptopResult_CREATED, results[0].GetOutcome())
+	require.Equal(t, pb.ImportLaptopResult_DUPLICATE, results[1].GetOutcome())
+	require.Equal(t, existing.GetId(), results[1].GetLaptopId())
+	require.Equal(t, pb.ImportLaptopResult_CREATED, results[2].GetOutcome())
+	require.NotEmpty(t, results[2].GetLaptopId())
+	require.Equal(t, pb.ImportLaptopResult_INVALID, results[3].GetOutcome())
+	require.EqualValues(t, 3, results[3].GetIndex())
+	require.Len(t, results[3].GetReasons(), 2)
+	require.Contains(t, results[3].GetReasons()[0], "laptop.cpu.cpu_threads")
+
+	found, err := laptopStore.Find(results[2].GetLaptopId())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+}
+
+func TestClientImportLaptopsAllOrNothing(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	invalid := sample.NewLaptop()
+	invalid.Brand = ""
+
+	// one invalid laptop keeps the others from being saved
+	res := importTestLaptops(t, laptopClient, true, sample.NewLaptop(), invalid, sample.NewLaptop())
+	require.Zero(t, res.GetCreatedCount())
+	require.EqualValues(t, 1, res.GetInvalidCount())
+	require.Equal(t, pb.ImportLaptopResult_ABORTED, res.GetResults()[0].GetOutcome())
+	require.Equal(t, pb.ImportLaptopResult_INVALID, res.GetResults()[1].GetOutcome())
+	require.Equal(t, pb.ImportLaptopResult_ABORTED, res.GetResults()[2].GetOutcome())
+	require.Zero(t, laptopStore.Count())
+
+	// so does a duplicate, which is only found by the store
+	laptop := sample.NewLaptop()
+	res = importTestLaptops(t, laptopClient, true, laptop, sample.NewLaptop(), laptop)
+	require.Zero(t, res.GetCreatedCount())
+	require.EqualValues(t, 1, res.GetDuplicateCount())
+	require.Equal(t, pb.ImportLaptopResult_ABORTED, res.GetResults()[0].GetOutcome())
+	require.Equal(t, pb.ImportLaptopResult_DUPLICATE, res.GetResults()[2].GetOutcome())
+	require.Zero(t, laptopStore.Count())
+
+	// more laptops than one batch are saved together
+	laptops := make([]*pb.Laptop, 250)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+	res = importTestLaptops(t, laptopClient, true, laptops...)
+	require.EqualValues(t, 250, res.GetCreatedCount())
+	require.Equal(t, 250, laptopStore.Count())
+}
+
+func importTestLaptops(t *testing.T, laptopClient pb.LaptopServiceClient, allOrNothing bool, laptops ...*pb.Laptop) *pb.ImportLaptopsResponse {
+	stream, err := laptopClient.ImportLaptops(context.Background())
+	require.NoError(t, err)
+
+	for _, laptop := range laptops {
+		require.NoError(t, stream.Send(&pb.ImportLaptopsRequest{Laptop: laptop, AllOrNothing: allOrNothing}))
+	}
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	return res
+}
+
+func TestClientExportLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	ratingStore := service.NewInMemoryRatingStore()
+
+	laptops := make(map[string]*pb.Laptop)
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = float64(1000 * (i + 1))
+		require.NoError(t, laptopStore.Save(laptop))
+		laptops[laptop.GetId()] = laptop
+	}
+
+	var rated, pictured *pb.Laptop
+	for _, laptop := range laptops {
+		if rated == nil {
+			rated = laptop
+		} else if pictured == nil {
+			pictured = laptop
+		}
+	}
+
+	_, err := ratingStore.Add(rated.GetId(), 4)
+	require.NoError(t, err)
+	_, err = ratingStore.Add(rated.GetId(), 5)
+	require.NoError(t, err)
+
+	imageID, err := imageStore.Save(pictured.GetId(), ".jpg", *bytes.NewBufferString("image"))
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	exported := exportTestLaptops(t, laptopClient, &pb.ExportLaptopsRequest{IncludeRatings: true, IncludeImages: true})
+	require.Len(t, exported, 3)
+	for _, res := range exported {
+		requireSameLaptop(t, laptops[res.GetLaptop().GetId()], res.GetLaptop())
+
+		switch res.GetLaptop().GetId() {
+		case rated.GetId():
+			require.EqualValues(t, 2, res.GetRating().GetRatedCount())
+			require.Equal(t, 4.5, res.GetRating().GetAverageScore())
+			require.Empty(t, res.GetImages())
+		case pictured.GetId():
+			require.Nil(t, res.GetRating())
+			require.Len(t, res.GetImages(), 1)
+			require.Equal(t, imageID, res.GetImages()[0].GetImageId())
+			require.Equal(t, ".jpg", res.GetImages()[0].GetImageType())
+			require.EqualValues(t, 5, res.GetImages()[0].GetSize())
+		}
+	}
+
+	// ratings and images are left out unless asked for
+	filter := &pb.Filter{MaxPriceUsd: 1500}
+	exported = exportTestLaptops(t, laptopClient, &pb.ExportLaptopsRequest{Filter: filter})
+	require.Len(t, exported, 1)
+	require.Equal(t, 1000.0, exported[0].GetLaptop().GetPriceUsd())
+	require.Nil(t, exported[0].GetRating())
+	require.Empty(t, exported[0].GetImages())
+}
+
+func exportTestLaptops(t *testing.T, laptopClient pb.LaptopServiceClient, req *pb.ExportLaptopsRequest) []*pb.ExportLaptopsResponse {
+	stream, err := laptopClient.ExportLaptops(context.Background(), req)
+	require.NoError(t, err)
+
+	var exported []*pb.ExportLaptopsResponse
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return exported
+		}
+
+		require.NoError(t, err)
+		exported = append(exported, res)
+	}
+}
+
 func TestClientRateLaptop(t *testing.T) {
 	t.Parallel()
 
@@ -267,11 +755,103 @@ func TestClientRateLaptop(t *testing.T) {
 	}
 }
 
-func startTestLaptopServer(t *testing.T, laptopStore service.LaptopStore, imageStore service.ImageStore, ratingStore service.RatingStore) string {
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+func TestClientRateLaptopIdempotent(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+	idempotencyStore := service.NewInMemoryIdempotencyStore(time.Minute)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore, service.WithIdempotencyStore(idempotencyStore))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+
+	// the second request is a retry of the first one and must not count again
+	requests := []*pb.RateLaptopRequest{
+		{LaptopId: laptop.GetId(), Score: 8, IdempotencyKey: "rate-1"},
+		{LaptopId: laptop.GetId(), Score: 8, IdempotencyKey: "rate-1"},
+		{LaptopId: laptop.GetId(), Score: 6, IdempotencyKey: "rate-2"},
+	}
+	counts := []uint32{1, 1, 2}
+	averages := []float64{8, 8, 7}
+
+	for _, req := range requests {
+		require.NoError(t, stream.Send(req))
+	}
+	require.NoError(t, stream.CloseSend())
+
+	for idx := 0; ; idx++ {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			require.Equal(t, len(requests), idx)
+			return
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, counts[idx], res.GetRatedCount())
+		require.Equal(t, averages[idx], res.GetAverageScore())
+	}
+}
+
+func TestClientRateLaptopIdempotencyHeader(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	ratingStore := service.NewInMemoryRatingStore()
+	idempotencyStore := service.NewInMemoryIdempotencyStore(time.Minute)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore, service.WithIdempotencyStore(idempotencyStore))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	rate := func(scores ...float64) []*pb.RateLaptopResponse {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "idempotency-key", "rate-stream")
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+
+		for _, score := range scores {
+			require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: score}))
+		}
+		require.NoError(t, stream.CloseSend())
+
+		var responses []*pb.RateLaptopResponse
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return responses
+			}
+			require.NoError(t, err)
+			responses = append(responses, res)
+		}
+	}
+
+	first := rate(8, 6)
+	require.Len(t, first, 2)
+	require.Equal(t, uint32(2), first[1].GetRatedCount())
+
+	// the retried stream replays the sent requests and rates only the new one
+	retried := rate(8, 6, 10)
+	require.Len(t, retried, 3)
+	require.Equal(t, uint32(1), retried[0].GetRatedCount())
+	require.Equal(t, uint32(2), retried[1].GetRatedCount())
+	require.Equal(t, uint32(3), retried[2].GetRatedCount())
+	require.Equal(t, float64(8), retried[2].GetAverageScore())
+}
+
+func startTestLaptopServer(t *testing.T, laptopStore service.LaptopStore, imageStore service.ImageStore, ratingStore service.RatingStore, opts ...service.LaptopServerOption) string {
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore, opts...)
 
 	//Creating a server using grpc
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.InTapHandle(service.ExpirableStreams))
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 
 	//Establishing a tcp connection on a random available port
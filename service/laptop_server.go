@@ -4,45 +4,150 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"laptop-app-using-grpc/pb/pb"
-	"log"
+	"laptop-app-using-grpc/tracing"
+	"laptop-app-using-grpc/validation"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"time"
 )
 
-// maximum 1 megabyte
-const maxImageSize = 1 << 20
+// DefaultMaxImageSize is the largest image accepted unless configured otherwise, 1 megabyte
+const DefaultMaxImageSize = 1 << 20
+
+// size of the chunks DownloadImage sends
+const downloadChunkSize = 32 << 10
+
+// number of laptops ImportLaptops saves per batch
+const importBatchSize = 100
+
+// the most laptops an all-or-nothing import may hold until it is saved
+const maxAllOrNothingImport = 10000
+
+// idempotencyKeyHeader is the metadata key clients may use instead of the request field
+const idempotencyKeyHeader = "idempotency-key"
+
+// DefaultSearchSendTimeout is how long a search waits for the client to take
+// a result unless configured otherwise
+const DefaultSearchSendTimeout = 30 * time.Second
+
+// SearchTruncatedTrailer is set to "true" in the trailer of a search that
+// stopped at the maximum number of results
+const SearchTruncatedTrailer = "search-truncated"
+
+// errSearchLimit stops a search at the maximum number of results
+var errSearchLimit = errors.New("search result limit reached")
+
+// errSendTimeout is returned for a result the client did not take in time
+var errSendTimeout = errors.New("send timed out")
 
 // LaptopServer which provides the services
 type LaptopServer struct {
-	laptopStore LaptopStore
-	imageStore  ImageStore
-	RatingStore RatingStore
+	laptopStore      LaptopStore
+	imageStore       ImageStore
+	RatingStore      RatingStore
+	idempotencyStore IdempotencyStore
+	maxImageSize     int
+
+	maxSearchResults  int
+	searchSendTimeout time.Duration
+}
+
+// LaptopServerOption configures optional behaviour of the laptop server
+type LaptopServerOption func(server *LaptopServer)
+
+// WithIdempotencyStore makes the server replay responses of requests that carry an idempotency key
+func WithIdempotencyStore(idempotencyStore IdempotencyStore) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.idempotencyStore = idempotencyStore
+	}
+}
+
+// WithMaxImageSize sets the largest image in bytes that UploadImage accepts
+func WithMaxImageSize(size int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImageSize = size
+	}
+}
+
+// WithMaxSearchResults stops a search after this many results, zero for no limit
+func WithMaxSearchResults(maxResults int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxSearchResults = maxResults
+	}
+}
+
+// WithSearchSendTimeout ends a search whose client does not take a result
+// within the timeout, zero waits as long as the call lasts
+func WithSearchSendTimeout(timeout time.Duration) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.searchSendTimeout = timeout
+	}
 }
 
 // NewLaptopServer Returning a new laptop server
-func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
-	return &LaptopServer{laptopStore, imageStore, ratingStore}
+func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore, opts ...LaptopServerOption) *LaptopServer {
+	server := &LaptopServer{
+		laptopStore:       laptopStore,
+		imageStore:        imageStore,
+		RatingStore:       ratingStore,
+		maxImageSize:      DefaultMaxImageSize,
+		searchSendTimeout: DefaultSearchSendTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	return server
 }
 
 // CreateLaptop Creating the unary RPC to create a new laptop
 func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
-	laptop := req.GetLaptop()
-	log.Println("received a create laptop request using id: ", laptop.Id)
+	key := req.GetIdempotencyKey()
+	if len(key) == 0 {
+		key = idempotencyKeyFromContext(ctx)
+	}
 
-	if len(laptop.Id) > 0 {
-		//Checking for valid UUID
-		_, err := uuid.Parse(laptop.Id)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "Laptop ID is not valid uuid: %v", err)
-		}
-	} else {
+	if len(key) == 0 || server.idempotencyStore == nil {
+		return server.createLaptop(ctx, req)
+	}
+
+	res, err := server.idempotent(ctx, "CreateLaptop", key, req, func() (proto.Message, error) {
+		return server.createLaptop(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*pb.CreateLaptopResponse), nil
+}
+
+func (server *LaptopServer) createLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
+	// the ID is set on a copy, so a retry of the request is still the same request
+	laptop, _ := proto.Clone(req.GetLaptop()).(*pb.Laptop)
+	slog.DebugContext(ctx, "received a create laptop request", "laptop_id", laptop.GetId())
+
+	//Checking the laptop against the domain rules, including a valid UUID
+	err := validationError("laptop", validation.Laptop(laptop))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(laptop.Id) == 0 {
 
 		id, err := uuid.NewRandom()
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Cannot generate a new laptop Id: %v", err)
+			return nil, detailedError(codes.Internal, ReasonIDGenerationFailed, nil, fmt.Sprintf("Cannot generate a new laptop Id: %v", err))
 		}
 
 		laptop.Id = id.String()
@@ -60,17 +165,20 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 	}
 
 	//Save the laptop to in-memory store
-	err := server.laptopStore.Save(laptop)
+	_, span := tracing.StartSpan(ctx, "LaptopStore.Save")
+	err = server.laptopStore.Save(laptop)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		code := codes.Internal
 		if errors.Is(err, ErrorAlreadyExists) {
-			code = codes.AlreadyExists
+			return nil, resourceError(codes.AlreadyExists, ReasonLaptopExists, resourceLaptop, laptop.Id,
+				fmt.Sprintf("Cannot save laptop to store: %v", err))
 		}
 
-		return nil, status.Errorf(code, "Cannot save laptop to store: %v", err)
+		return nil, storeError("laptop", "Cannot save laptop to store", err)
 	}
 
-	log.Println("Saved laptop with id: ", laptop.Id)
+	slog.InfoContext(ctx, "saved laptop", "laptop_id", laptop.Id)
 
 	res := &pb.CreateLaptopResponse{
 		Id: laptop.Id,
@@ -78,54 +186,123 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 	return res, nil
 }
 
-// SearchLaptop is server-streaming RPC to seach for laptops
-func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) (outErr error) {
+// SearchLaptop is server-streaming RPC to seach for laptops. The search
+// stops at the first result that cannot be sent, at the maximum number of
+// results, or when the client does not take a result within the send timeout.
+func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
 	filter := req.GetFilter()
-	log.Printf("Recieve a search laptop request with filter: %v", filter)
+	slog.DebugContext(stream.Context(), "received a search laptop request", "filter", filter.String())
+
+	ctx, span := tracing.StartSpan(stream.Context(), "LaptopStore.Search")
+	defer span.End()
 
+	count := 0
+	var sendErr error
 	err := server.laptopStore.Search(
-		stream.Context(),
+		ctx,
 		filter,
-		func(laptop *pb.Laptop) {
+		func(laptop *pb.Laptop) error {
+			if server.maxSearchResults > 0 && count >= server.maxSearchResults {
+				return errSearchLimit
+			}
+
 			res := &pb.SearchLaptopResponse{Laptop: laptop}
 
-			err := stream.Send(res)
-			if err != nil {
-				outErr = status.Errorf(codes.Unknown, "cannot send response: %v", err)
-				return
+			_, sendSpan := tracing.StartSpan(ctx, "SearchLaptop.Send")
+			sendErr = sendWithTimeout(stream.Context(), server.searchSendTimeout, func() error {
+				return stream.Send(res)
+			})
+			sendSpan.SetError(sendErr)
+			sendSpan.End()
+			if sendErr != nil {
+				return sendErr
 			}
 
-			log.Printf("Send laptop with id: %s", laptop.GetId())
+			count++
+			slog.DebugContext(stream.Context(), "sent laptop", "laptop_id", laptop.GetId())
+			return nil
 		})
-	if err != nil {
-		return status.Errorf(codes.Internal, "unexpected error: %v", err)
+
+	switch {
+	case err == nil:
+		return nil
+	case err == errSearchLimit:
+		slog.DebugContext(ctx, "search stopped at the result limit", "count", count)
+		stream.SetTrailer(metadata.Pairs(SearchTruncatedTrailer, "true"))
+		return nil
+	case err == errSendTimeout:
+		// the expired send has usually ended the call already, then the detail only reaches the logs
+		span.SetError(err)
+		message := fmt.Sprintf("client did not receive a search result within %s", server.searchSendTimeout)
+		return logError(detailedError(codes.DeadlineExceeded, ReasonSendTimeout, []string{"timeout", server.searchSendTimeout.String()}, message))
+	case err == sendErr:
+		span.SetError(err)
+		return logError(streamError("cannot send response", err))
+	default:
+		span.SetError(err)
+		return storeError("laptop", "unexpected error", err)
 	}
+}
 
-	return nil
+// sendWithTimeout calls send and returns errSendTimeout if it does not return
+// within the timeout. A send must not outlive its handler, so on timeout the
+// stream is expired and the send is waited for. The expired send ends the
+// call with DEADLINE_EXCEEDED itself. Without the ExpirableStreams tap handle
+// the send cannot be stopped, so it is left to end with the call.
+func sendWithTimeout(ctx context.Context, timeout time.Duration, send func() error) error {
+	if timeout <= 0 {
+		return send()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- send()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+
+	if !expireStream(ctx) {
+		slog.WarnContext(ctx, "cannot expire the stream of a timed out send, the server has no ExpirableStreams tap handle")
+		return errSendTimeout
+	}
+
+	<-done
+	return errSendTimeout
 }
 
-//UploadImage is client-streaming RPC to upload laptop Images
+// UploadImage is client-streaming RPC to upload laptop Images
 func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
 	// The stream now starts receiving data
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "Cannot receive image info"))
+		return logError(streamError("Cannot receive image info", err))
 	}
 
 	// Getting the laptop id and image type from the request
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
-	log.Printf("Received an upload image request for laptop %s with image type %s", laptopID, imageType)
+	slog.DebugContext(stream.Context(), "received an upload image request", "laptop_id", laptopID, "image_type", imageType)
 
 	// Finding the laptop in the laptopStore with the obtained id
+	_, span := tracing.StartSpan(stream.Context(), "LaptopStore.Find")
 	laptop, err := server.laptopStore.Find(laptopID)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "Cannot find laptop: %v", err))
+		return logError(storeError("laptop", "Cannot find laptop", err))
 	}
 
 	// In-case the laptop does not exist in the store
 	if laptop == nil {
-		return logError(status.Errorf(codes.Internal, "Laptop %s does not exist", laptopID))
+		return logError(resourceError(codes.NotFound, ReasonLaptopNotFound, resourceLaptop, laptopID,
+			fmt.Sprintf("Laptop %s does not exist", laptopID)))
 	}
 
 	// Pre-defining an imageData buffer of bytes
@@ -135,31 +312,37 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 	for {
 		// If stream does not get data
 		if err := contextError(stream.Context()); err != nil {
-			return nil
+			return err
 		}
-		log.Printf("Waiting to recieve more data")
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("No more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "Cannot receive chunk data: %v", err))
+			return logError(streamError("Cannot receive chunk data", err))
 		}
 
 		// Calling raw chunk data of bytes and setting its length equal to size of chunk
 		chunk := req.GetChunkData()
 		size := len(chunk)
 
-		log.Printf("Recieved a chunk with size: %d", size)
+		slog.DebugContext(stream.Context(), "received a chunk", "size", size)
 
 		// Increasing imageSize with the chunk size
 		imageSize += size
 
 		// If image size is too large
-		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, maxImageSize))
+		if imageSize > server.maxImageSize {
+			return logError(detailedError(
+				codes.InvalidArgument,
+				ReasonImageTooLarge,
+				[]string{"size", strconv.Itoa(imageSize), "max_size", strconv.Itoa(server.maxImageSize)},
+				fmt.Sprintf("image is too large: %d > %d", imageSize, server.maxImageSize),
+				&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					fieldViolation("chunk_data", "the image must not be larger than %d bytes", server.maxImageSize),
+				}},
+			))
 		}
 
 		// Write slowly
@@ -168,14 +351,18 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		// Writing the data from uploaded image to bytes chunk
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "Cannot write chunk data: %v", err))
+			return logError(detailedError(codes.Internal, ReasonStoreFailure, nil, fmt.Sprintf("Cannot write chunk data: %v", err)))
 		}
 	}
 
 	// Saving the image to the store
+	_, span = tracing.StartSpan(stream.Context(), "ImageStore.Save")
+	span.SetAttribute("image.size", imageSize)
 	imageID, err := server.imageStore.Save(laptopID, imageType, imageData)
+	span.SetError(err)
+	span.End()
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "Cannot save image to the store: %v", err))
+		return logError(storeError("image", "Cannot save image to the store", err))
 	}
 
 	// Defining response with the upload image info
@@ -187,15 +374,81 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 	// Closing the stream after receiving data
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "Cannot send response: %v", err))
+		return logError(streamError("Cannot send response", err))
 	}
 
-	log.Printf("Saved image with id: %s, size: %d", imageID, imageSize)
+	slog.InfoContext(stream.Context(), "saved image", "image_id", imageID, "laptop_id", laptopID, "size", imageSize)
 	return nil
 }
 
-func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+// DownloadImage is server-streaming RPC that sends the image info and then its data in chunks
+func (server *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageID := req.GetImageId()
+	slog.DebugContext(stream.Context(), "received a download image request", "image_id", imageID)
+
+	_, span := tracing.StartSpan(stream.Context(), "ImageStore.Find")
+	info, err := server.imageStore.Find(imageID)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return logError(storeError("image", "cannot find image", err))
+	}
+	if info == nil {
+		return logError(resourceError(codes.NotFound, ReasonImageNotFound, resourceImage, imageID,
+			fmt.Sprintf("image %s does not exist", imageID)))
+	}
+
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return logError(storeError("image", "cannot open image file", err))
+	}
+	defer file.Close()
+
+	err = stream.Send(&pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  info.LaptopID,
+				ImageType: info.Type,
+			},
+		},
+	})
+	if err != nil {
+		return logError(streamError("cannot send image info", err))
+	}
+
+	buffer := make([]byte, downloadChunkSize)
 	for {
+		if err := contextError(stream.Context()); err != nil {
+			return err
+		}
+
+		n, err := file.Read(buffer)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(storeError("image", "cannot read image file", err))
+		}
+
+		err = stream.Send(&pb.DownloadImageResponse{
+			Data: &pb.DownloadImageResponse_ChunkData{ChunkData: buffer[:n]},
+		})
+		if err != nil {
+			return logError(streamError("cannot send chunk data", err))
+		}
+	}
+
+	return nil
+}
+
+// RateLaptop is bidirectional-streaming RPC that rates laptops. A request
+// with an idempotency key is rated once. A key in the call metadata is used
+// for every request without one, together with the index of the request on
+// the stream, so a retried stream replays the requests it already sent.
+func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	streamKey := idempotencyKeyFromContext(stream.Context())
+
+	for index := 0; ; index++ {
 		err := contextError(stream.Context())
 		if err != nil {
 			return err
@@ -203,58 +456,353 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("No more data, end of file reached")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive stream request: %v", err))
+			return logError(streamError("cannot receive stream request", err))
+		}
+
+		slog.DebugContext(stream.Context(), "received a rate laptop request", "laptop_id", req.GetLaptopId(), "score", req.GetScore())
+
+		key := req.GetIdempotencyKey()
+		if len(key) == 0 && len(streamKey) > 0 {
+			key = streamKey + "/" + strconv.Itoa(index)
+		}
+
+		var res *pb.RateLaptopResponse
+		if len(key) == 0 || server.idempotencyStore == nil {
+			res, err = server.rateLaptop(stream.Context(), req)
+		} else {
+			var msg proto.Message
+			msg, err = server.idempotent(stream.Context(), "RateLaptop", key, req, func() (proto.Message, error) {
+				return server.rateLaptop(stream.Context(), req)
+			})
+			if err == nil {
+				res = msg.(*pb.RateLaptopResponse)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		err = stream.Send(res)
+		if err != nil {
+			return logError(streamError("cannot send stream response", err))
 		}
+	}
+
+	return nil
+}
+
+// rateLaptop adds a single score to the rating store
+func (server *LaptopServer) rateLaptop(ctx context.Context, req *pb.RateLaptopRequest) (*pb.RateLaptopResponse, error) {
+	laptopId := req.GetLaptopId()
+	rating_score := req.GetScore()
+
+	_, span := tracing.StartSpan(ctx, "LaptopStore.Find")
+	found, err := server.laptopStore.Find(laptopId)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, logError(storeError("laptop", "cannot find laptop from request", err))
+	}
+	if found == nil {
+		return nil, logError(resourceError(codes.NotFound, ReasonLaptopNotFound, resourceLaptop, laptopId,
+			fmt.Sprintf("Laptop with id %s could not be found", laptopId)))
+	}
 
-		laptopId := req.GetLaptopId()
-		rating_score := req.GetScore()
+	_, span = tracing.StartSpan(ctx, "RatingStore.Add")
+	rating, err := server.RatingStore.Add(laptopId, rating_score)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, logError(storeError("rating", "cannot add rating to store", err))
+	}
 
-		log.Printf("received a request to rate laptops: \n Id: %s \n RatingScore: %.2f\n", laptopId, rating_score)
+	res := &pb.RateLaptopResponse{
+		LaptopId:     laptopId,
+		RatedCount:   rating.Count,
+		AverageScore: rating.Sum / float64(rating.Count),
+	}
+	return res, nil
+}
 
-		found, err := server.laptopStore.Find(laptopId)
+// ImportLaptops is client-streaming RPC that saves many laptops in batches.
+// Every laptop gets a result: created, duplicate, or invalid with the rules
+// it broke. With all_or_nothing on the first request the laptops are kept
+// until the stream ends and saved in one batch, only if all of them can be.
+func (server *LaptopServer) ImportLaptops(stream pb.LaptopService_ImportLaptopsServer) error {
+	ctx := stream.Context()
+	res := &pb.ImportLaptopsResponse{}
+	allOrNothing := false
+
+	// valid laptops waiting for the next batch, and their results
+	var batch []*pb.Laptop
+	var batchResults []*pb.ImportLaptopResult
+
+	for index := 0; ; index++ {
+		err := contextError(ctx)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot find laptop from request: %v", err))
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(streamError("cannot receive laptop", err))
+		}
+
+		if index == 0 {
+			allOrNothing = req.GetAllOrNothing()
+		}
+		if allOrNothing && index >= maxAllOrNothingImport {
+			return logError(detailedError(
+				codes.InvalidArgument,
+				ReasonImportTooLarge,
+				[]string{"max_laptops", strconv.Itoa(maxAllOrNothingImport)},
+				fmt.Sprintf("an all-or-nothing import takes at most %d laptops", maxAllOrNothingImport),
+			))
+		}
+
+		laptop := req.GetLaptop()
+		result := &pb.ImportLaptopResult{Index: uint32(index), LaptopId: laptop.GetId()}
+		res.Results = append(res.Results, result)
+
+		violations := validation.Laptop(laptop)
+		if len(violations) > 0 {
+			result.Outcome = pb.ImportLaptopResult_INVALID
+			for _, violation := range violations {
+				result.Reasons = append(result.Reasons, validation.Path("laptop", violation.Path)+": "+violation.Description)
+			}
+			continue
 		}
-		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "Laptop with id %s could not be found", laptopId))
+
+		if len(laptop.Id) == 0 {
+			id, err := uuid.NewRandom()
+			if err != nil {
+				return logError(detailedError(codes.Internal, ReasonIDGenerationFailed, nil, fmt.Sprintf("Cannot generate a new laptop Id: %v", err)))
+			}
+			laptop.Id = id.String()
+			result.LaptopId = laptop.Id
+		}
+
+		batch = append(batch, laptop)
+		batchResults = append(batchResults, result)
+		if !allOrNothing && len(batch) >= importBatchSize {
+			err = server.saveImportBatch(ctx, batch, batchResults, false)
+			if err != nil {
+				return err
+			}
+			batch, batchResults = nil, nil
 		}
+	}
 
-		rating, err := server.RatingStore.Add(laptopId, rating_score)
+	hasInvalid := false
+	for _, result := range res.Results {
+		hasInvalid = hasInvalid || result.Outcome == pb.ImportLaptopResult_INVALID
+	}
+
+	if allOrNothing && hasInvalid {
+		for _, result := range batchResults {
+			result.Outcome = pb.ImportLaptopResult_ABORTED
+		}
+	} else {
+		err := server.saveImportBatch(ctx, batch, batchResults, allOrNothing)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot add rating to store: %v", err))
+			return err
 		}
+	}
 
-		res := &pb.RateLaptopResponse{
-			LaptopId:     laptopId,
-			RatedCount:   rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+	for _, result := range res.Results {
+		switch result.Outcome {
+		case pb.ImportLaptopResult_CREATED:
+			res.CreatedCount++
+		case pb.ImportLaptopResult_DUPLICATE:
+			res.DuplicateCount++
+		case pb.ImportLaptopResult_INVALID:
+			res.InvalidCount++
+		}
+	}
+
+	err := stream.SendAndClose(res)
+	if err != nil {
+		return logError(streamError("cannot send response", err))
+	}
+
+	slog.InfoContext(ctx, "imported laptops", "created", res.CreatedCount, "duplicate", res.DuplicateCount, "invalid", res.InvalidCount, "all_or_nothing", allOrNothing)
+	return nil
+}
+
+// saveImportBatch saves a batch of valid laptops and sets the outcome of their results
+func (server *LaptopServer) saveImportBatch(ctx context.Context, batch []*pb.Laptop, results []*pb.ImportLaptopResult, allOrNothing bool) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	_, span := tracing.StartSpan(ctx, "LaptopStore.SaveBatch")
+	span.SetAttribute("batch.size", len(batch))
+	errs, err := server.laptopStore.SaveBatch(batch, allOrNothing)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return logError(storeError("laptop", "cannot save laptops to store", err))
+	}
+
+	failed := hasError(errs)
+	for i, result := range results {
+		switch {
+		case errors.Is(errs[i], ErrorAlreadyExists):
+			result.Outcome = pb.ImportLaptopResult_DUPLICATE
+			result.Reasons = []string{fmt.Sprintf("laptop.id: %s is already in the store or earlier in the import", result.LaptopId)}
+		case errs[i] != nil:
+			result.Outcome = pb.ImportLaptopResult_INVALID
+			result.Reasons = []string{errs[i].Error()}
+		case allOrNothing && failed:
+			result.Outcome = pb.ImportLaptopResult_ABORTED
+		default:
+			result.Outcome = pb.ImportLaptopResult_CREATED
 		}
-		err = stream.Send(res)
 	}
 
 	return nil
 }
 
-//Utility function for logging errors to console
+// ExportLaptops is server-streaming RPC that sends every laptop matching the
+// filter, or the whole catalog without one, optionally with its rating and
+// the references of its images
+func (server *LaptopServer) ExportLaptops(req *pb.ExportLaptopsRequest, stream pb.LaptopService_ExportLaptopsServer) error {
+	filter := req.GetFilter()
+	if filter == nil {
+		filter = &pb.Filter{MaxPriceUsd: math.MaxFloat64}
+	}
+	slog.DebugContext(stream.Context(), "received an export laptops request", "filter", filter.String(),
+		"include_ratings", req.GetIncludeRatings(), "include_images", req.GetIncludeImages())
+
+	ctx, span := tracing.StartSpan(stream.Context(), "LaptopStore.Search")
+	defer span.End()
+
+	count := 0
+	var outErr error
+	err := server.laptopStore.Search(
+		ctx,
+		filter,
+		func(laptop *pb.Laptop) error {
+			res, err := server.exportLaptop(req, laptop)
+			if err != nil {
+				outErr = err
+				return err
+			}
+
+			err = stream.Send(res)
+			if err != nil {
+				outErr = streamError("cannot send response", err)
+				return outErr
+			}
+			count++
+			return nil
+		})
+	if outErr != nil {
+		span.SetError(outErr)
+		return logError(outErr)
+	}
+	if err != nil {
+		span.SetError(err)
+		return logError(storeError("laptop", "unexpected error", err))
+	}
+
+	slog.InfoContext(ctx, "exported laptops", "count", count)
+	return nil
+}
+
+// exportLaptop adds the rating and image references to an exported laptop
+func (server *LaptopServer) exportLaptop(req *pb.ExportLaptopsRequest, laptop *pb.Laptop) (*pb.ExportLaptopsResponse, error) {
+	res := &pb.ExportLaptopsResponse{Laptop: laptop}
+
+	if req.GetIncludeRatings() {
+		rating, err := server.RatingStore.Find(laptop.GetId())
+		if err != nil {
+			return nil, storeError("rating", "cannot find rating", err)
+		}
+		if rating != nil {
+			res.Rating = &pb.LaptopRating{
+				RatedCount:   rating.Count,
+				AverageScore: rating.Sum / float64(rating.Count),
+			}
+		}
+	}
+
+	if req.GetIncludeImages() {
+		infos, err := server.imageStore.FindByLaptop(laptop.GetId())
+		if err != nil {
+			return nil, storeError("image", "cannot find images", err)
+		}
+		for _, info := range infos {
+			res.Images = append(res.Images, &pb.ImageReference{
+				ImageId:   info.ID,
+				ImageType: info.Type,
+				Size:      uint64(info.Size),
+			})
+		}
+	}
+
+	return res, nil
+}
+
+// idempotent runs fn once per idempotency key of the caller. Keys are
+// scoped by method and caller, so one client cannot replay the response of
+// another.
+func (server *LaptopServer) idempotent(ctx context.Context, method string, key string, req proto.Message, fn func() (proto.Message, error)) (proto.Message, error) {
+	res, replayed, err := server.idempotencyStore.Do(ctx, method+"/"+clientKey(ctx)+"/"+key, req, fn)
+	switch {
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return nil, logError(invalidArgumentError(ReasonIdempotencyReused, fmt.Sprintf("%s: %s", err, key),
+			fieldViolation("idempotency_key", "was used for a different request")))
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil, contextError(ctx)
+	case err != nil:
+		return nil, err
+	}
+
+	if replayed {
+		slog.InfoContext(ctx, "replayed request", "method", method, "idempotency_key", key)
+	}
+
+	return res, nil
+}
+
+// idempotencyKeyFromContext returns the idempotency key from the request metadata
+func idempotencyKeyFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(idempotencyKeyHeader)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Utility function for logging errors, the logging interceptor reports them once per call
 func logError(err error) error {
 	if err != nil {
-		log.Print(err)
+		slog.Debug("rpc error", "error", err)
 	}
 
 	return err
 }
 
-//Utility function for testing context errors:
+// Utility function for testing context errors:
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
-		return logError(status.Error(codes.Canceled, "Request is canceled"))
+		return logError(detailedError(codes.Canceled, ReasonRequestCancelled, nil, "Request is canceled"))
 	case context.DeadlineExceeded:
-		return logError(status.Error(codes.DeadlineExceeded, "Deadline is exceeded"))
+		return logError(detailedError(codes.DeadlineExceeded, ReasonDeadlineExceeded, nil, "Deadline is exceeded"))
 	default:
 		return nil
 	}
@@ -3,12 +3,15 @@ package service_test
 import (
 	"context"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"laptop-app-using-grpc/pb/pb"
 	"laptop-app-using-grpc/sample"
 	"laptop-app-using-grpc/service"
 	"testing"
+	"time"
 )
 
 func TestServerCreateLaptop(t *testing.T) {
@@ -20,6 +23,9 @@ func TestServerCreateLaptop(t *testing.T) {
 	LaptopInvalidID := sample.NewLaptop()
 	LaptopInvalidID.Id = "invalid-uuid"
 
+	LaptopInvalidCPU := sample.NewLaptop()
+	LaptopInvalidCPU.Cpu.CpuThreads = LaptopInvalidCPU.Cpu.CpuCores - 1
+
 	LaptopDuplicateID := sample.NewLaptop()
 	storeDuplicateID := service.NewInMemoryLaptopStore()
 	err := storeDuplicateID.Save(LaptopDuplicateID)
@@ -48,6 +54,18 @@ func TestServerCreateLaptop(t *testing.T) {
 			store:  service.NewInMemoryLaptopStore(),
 			code:   codes.InvalidArgument,
 		},
+		{
+			name:   "failure_invalid_cpu",
+			laptop: LaptopInvalidCPU,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "failure_missing_laptop",
+			laptop: nil,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
+		},
 		{
 			name:   "failure_duplicate_id",
 			laptop: LaptopDuplicateID,
@@ -87,3 +105,132 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCreateLaptopErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Id = "invalid-uuid"
+
+	server := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+	_, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	st := requireErrorReason(t, err, codes.InvalidArgument, service.ReasonInvalidLaptop)
+
+	badRequest := requireDetail[*errdetails.BadRequest](t, st)
+	require.Len(t, badRequest.GetFieldViolations(), 1)
+	require.Equal(t, "laptop.id", badRequest.GetFieldViolations()[0].GetField())
+	require.Contains(t, badRequest.GetFieldViolations()[0].GetDescription(), "invalid-uuid")
+
+	// every broken rule is reported by its path in the request
+	laptop = sample.NewLaptop()
+	laptop.Brand = ""
+	laptop.Ram.Unit = pb.Memory_UNKNOWN
+	laptop.Screen.Resolution.Height = 0
+	_, err = server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	st = requireErrorReason(t, err, codes.InvalidArgument, service.ReasonInvalidLaptop)
+	require.Equal(t, "invalid laptop: laptop.brand is required and 2 more", st.Message())
+
+	var fields []string
+	for _, violation := range requireDetail[*errdetails.BadRequest](t, st).GetFieldViolations() {
+		fields = append(fields, violation.GetField())
+	}
+	require.Equal(t, []string{"laptop.brand", "laptop.ram.unit", "laptop.screen.resolution.height"}, fields)
+
+	laptop = sample.NewLaptop()
+	store := service.NewInMemoryLaptopStore()
+	require.NoError(t, store.Save(laptop))
+
+	server = service.NewLaptopServer(store, nil, nil)
+	_, err = server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	st = requireErrorReason(t, err, codes.AlreadyExists, service.ReasonLaptopExists)
+
+	resource := requireDetail[*errdetails.ResourceInfo](t, st)
+	require.Equal(t, "vyom1611.laptop_app.Laptop", resource.GetResourceType())
+	require.Equal(t, laptop.GetId(), resource.GetResourceName())
+}
+
+func TestServerCreateLaptopIdempotent(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+
+	store := service.NewInMemoryLaptopStore()
+	idempotencyStore := service.NewInMemoryIdempotencyStore(time.Minute)
+	server := service.NewLaptopServer(store, nil, nil, service.WithIdempotencyStore(idempotencyStore))
+
+	req := &pb.CreateLaptopRequest{
+		Laptop:         laptop,
+		IdempotencyKey: "create-once",
+	}
+
+	res1, err := server.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	// the retry would fail with AlreadyExists if it were applied again
+	res2, err := server.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, res1.GetId(), res2.GetId())
+}
+
+func TestServerCreateLaptopIdempotencyHeader(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	idempotencyStore := service.NewInMemoryIdempotencyStore(time.Minute)
+	server := service.NewLaptopServer(store, nil, nil, service.WithIdempotencyStore(idempotencyStore))
+
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+	req := &pb.CreateLaptopRequest{Laptop: laptop}
+
+	callerCtx := func(name string) context.Context {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", "create-once"))
+		return service.ContextWithIdentity(ctx, &service.Identity{Name: name})
+	}
+
+	res1, err := server.CreateLaptop(callerCtx("alice"), req)
+	require.NoError(t, err)
+
+	res2, err := server.CreateLaptop(callerCtx("alice"), req)
+	require.NoError(t, err)
+	require.Equal(t, res1.GetId(), res2.GetId())
+	require.Equal(t, 1, store.Count())
+
+	// the same key of another caller is a request of its own
+	res3, err := server.CreateLaptop(callerCtx("bob"), req)
+	require.NoError(t, err)
+	require.NotEqual(t, res1.GetId(), res3.GetId())
+	require.Equal(t, 2, store.Count())
+
+	// a different request with a used key is rejected
+	_, err = server.CreateLaptop(callerCtx("alice"), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	requireErrorReason(t, err, codes.InvalidArgument, service.ReasonIdempotencyReused)
+	require.Equal(t, 2, store.Count())
+}
+
+// requireErrorReason checks the code of err and the reason in its ErrorInfo
+func requireErrorReason(t *testing.T, err error, code codes.Code, reason string) *status.Status {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, code, st.Code())
+
+	info := requireDetail[*errdetails.ErrorInfo](t, st)
+	require.Equal(t, reason, info.GetReason())
+	require.Equal(t, service.ErrorDomain, info.GetDomain())
+
+	return st
+}
+
+// requireDetail returns the first detail of type T in st
+func requireDetail[T any](t *testing.T, st *status.Status) T {
+	for _, detail := range st.Details() {
+		if detail, ok := detail.(T); ok {
+			return detail
+		}
+	}
+
+	var zero T
+	require.Failf(t, "missing error detail", "%T not in %v", zero, st.Details())
+	return zero
+}
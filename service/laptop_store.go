@@ -3,12 +3,12 @@ package service
 import (
 	"context"
 	"errors"
-	"fmt"
-	"github.com/jinzhu/copier"
+	"google.golang.org/protobuf/proto"
 	"laptop-app-using-grpc/pb/pb"
-	"log"
-
+	"laptop-app-using-grpc/tracing"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 )
 
 var ErrorAlreadyExists = errors.New("Error already exists")
@@ -16,24 +16,39 @@ var ErrorAlreadyExists = errors.New("Error already exists")
 type LaptopStore interface {
 	//Saves the laptop to store
 	Save(laptop *pb.Laptop) error
+	//Saves the laptops under one lock and returns an error per laptop,
+	//ErrorAlreadyExists for an ID taken in the store or earlier in the batch.
+	//With allOrNothing nothing is saved unless every laptop can be.
+	//The second error is set when the store failed and nothing was saved.
+	SaveBatch(laptops []*pb.Laptop, allOrNothing bool) ([]error, error)
 	//Find laptop by Id
 	Find(id string) (*pb.Laptop, error)
 
-	//Searching laptops and returning one by one with found function
-	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop)) error
+	//Searching laptops and returning one by one with found function.
+	//The search stops at the first error found returns, and returns it.
+	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+
+	//Number of laptops in the store
+	Count() int
+
+	//Ready returns an error while the store cannot serve requests
+	Ready() error
 }
 
-//Store laptop in-memory
+//Store laptop in-memory. Reads work on an immutable snapshot of the
+//laptops that every write replaces as a whole, so a slow search never
+//holds up a save and a save never waits for readers.
 type InMemoryLaptopStore struct {
-	mutex sync.RWMutex
-	data  map[string]*pb.Laptop
+	//serializes writers, readers never take it
+	mutex    sync.Mutex
+	snapshot atomic.Pointer[laptopTrie]
 }
 
 //Returning new in memory laptop store
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
-	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
-	}
+	store := &InMemoryLaptopStore{}
+	store.snapshot.Store(newLaptopTrie())
+	return store
 }
 
 //Saving the laptop to store
@@ -41,60 +56,124 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	if store.data[laptop.Id] != nil {
+	snapshot := store.snapshot.Load()
+	if snapshot.find(laptop.Id) != nil {
 		return ErrorAlreadyExists
 	}
 
-	//deep copy
-	other, err := DeepCopy(laptop)
-	if err != nil {
-		return err
+	//deep copy, stored laptops are never changed
+	store.snapshot.Store(snapshot.with(DeepCopy(laptop)))
+	return nil
+}
+
+// SaveBatch saves the laptops that are new, readers see all of them at once
+func (store *InMemoryLaptopStore) SaveBatch(laptops []*pb.Laptop, allOrNothing bool) ([]error, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	snapshot := store.snapshot.Load()
+	errs := batchErrors(snapshot, laptops)
+	if allOrNothing && hasError(errs) {
+		return errs, nil
 	}
 
-	store.data[other.Id] = other
-	return nil
+	for i, laptop := range laptops {
+		if errs[i] == nil {
+			snapshot = snapshot.with(DeepCopy(laptop))
+		}
+	}
+
+	store.snapshot.Store(snapshot)
+	return errs, nil
+}
+
+// checkBatch finds the laptops of a batch that cannot be saved
+func (store *InMemoryLaptopStore) checkBatch(laptops []*pb.Laptop) []error {
+	return batchErrors(store.snapshot.Load(), laptops)
+}
+
+// batchErrors finds the laptops of a batch that cannot be saved in the snapshot
+func batchErrors(snapshot *laptopTrie, laptops []*pb.Laptop) []error {
+	errs := make([]error, len(laptops))
+	seen := make(map[string]bool, len(laptops))
+
+	for i, laptop := range laptops {
+		if snapshot.find(laptop.Id) != nil || seen[laptop.Id] {
+			errs[i] = ErrorAlreadyExists
+		}
+		seen[laptop.Id] = true
+	}
+
+	return errs
 }
 
 //Finding laptop by its Id on the store
 func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
-	store.mutex.RLock()
-	defer store.mutex.RUnlock()
-
 	//find laptop from data store
-	laptop := store.data[id]
+	laptop := store.snapshot.Load().find(id)
 	if laptop == nil {
 		return nil, nil
 	}
 
-	return DeepCopy(laptop)
+	return DeepCopy(laptop), nil
 }
 
-func (store *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop)) error {
-	store.mutex.RLock()
-	defer store.mutex.RUnlock()
+//Searching the laptops of the snapshot taken when the search starts,
+//laptops saved during the search are not found
+func (store *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	var err error
 
 	//Looping through all the laptops in the store service
-	for _, laptop := range store.data {
+	store.snapshot.Load().each(func(laptop *pb.Laptop) bool {
 		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
-			log.Print("context is cancelled")
-			return nil
+			slog.DebugContext(ctx, "search context is cancelled")
+			return false
 		}
 
 		// time.Sleep(time.Second)
 		// log.Print("checking laptop id: ", laptop.GetId())
 		if isQualified(filter, laptop) {
-			other, err := DeepCopy(laptop)
-			if err != nil {
-				return err
-			}
+			_, span := tracing.StartSpan(ctx, "DeepCopy")
+			other := DeepCopy(laptop)
+			span.End()
 
-			found(other)
+			err = found(other)
 		}
-	}
 
+		return err == nil
+	})
+
+	return err
+}
+
+// Count returns the number of laptops in the store
+func (store *InMemoryLaptopStore) Count() int {
+	return store.snapshot.Load().size
+}
+
+// Ready always succeeds for the in-memory store
+func (store *InMemoryLaptopStore) Ready() error {
 	return nil
 }
 
+// Calling fn for every laptop in the store without copying it
+func (store *InMemoryLaptopStore) forEach(fn func(laptop *pb.Laptop)) {
+	store.snapshot.Load().each(func(laptop *pb.Laptop) bool {
+		fn(laptop)
+		return true
+	})
+}
+
+func hasError(errs []error) bool {
+	for _, err := range errs {
+		if err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
 	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
 		return false
@@ -136,13 +215,8 @@ func toBit(memory *pb.Memory) uint64 {
 	}
 }
 
-//Deep Copy utility function
-func DeepCopy(laptop *pb.Laptop) (*pb.Laptop, error) {
-	other := &pb.Laptop{}
-	err := copier.Copy(other, laptop)
-	if err != nil {
-		return nil, fmt.Errorf("cannot copy laptop data: %w", err)
-	}
-
-	return other, nil
+//Deep Copy utility function, cloning the laptop with its oneof weight,
+//timestamps, repeated fields and unknown fields
+func DeepCopy(laptop *pb.Laptop) *pb.Laptop {
+	return proto.Clone(laptop).(*pb.Laptop)
 }
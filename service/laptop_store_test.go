@@ -0,0 +1,437 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCopyTestLaptops returns laptops with every kind of field a copy can lose
+func newCopyTestLaptops() []*pb.Laptop {
+	inKg := sample.NewLaptop()
+	inKg.Weight = &pb.Laptop_WeightKg{WeightKg: 1.25}
+	inKg.UpdatedAt = timestamppb.New(time.Date(2024, 5, 1, 12, 30, 15, 123456789, time.UTC))
+	inKg.Gpus = []*pb.GPU{sample.NewGPU(), sample.NewGPU(), sample.NewGPU()}
+	inKg.Storages = []*pb.Storage{sample.NewSSD(), sample.NewHHD()}
+
+	inLb := sample.NewLaptop()
+	inLb.Weight = &pb.Laptop_WeightLb{WeightLb: 3.5}
+	inLb.UpdatedAt = timestamppb.New(time.Unix(0, 0))
+
+	// a field of a newer schema, kept as unknown bytes
+	withUnknown := sample.NewLaptop()
+	withUnknown.Weight = nil
+	unknown := protowire.AppendTag(nil, 1000, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "newer field")
+	withUnknown.ProtoReflect().SetUnknown(unknown)
+
+	return []*pb.Laptop{inKg, inLb, withUnknown}
+}
+
+func TestDeepCopy(t *testing.T) {
+	t.Parallel()
+
+	for _, laptop := range newCopyTestLaptops() {
+		other := service.DeepCopy(laptop)
+		require.True(t, proto.Equal(laptop, other))
+		require.Equal(t, laptop.GetWeight(), other.GetWeight())
+		require.Equal(t, laptop.ProtoReflect().GetUnknown(), other.ProtoReflect().GetUnknown())
+
+		// the copy shares nothing with the original
+		expected := proto.Clone(laptop)
+		other.Cpu.CpuCores++
+		other.UpdatedAt.Seconds++
+		other.Gpus[0].Memory.Value++
+		other.Storages[0].Driver = pb.Storage_UNKNOWN
+		if weight, ok := other.Weight.(*pb.Laptop_WeightKg); ok {
+			weight.WeightKg++
+		}
+		other.ProtoReflect().SetUnknown(nil)
+		require.True(t, proto.Equal(expected, laptop))
+	}
+}
+
+func TestInMemoryLaptopStoreCopies(t *testing.T) {
+	t.Parallel()
+
+	laptops := newCopyTestLaptops()
+	store := service.NewInMemoryLaptopStore()
+	require.NoError(t, store.Save(laptops[0]))
+	errs, err := store.SaveBatch(laptops[1:], true)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, nil}, errs)
+
+	// changing a saved laptop does not change the store
+	expected := make([]*pb.Laptop, len(laptops))
+	for i, laptop := range laptops {
+		expected[i] = proto.Clone(laptop).(*pb.Laptop)
+		laptop.Gpus[0].Name = "changed"
+		laptop.UpdatedAt = nil
+	}
+
+	found := make(map[string]*pb.Laptop)
+	err = store.Search(context.Background(), &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+		found[laptop.GetId()] = laptop
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, found, len(laptops))
+
+	for _, laptop := range expected {
+		other, err := store.Find(laptop.GetId())
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, other))
+		require.True(t, proto.Equal(laptop, found[laptop.GetId()]))
+
+		// changing a found laptop does not change the store either
+		other.Storages = nil
+		other.Weight = nil
+		again, err := store.Find(laptop.GetId())
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, again))
+	}
+}
+
+func TestInMemoryLaptopStoreManyLaptops(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	laptops := make(map[string]*pb.Laptop)
+	for i := 0; i < 5000; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, store.Save(laptop))
+		laptops[laptop.GetId()] = laptop
+	}
+	require.Equal(t, len(laptops), store.Count())
+
+	for id, laptop := range laptops {
+		require.ErrorIs(t, store.Save(laptop), service.ErrorAlreadyExists)
+
+		other, err := store.Find(id)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, other))
+	}
+
+	missing, err := store.Find("missing")
+	require.NoError(t, err)
+	require.Nil(t, missing)
+
+	found := make(map[string]int)
+	err = store.Search(context.Background(), &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+		found[laptop.GetId()]++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, found, len(laptops))
+	for id, count := range found {
+		require.Equal(t, 1, count, id)
+	}
+}
+
+func TestInMemoryLaptopStoreSearchStops(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	stop := errors.New("stop")
+	found := 0
+	err := store.Search(context.Background(), &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+		found++
+		if found == 3 {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 3, found)
+}
+
+func TestInMemoryLaptopStoreSaveDuringSearch(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	// the search stops at its first laptop, like a client that reads nothing
+	blocked := make(chan struct{})
+	unblock := make(chan struct{})
+	var found []*pb.Laptop
+	searched := make(chan error)
+	go func() {
+		searched <- store.Search(context.Background(), &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+			if len(found) == 0 {
+				close(blocked)
+				<-unblock
+			}
+			found = append(found, laptop)
+			return nil
+		})
+	}()
+	<-blocked
+
+	saved := make(chan error)
+	laptop := sample.NewLaptop()
+	go func() {
+		saved <- store.Save(laptop)
+	}()
+
+	select {
+	case err := <-saved:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("save waits for the search")
+	}
+
+	errs, err := store.SaveBatch([]*pb.Laptop{sample.NewLaptop(), sample.NewLaptop()}, true)
+	require.NoError(t, err)
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, 13, store.Count())
+
+	other, err := store.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop, other))
+
+	// the search goes on with the laptops it started with
+	close(unblock)
+	require.NoError(t, <-searched)
+	require.Len(t, found, 10)
+}
+
+func TestInMemoryLaptopStoreConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	const writers, saves = 4, 200
+
+	var group sync.WaitGroup
+	ids := make([][]string, writers)
+	for i := 0; i < writers; i++ {
+		i := i
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for j := 0; j < saves; j++ {
+				laptop := sample.NewLaptop()
+				err := store.Save(laptop)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids[i] = append(ids[i], laptop.GetId())
+
+				// a saved laptop is found right away
+				other, err := store.Find(laptop.GetId())
+				if err != nil || other == nil {
+					t.Error("cannot find saved laptop: ", err)
+					return
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for ctx.Err() == nil {
+				before := store.Count()
+				found := 0
+				err := store.Search(ctx, &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+					found++
+					return nil
+				})
+				if err != nil || (ctx.Err() == nil && found < before) {
+					t.Errorf("search found %d of at least %d laptops: %v", found, before, err)
+					return
+				}
+			}
+		}()
+	}
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	group.Wait()
+	require.Equal(t, writers*saves, store.Count())
+	for _, writerIDs := range ids {
+		for _, id := range writerIDs {
+			laptop, err := store.Find(id)
+			require.NoError(t, err)
+			require.NotNil(t, laptop)
+		}
+	}
+}
+
+// catalogSizes are the numbers of laptops the store benchmarks run on
+var catalogSizes = []int{1000, 10000, 100000}
+
+// newBenchmarkStore returns an in-memory store with size sample laptops and their IDs
+func newBenchmarkStore(b *testing.B, size int) (*service.InMemoryLaptopStore, []string) {
+	b.Helper()
+
+	store := service.NewInMemoryLaptopStore()
+	ids := make([]string, size)
+	for i := range ids {
+		laptop := sample.NewLaptop()
+		require.NoError(b, store.Save(laptop))
+		ids[i] = laptop.GetId()
+	}
+
+	return store, ids
+}
+
+func BenchmarkInMemoryLaptopStoreSave(b *testing.B) {
+	for _, size := range catalogSizes {
+		b.Run(fmt.Sprintf("catalog=%d", size), func(b *testing.B) {
+			store, _ := newBenchmarkStore(b, size)
+			laptops := make([]*pb.Laptop, b.N)
+			for i := range laptops {
+				laptops[i] = sample.NewLaptop()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for _, laptop := range laptops {
+				err := store.Save(laptop)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInMemoryLaptopStoreFind(b *testing.B) {
+	for _, size := range catalogSizes {
+		b.Run(fmt.Sprintf("catalog=%d", size), func(b *testing.B) {
+			store, ids := newBenchmarkStore(b, size)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				laptop, err := store.Find(ids[i%len(ids)])
+				if err != nil || laptop == nil {
+					b.Fatal("cannot find laptop: ", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInMemoryLaptopStoreSearch(b *testing.B) {
+	filter := &pb.Filter{
+		MaxPriceUsd: 2000,
+		MinCpuCores: 4,
+		MinCpuGhz:   2.5,
+		MinRam:      &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE},
+	}
+
+	for _, size := range catalogSizes {
+		b.Run(fmt.Sprintf("catalog=%d", size), func(b *testing.B) {
+			store, _ := newBenchmarkStore(b, size)
+
+			found := 0
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+					found++
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(found)/float64(b.N), "laptops/op")
+		})
+	}
+}
+
+func BenchmarkInMemoryLaptopStoreMixed(b *testing.B) {
+	for _, writePercent := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("writes=%d%%", writePercent), func(b *testing.B) {
+			store, ids := newBenchmarkStore(b, 10000)
+			laptops := make([]*pb.Laptop, b.N)
+			for i := range laptops {
+				laptops[i] = sample.NewLaptop()
+			}
+
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(parallel *testing.PB) {
+				random := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for parallel.Next() {
+					if random.Intn(100) < writePercent {
+						err := store.Save(laptops[next.Add(1)-1])
+						if err != nil {
+							b.Fatal(err)
+						}
+						continue
+					}
+
+					laptop, err := store.Find(ids[random.Intn(len(ids))])
+					if err != nil || laptop == nil {
+						b.Fatal("cannot find laptop: ", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkInMemoryLaptopStoreSaveDuringSearch measures saves while searches
+// send their results to slow clients
+func BenchmarkInMemoryLaptopStoreSaveDuringSearch(b *testing.B) {
+	store, _ := newBenchmarkStore(b, 10000)
+	laptops := make([]*pb.Laptop, b.N)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var searchers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		searchers.Add(1)
+		go func() {
+			defer searchers.Done()
+			for ctx.Err() == nil {
+				_ = store.Search(ctx, &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+					time.Sleep(10 * time.Microsecond)
+					return nil
+				})
+			}
+		}()
+	}
+	defer searchers.Wait()
+	defer cancel()
+
+	// let the searchers start their scans
+	time.Sleep(10 * time.Millisecond)
+
+	b.ResetTimer()
+	for _, laptop := range laptops {
+		err := store.Save(laptop)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"laptop-app-using-grpc/pb/pb"
+	"math/bits"
+)
+
+const (
+	// bits of the hash of a laptop ID used at every level of the trie
+	trieBits  = 5
+	trieWidth = 1 << trieBits
+	trieMask  = trieWidth - 1
+)
+
+// laptopTrie is a persistent hash array mapped trie from laptop ID to laptop.
+// A trie is never changed: adding a laptop returns a new trie that copies the
+// path to the laptop and shares every other node, so any number of goroutines
+// can read a trie while a writer builds the next one.
+type laptopTrie struct {
+	root *trieNode
+	size int
+}
+
+// trieNode holds an entry for every bit set in bitmap, in bit order
+type trieNode struct {
+	bitmap  uint32
+	entries []trieEntry
+}
+
+// trieEntry is either a child node or a leaf
+type trieEntry struct {
+	node *trieNode
+	leaf *trieLeaf
+}
+
+// trieLeaf holds the laptops whose IDs have the hash, almost always one
+type trieLeaf struct {
+	hash    uint64
+	laptops []*pb.Laptop
+}
+
+func newLaptopTrie() *laptopTrie {
+	return &laptopTrie{root: &trieNode{}}
+}
+
+// hashID is the 64-bit FNV-1a hash of the ID, computed without allocating
+func hashID(id string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(id); i++ {
+		hash ^= uint64(id[i])
+		hash *= 1099511628211
+	}
+
+	return hash
+}
+
+// find returns the laptop with the ID, or nil
+func (trie *laptopTrie) find(id string) *pb.Laptop {
+	hash := hashID(id)
+	node := trie.root
+
+	for shift := uint(0); ; shift += trieBits {
+		bit := uint32(1) << ((hash >> shift) & trieMask)
+		if node.bitmap&bit == 0 {
+			return nil
+		}
+
+		entry := node.entries[bits.OnesCount32(node.bitmap&(bit-1))]
+		if entry.node != nil {
+			node = entry.node
+			continue
+		}
+
+		if entry.leaf.hash == hash {
+			for _, laptop := range entry.leaf.laptops {
+				if laptop.GetId() == id {
+					return laptop
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// with returns a trie that also holds the laptop, replacing a laptop with the same ID
+func (trie *laptopTrie) with(laptop *pb.Laptop) *laptopTrie {
+	root, added := trie.root.with(hashID(laptop.GetId()), 0, laptop)
+
+	size := trie.size
+	if added {
+		size++
+	}
+
+	return &laptopTrie{root: root, size: size}
+}
+
+// each calls fn for every laptop until it returns false, and reports whether
+// all laptops were visited
+func (trie *laptopTrie) each(fn func(laptop *pb.Laptop) bool) bool {
+	return trie.root.each(fn)
+}
+
+func (node *trieNode) with(hash uint64, shift uint, laptop *pb.Laptop) (*trieNode, bool) {
+	bit := uint32(1) << ((hash >> shift) & trieMask)
+	index := bits.OnesCount32(node.bitmap & (bit - 1))
+
+	if node.bitmap&bit == 0 {
+		entries := make([]trieEntry, len(node.entries)+1)
+		copy(entries, node.entries[:index])
+		entries[index] = trieEntry{leaf: &trieLeaf{hash: hash, laptops: []*pb.Laptop{laptop}}}
+		copy(entries[index+1:], node.entries[index:])
+
+		return &trieNode{bitmap: node.bitmap | bit, entries: entries}, true
+	}
+
+	entry := node.entries[index]
+	added := true
+	switch {
+	case entry.node != nil:
+		var child *trieNode
+		child, added = entry.node.with(hash, shift+trieBits, laptop)
+		entry = trieEntry{node: child}
+	case entry.leaf.hash == hash:
+		var laptops []*pb.Laptop
+		laptops, added = withLaptop(entry.leaf.laptops, laptop)
+		entry = trieEntry{leaf: &trieLeaf{hash: hash, laptops: laptops}}
+	default:
+		// two hashes share the bits so far, the next bits tell them apart
+		childShift := shift + trieBits
+		child := &trieNode{
+			bitmap:  uint32(1) << ((entry.leaf.hash >> childShift) & trieMask),
+			entries: []trieEntry{entry},
+		}
+		child, _ = child.with(hash, childShift, laptop)
+		entry = trieEntry{node: child}
+	}
+
+	entries := make([]trieEntry, len(node.entries))
+	copy(entries, node.entries)
+	entries[index] = entry
+
+	return &trieNode{bitmap: node.bitmap, entries: entries}, added
+}
+
+// withLaptop returns a copy of laptops with the laptop added or replaced
+func withLaptop(laptops []*pb.Laptop, laptop *pb.Laptop) ([]*pb.Laptop, bool) {
+	others := make([]*pb.Laptop, len(laptops), len(laptops)+1)
+	copy(others, laptops)
+
+	for i, other := range others {
+		if other.GetId() == laptop.GetId() {
+			others[i] = laptop
+			return others, false
+		}
+	}
+
+	return append(others, laptop), true
+}
+
+func (node *trieNode) each(fn func(laptop *pb.Laptop) bool) bool {
+	for _, entry := range node.entries {
+		if entry.node != nil {
+			if !entry.node.each(fn) {
+				return false
+			}
+			continue
+		}
+
+		for _, laptop := range entry.leaf.laptops {
+			if !fn(laptop) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// trieOrder is the position of a hash in the order a trie visits its laptops,
+// which compares the lowest bits of the hashes first
+func trieOrder(hash uint64) uint64 {
+	var order uint64
+	for shift := uint(0); shift < 60; shift += trieBits {
+		order = order<<trieBits | (hash>>shift)&trieMask
+	}
+
+	// the last level has the 4 highest bits
+	return order<<4 | hash>>60
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"laptop-app-using-grpc/tracing"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// NewLogger returns a structured logger writing text or JSON lines to w.
+// The level is read from level on every call, so it can be changed at runtime.
+func NewLogger(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// ParseLogLevel parses a level name like debug, info, warn or error
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	if err != nil {
+		return level, fmt.Errorf("unknown log level: %s", name)
+	}
+
+	return level, nil
+}
+
+// LoggingInterceptor is a server interceptor that logs every call once, when it ends
+type LoggingInterceptor struct {
+	logger *slog.Logger
+}
+
+// NewLoggingInterceptor returns a new logging interceptor
+func NewLoggingInterceptor(logger *slog.Logger) *LoggingInterceptor {
+	return &LoggingInterceptor{logger}
+}
+
+// Unary returns a server interceptor function to log unary RPC
+func (interceptor *LoggingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		interceptor.log(ctx, info.FullMethod, start, err, 1, 1)
+
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to log stream RPC with its message counts
+func (interceptor *LoggingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		counted := &countingServerStream{ServerStream: stream}
+		err := handler(srv, counted)
+		interceptor.log(stream.Context(), info.FullMethod, start, err, counted.received.Load(), counted.sent.Load())
+
+		return err
+	}
+}
+
+func (interceptor *LoggingInterceptor) log(ctx context.Context, method string, start time.Time, err error, received int64, sent int64) {
+	st := status.Convert(err)
+
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("peer", peerAddress(ctx)),
+		slog.String("code", st.Code().String()),
+		slog.Duration("latency", time.Since(start)),
+		slog.Int64("received", received),
+		slog.Int64("sent", sent),
+	}
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		attrs = append(attrs, slog.String("trace_id", span.SpanContext().TraceID.String()))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", st.Message()))
+	}
+
+	interceptor.logger.LogAttrs(ctx, level, "finished call", attrs...)
+}
+
+// countingServerStream counts the messages received and sent on a stream
+type countingServerStream struct {
+	grpc.ServerStream
+	received atomic.Int64
+	sent     atomic.Int64
+}
+
+// RecvMsg receives a message and counts it
+func (stream *countingServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		stream.received.Add(1)
+	}
+
+	return err
+}
+
+// SendMsg sends a message and counts it
+func (stream *countingServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.sent.Add(1)
+	}
+
+	return err
+}
+
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	return p.Addr.String()
+}
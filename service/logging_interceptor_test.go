@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoggingInterceptor(t *testing.T) {
+	t.Parallel()
+
+	output := &lockedBuffer{}
+	level := &slog.LevelVar{}
+	logger, err := service.NewLogger(output, "json", level)
+	require.NoError(t, err)
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		require.NoError(t, laptopStore.Save(laptop))
+	}
+
+	loggingInterceptor := service.NewLoggingInterceptor(logger)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(loggingInterceptor.Unary()),
+		grpc.StreamInterceptor(loggingInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{
+		Laptop: &pb.Laptop{Id: "invalid-uuid"},
+	})
+	require.Error(t, err)
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 2000},
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	grpcServer.GracefulStop()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+
+	// one line per call, the debug lines of the handlers are below the level
+	require.Len(t, entries, 2)
+
+	require.Equal(t, laptopServicePath+"CreateLaptop", entries[0]["method"])
+	require.Equal(t, "InvalidArgument", entries[0]["code"])
+	require.Equal(t, "WARN", entries[0]["level"])
+	require.NotEmpty(t, entries[0]["peer"])
+
+	require.Equal(t, laptopServicePath+"SearchLaptop", entries[1]["method"])
+	require.Equal(t, "OK", entries[1]["code"])
+	require.EqualValues(t, 1, entries[1]["received"])
+	require.EqualValues(t, 3, entries[1]["sent"])
+	require.Contains(t, entries[1], "latency")
+}
+
+func TestParseLogLevel(t *testing.T) {
+	t.Parallel()
+
+	level, err := service.ParseLogLevel("debug")
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelDebug, level)
+
+	_, err = service.ParseLogLevel("verbose")
+	require.Error(t, err)
+
+	_, err = service.NewLogger(io.Discard, "xml", &slog.LevelVar{})
+	require.Error(t, err)
+}
+
+// lockedBuffer is a buffer that is safe for the server goroutines to write to
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buffer.String()
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"laptop-app-using-grpc/metrics"
+	"time"
+)
+
+// MetricsInterceptor is a server interceptor that counts calls and messages
+// and records call latencies by method and status code
+type MetricsInterceptor struct {
+	handled  *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	received *metrics.CounterVec
+	sent     *metrics.CounterVec
+}
+
+// NewMetricsInterceptor registers the RPC metrics in registry and returns a new metrics interceptor
+func NewMetricsInterceptor(registry *metrics.Registry) *MetricsInterceptor {
+	return &MetricsInterceptor{
+		handled: registry.NewCounterVec(
+			"grpc_server_handled_total",
+			"Total number of RPCs completed on the server, regardless of success or failure.",
+			"grpc_method", "grpc_code",
+		),
+		latency: registry.NewHistogramVec(
+			"grpc_server_handling_seconds",
+			"Latency of RPCs handled by the server.",
+			metrics.DefaultBuckets,
+			"grpc_method", "grpc_code",
+		),
+		received: registry.NewCounterVec(
+			"grpc_server_msg_received_total",
+			"Total number of messages received from clients.",
+			"grpc_method",
+		),
+		sent: registry.NewCounterVec(
+			"grpc_server_msg_sent_total",
+			"Total number of messages sent to clients.",
+			"grpc_method",
+		),
+	}
+}
+
+// Unary returns a server interceptor function to measure unary RPC
+func (interceptor *MetricsInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		interceptor.received.Inc(info.FullMethod)
+
+		res, err := handler(ctx, req)
+		if err == nil {
+			interceptor.sent.Inc(info.FullMethod)
+		}
+
+		interceptor.observe(info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to measure stream RPC
+func (interceptor *MetricsInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, &measuredServerStream{stream, interceptor, info.FullMethod})
+
+		interceptor.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (interceptor *MetricsInterceptor) observe(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	interceptor.handled.Inc(method, code)
+	interceptor.latency.Observe(time.Since(start).Seconds(), method, code)
+}
+
+// measuredServerStream counts every message as it is received or sent
+type measuredServerStream struct {
+	grpc.ServerStream
+	interceptor *MetricsInterceptor
+	method      string
+}
+
+// RecvMsg receives a message and counts it
+func (stream *measuredServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		stream.interceptor.received.Inc(stream.method)
+	}
+
+	return err
+}
+
+// SendMsg sends a message and counts it
+func (stream *measuredServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.interceptor.sent.Inc(stream.method)
+	}
+
+	return err
+}
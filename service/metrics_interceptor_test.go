@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"laptop-app-using-grpc/metrics"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"testing"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < 2; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		require.NoError(t, laptopStore.Save(laptop))
+	}
+
+	registry := metrics.NewRegistry()
+	metricsInterceptor := service.NewMetricsInterceptor(registry)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(metricsInterceptor.Unary()),
+		grpc.StreamInterceptor(metricsInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{
+		Laptop: &pb.Laptop{Id: "invalid-uuid"},
+	})
+	require.Error(t, err)
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 2000},
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	grpcServer.GracefulStop()
+
+	var output bytes.Buffer
+	require.NoError(t, registry.Write(&output))
+	exposition := output.String()
+
+	createLaptop := laptopServicePath + "CreateLaptop"
+	searchLaptop := laptopServicePath + "SearchLaptop"
+	require.Contains(t, exposition, `grpc_server_handled_total{grpc_method="`+createLaptop+`",grpc_code="InvalidArgument"} 1`)
+	require.Contains(t, exposition, `grpc_server_handled_total{grpc_method="`+searchLaptop+`",grpc_code="OK"} 1`)
+	require.Contains(t, exposition, `grpc_server_handling_seconds_count{grpc_method="`+searchLaptop+`",grpc_code="OK"} 1`)
+	require.Contains(t, exposition, `grpc_server_msg_received_total{grpc_method="`+searchLaptop+`"} 1`)
+	require.Contains(t, exposition, `grpc_server_msg_sent_total{grpc_method="`+searchLaptop+`"} 2`)
+	require.Contains(t, exposition, `grpc_server_msg_received_total{grpc_method="`+createLaptop+`"} 1`)
+	require.NotContains(t, exposition, `grpc_server_msg_sent_total{grpc_method="`+createLaptop+`"}`)
+}
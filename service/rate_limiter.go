@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimitKey is the key of the limit used for RPCs without their own limit
+const DefaultRateLimitKey = "*"
+
+// idle buckets are dropped this often to keep memory bounded
+const bucketSweepInterval = time.Minute
+
+// streamRetryDelay is the retry delay suggested when a client has too many
+// streams. It cannot know when a stream ends, so it is only a hint.
+const streamRetryDelay = time.Second
+
+// RateLimit is a token bucket: Rate tokens are added per second, up to Burst
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	// PerMessage makes every message received on a stream take a token,
+	// not only the start of the call
+	PerMessage bool
+}
+
+// RateLimiter limits the calls of every client with one token bucket per
+// client and RPC, and caps the number of concurrent streams per client.
+// Clients are identified by their authenticated identity or peer address.
+type RateLimiter struct {
+	mutex      sync.Mutex
+	limits     map[string]RateLimit
+	maxStreams int
+	buckets    map[bucketKey]*tokenBucket
+	streams    map[string]int
+	sweepAt    time.Time
+}
+
+type bucketKey struct {
+	client string
+	method string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a rate limiter. limits maps the full method name of
+// an RPC, or DefaultRateLimitKey, to its limit; RPCs without a limit are not
+// limited. maxStreams of zero means streams are not capped.
+func NewRateLimiter(limits map[string]RateLimit, maxStreams int) *RateLimiter {
+	limiter := &RateLimiter{
+		buckets: make(map[bucketKey]*tokenBucket),
+		streams: make(map[string]int),
+	}
+	limiter.SetLimits(limits, maxStreams)
+
+	return limiter
+}
+
+// SetLimits replaces the limits, existing buckets keep their tokens
+func (limiter *RateLimiter) SetLimits(limits map[string]RateLimit, maxStreams int) {
+	copied := make(map[string]RateLimit, len(limits))
+	for method, limit := range limits {
+		copied[method] = limit
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.limits = copied
+	limiter.maxStreams = maxStreams
+}
+
+// Allow takes a token for a call of method by client. When the bucket is
+// empty it returns false and how long until the next token is available.
+func (limiter *RateLimiter) Allow(client string, method string) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limit, ok := limiter.limitFor(method)
+	if !ok {
+		return true, 0
+	}
+
+	now := time.Now()
+	limiter.sweep(now)
+
+	key := bucketKey{client, method}
+	bucket := limiter.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.refill(limit, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	if limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// Unary returns a server interceptor that rate limits unary RPC
+func (limiter *RateLimiter) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		client := clientKey(ctx)
+
+		allowed, wait := limiter.Allow(client, info.FullMethod)
+		if !allowed {
+			return nil, rateLimitError(client, info.FullMethod, wait)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a server interceptor that rate limits stream RPC and caps
+// the number of concurrent streams of each client
+func (limiter *RateLimiter) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		client := clientKey(stream.Context())
+
+		allowed, wait := limiter.Allow(client, info.FullMethod)
+		if !allowed {
+			return rateLimitError(client, info.FullMethod, wait)
+		}
+
+		if !limiter.acquireStream(client) {
+			return streamLimitError(client, info.FullMethod)
+		}
+		defer limiter.releaseStream(client)
+
+		limiter.mutex.Lock()
+		limit, ok := limiter.limitFor(info.FullMethod)
+		limiter.mutex.Unlock()
+
+		if ok && limit.PerMessage {
+			stream = &rateLimitedServerStream{stream, limiter, client, info.FullMethod}
+		}
+
+		return handler(srv, stream)
+	}
+}
+
+func (limiter *RateLimiter) acquireStream(client string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.maxStreams > 0 && limiter.streams[client] >= limiter.maxStreams {
+		return false
+	}
+
+	limiter.streams[client]++
+	return true
+}
+
+func (limiter *RateLimiter) releaseStream(client string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.streams[client]--
+	if limiter.streams[client] <= 0 {
+		delete(limiter.streams, client)
+	}
+}
+
+func (limiter *RateLimiter) limitFor(method string) (RateLimit, bool) {
+	limit, ok := limiter.limits[method]
+	if !ok {
+		limit, ok = limiter.limits[DefaultRateLimitKey]
+	}
+
+	return limit, ok
+}
+
+// sweep drops buckets that have refilled completely, they behave like new ones
+func (limiter *RateLimiter) sweep(now time.Time) {
+	if now.Before(limiter.sweepAt) {
+		return
+	}
+
+	for key, bucket := range limiter.buckets {
+		limit, ok := limiter.limitFor(key.method)
+		if !ok {
+			delete(limiter.buckets, key)
+			continue
+		}
+
+		bucket.refill(limit, now)
+		if bucket.tokens >= float64(limit.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+
+	limiter.sweepAt = now.Add(bucketSweepInterval)
+}
+
+func (bucket *tokenBucket) refill(limit RateLimit, now time.Time) {
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+	bucket.last = now
+}
+
+// rateLimitedServerStream takes a token for every received message
+type rateLimitedServerStream struct {
+	grpc.ServerStream
+	limiter *RateLimiter
+	client  string
+	method  string
+}
+
+// RecvMsg receives the next message if the client still has tokens
+func (stream *rateLimitedServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	allowed, wait := stream.limiter.Allow(stream.client, stream.method)
+	if !allowed {
+		return rateLimitError(stream.client, stream.method, wait)
+	}
+
+	return nil
+}
+
+// clientKey identifies the caller by identity, or by peer host when not authenticated
+func clientKey(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return "identity:" + identity.Name
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	return "peer:" + host
+}
+
+func rateLimitError(client string, method string, wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("rate limit exceeded for %s, retry after %v", method, wait.Round(time.Millisecond)))
+
+	detailed, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)},
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     client,
+				Description: "requests per second for " + method,
+			}},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+func streamLimitError(client string, method string) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("too many concurrent streams, retry after %v", streamRetryDelay))
+
+	detailed, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(streamRetryDelay)},
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     client,
+				Description: "concurrent streams, rejected " + method,
+			}},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
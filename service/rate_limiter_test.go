@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterUnary(t *testing.T) {
+	t.Parallel()
+
+	rateLimiter := service.NewRateLimiter(map[string]service.RateLimit{
+		laptopServicePath + "CreateLaptop": {Rate: 1, Burst: 2},
+	}, 0)
+	laptopClient, _ := newTestRateLimitedClient(t, rateLimiter)
+
+	for i := 0; i < 2; i++ {
+		_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		require.NoError(t, err)
+	}
+
+	_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+
+	var retryDelay time.Duration
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			retryDelay = retryInfo.GetRetryDelay().AsDuration()
+		}
+	}
+	require.Greater(t, retryDelay, time.Duration(0))
+	require.LessOrEqual(t, retryDelay, time.Second)
+
+	// the bucket refills after the retry delay
+	time.Sleep(retryDelay)
+	_, err = laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+}
+
+func TestRateLimiterStreams(t *testing.T) {
+	t.Parallel()
+
+	rateLimiter := service.NewRateLimiter(nil, 2)
+	laptopClient, laptopStore := newTestRateLimitedClient(t, rateLimiter)
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+	req := &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 5}
+
+	// two open streams with a handled message use up the cap of this client
+	open := make([]pb.LaptopService_RateLaptopClient, 0, 2)
+	for i := 0; i < 2; i++ {
+		stream, err := laptopClient.RateLaptop(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(req))
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		open = append(open, stream)
+	}
+
+	rejected, err := laptopClient.RateLaptop(context.Background())
+	require.NoError(t, err)
+	_, err = rejected.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	retryInfo := requireDetail[*errdetails.RetryInfo](t, status.Convert(err))
+	require.Positive(t, retryInfo.GetRetryDelay().AsDuration())
+
+	// closing a stream frees a slot for the next one
+	require.NoError(t, open[0].CloseSend())
+	_, err = open[0].Recv()
+	require.Equal(t, io.EOF, err)
+
+	require.Eventually(t, func() bool {
+		stream, err := laptopClient.RateLaptop(context.Background())
+		if err != nil || stream.Send(req) != nil {
+			return false
+		}
+		_, err = stream.Recv()
+		stream.CloseSend()
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func newTestRateLimitedClient(t *testing.T, rateLimiter *service.RateLimiter) (pb.LaptopServiceClient, service.LaptopStore) {
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(rateLimiter.Unary()),
+		grpc.StreamInterceptor(rateLimiter.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewLaptopServiceClient(conn), laptopStore
+}
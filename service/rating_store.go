@@ -6,6 +6,12 @@ import "sync"
 type RatingStore interface {
 	// Add function adds a laptop score to the store and returns the rating
 	Add(laptopId string, score float64) (*Rating, error)
+	// Find returns a copy of the rating of a laptop, or nil if it has not been rated
+	Find(laptopId string) (*Rating, error)
+	// Count returns the total number of ratings of all laptops
+	Count() uint64
+	// Ready returns an error while the store cannot serve requests
+	Ready() error
 }
 
 // Rating has the laptop's scores info
@@ -46,3 +52,42 @@ func (store *InMemoryRatingStore) Add(laptopId string, score float64) (*Rating,
 	store.rating[laptopId] = rating
 	return rating, nil
 }
+
+// Find returns a copy of the rating of a laptop, or nil if it has not been rated
+func (store *InMemoryRatingStore) Find(laptopId string) (*Rating, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return copyRating(store.rating[laptopId]), nil
+}
+
+// Count returns the total number of ratings of all laptops
+func (store *InMemoryRatingStore) Count() uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return countRatings(store.rating)
+}
+
+// Ready always succeeds for the in-memory store
+func (store *InMemoryRatingStore) Ready() error {
+	return nil
+}
+
+func countRatings(ratings map[string]*Rating) uint64 {
+	total := uint64(0)
+	for _, rating := range ratings {
+		total += uint64(rating.Count)
+	}
+
+	return total
+}
+
+func copyRating(rating *Rating) *Rating {
+	if rating == nil {
+		return nil
+	}
+
+	other := *rating
+	return &other
+}
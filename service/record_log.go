@@ -0,0 +1,252 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxRecordSize is the largest record a log or record file holds. A larger
+// size read from disk can only come from a torn or corrupt file.
+const maxRecordSize = 16 << 20
+
+// errRecordTooLarge is returned for a record above maxRecordSize
+var errRecordTooLarge = fmt.Errorf("record is larger than %d bytes", maxRecordSize)
+
+// recordLog is an append-only file of varint length-prefixed records
+type recordLog struct {
+	file *os.File
+	// size is the end of the last record that was written completely
+	size int64
+	// err is the error of the last failed write, until a write succeeds again
+	err    error
+	closed bool
+}
+
+// openRecordLog opens the log at path for appending, creating it if needed
+func openRecordLog(path string) (*recordLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot stat log file: %w", err)
+	}
+
+	return &recordLog{file: file, size: info.Size()}, nil
+}
+
+// Append writes one record to the end of the log and syncs it to disk
+func (log *recordLog) Append(record []byte) error {
+	if len(record) > maxRecordSize {
+		return errRecordTooLarge
+	}
+
+	return log.write(frameRecord(record))
+}
+
+// AppendBatch writes the records to the end of the log and syncs them once
+func (log *recordLog) AppendBatch(records [][]byte) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var data []byte
+	for _, record := range records {
+		if len(record) > maxRecordSize {
+			return errRecordTooLarge
+		}
+		data = append(data, frameRecord(record)...)
+	}
+
+	return log.write(data)
+}
+
+// write appends framed records in one write and syncs the file. A failed
+// write is cut off again, so the next one does not land after garbage.
+func (log *recordLog) write(data []byte) error {
+	_, err := log.file.Write(data)
+	if err != nil {
+		log.err = fmt.Errorf("cannot write log record: %w", err)
+		return log.rollback()
+	}
+
+	err = log.file.Sync()
+	if err != nil {
+		log.err = fmt.Errorf("cannot sync log file: %w", err)
+		return log.rollback()
+	}
+
+	log.size += int64(len(data))
+	log.err = nil
+	return nil
+}
+
+// rollback truncates the log back to the end of the last complete record
+// after a failed write and returns the error of the write
+func (log *recordLog) rollback() error {
+	err := log.file.Truncate(log.size)
+	if err != nil {
+		log.err = errors.Join(log.err, fmt.Errorf("cannot truncate log file: %w", err))
+	}
+
+	return log.err
+}
+
+// Reset drops every record in the log
+func (log *recordLog) Reset() error {
+	err := log.file.Truncate(0)
+	if err != nil {
+		log.err = fmt.Errorf("cannot truncate log file: %w", err)
+		return log.err
+	}
+
+	log.size = 0
+	log.err = log.file.Sync()
+	return log.err
+}
+
+// Close closes the underlying log file
+func (log *recordLog) Close() error {
+	log.closed = true
+	return log.file.Close()
+}
+
+// Ready reports whether the log takes writes. A store replays its log
+// before it is created, so there is no replaying state to report.
+func (log *recordLog) Ready() error {
+	if log.closed {
+		return errors.New("log is closed")
+	}
+
+	return log.err
+}
+
+// readRecords calls fn for every complete record in the file at path.
+// A missing file has no records. It returns the offset right after the
+// last complete record, so a torn write at the end can be cut off. A size
+// that cannot be a record, like one past the end of the file, marks a torn
+// or corrupt tail the same way.
+func readRecords(path string, fn func(record []byte) error) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot open record file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat record file: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, fmt.Errorf("cannot read record size: %w", err)
+		}
+
+		left := info.Size() - offset - int64(uvarintSize(size))
+		if size > maxRecordSize || int64(size) > left {
+			return offset, nil
+		}
+
+		record := make([]byte, size)
+		_, err = io.ReadFull(reader, record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, fmt.Errorf("cannot read record: %w", err)
+		}
+
+		err = fn(record)
+		if err != nil {
+			return offset, err
+		}
+
+		offset += int64(uvarintSize(size)) + int64(size)
+	}
+}
+
+// writeRecordFile atomically replaces the file at path with the given records
+func writeRecordFile(path string, records [][]byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("cannot create record file: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		if len(record) > maxRecordSize {
+			file.Close()
+			return errRecordTooLarge
+		}
+		_, err = writer.Write(frameRecord(record))
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("cannot write record: %w", err)
+		}
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot flush record file: %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("cannot close record file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("cannot replace record file: %w", err)
+	}
+
+	return nil
+}
+
+// truncateFile cuts the file at path to size, ignoring a missing file
+func truncateFile(path string, size int64) error {
+	err := os.Truncate(path, size)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot truncate file: %w", err)
+	}
+
+	return nil
+}
+
+func frameRecord(record []byte) []byte {
+	framed := make([]byte, binary.MaxVarintLen64+len(record))
+	n := binary.PutUvarint(framed, uint64(len(record)))
+	n += copy(framed[n:], record)
+	return framed[:n]
+}
+
+func uvarintSize(value uint64) int {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buffer, value)
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"laptop-app-using-grpc/pb/pb"
+	"sort"
+	"sync"
+)
+
+// number of laptops a shard finds ahead of the merge of a sharded search
+const shardSearchBuffer = 64
+
+// ShardedLaptopStore spreads laptops across in-memory shards by the hash of
+// their ID. Every shard has its own writer lock, so saves to different shards
+// run in parallel, and a search scans all shards in parallel.
+type ShardedLaptopStore struct {
+	shards []*InMemoryLaptopStore
+}
+
+// NewShardedLaptopStore returns a store with the number of shards, at least one
+func NewShardedLaptopStore(shards int) *ShardedLaptopStore {
+	if shards < 1 {
+		shards = 1
+	}
+
+	store := &ShardedLaptopStore{shards: make([]*InMemoryLaptopStore, shards)}
+	for i := range store.shards {
+		store.shards[i] = NewInMemoryLaptopStore()
+	}
+
+	return store
+}
+
+// shardIndex picks the shard of an ID by the high bits of its hash, the trie
+// of a shard starts with the low bits
+func (store *ShardedLaptopStore) shardIndex(id string) int {
+	return int((hashID(id) >> 32) % uint64(len(store.shards)))
+}
+
+// Save saves the laptop to its shard
+func (store *ShardedLaptopStore) Save(laptop *pb.Laptop) error {
+	return store.shards[store.shardIndex(laptop.Id)].Save(laptop)
+}
+
+// SaveBatch locks the shards of the batch in order, so the check and the
+// saves cannot interleave with other writes. Readers may see the laptops of
+// one shard before those of another.
+func (store *ShardedLaptopStore) SaveBatch(laptops []*pb.Laptop, allOrNothing bool) ([]error, error) {
+	batches := make(map[int][]int)
+	for i, laptop := range laptops {
+		index := store.shardIndex(laptop.Id)
+		batches[index] = append(batches[index], i)
+	}
+
+	indices := make([]int, 0, len(batches))
+	for index := range batches {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	for _, index := range indices {
+		shard := store.shards[index]
+		shard.mutex.Lock()
+		defer shard.mutex.Unlock()
+	}
+
+	errs := make([]error, len(laptops))
+	seen := make(map[string]bool, len(laptops))
+	for i, laptop := range laptops {
+		shard := store.shards[store.shardIndex(laptop.Id)]
+		if shard.snapshot.Load().find(laptop.Id) != nil || seen[laptop.Id] {
+			errs[i] = ErrorAlreadyExists
+		}
+		seen[laptop.Id] = true
+	}
+
+	if allOrNothing && hasError(errs) {
+		return errs, nil
+	}
+
+	for _, index := range indices {
+		shard := store.shards[index]
+		snapshot := shard.snapshot.Load()
+		for _, i := range batches[index] {
+			if errs[i] == nil {
+				snapshot = snapshot.with(DeepCopy(laptops[i]))
+			}
+		}
+		shard.snapshot.Store(snapshot)
+	}
+
+	return errs, nil
+}
+
+// Find finds the laptop in its shard
+func (store *ShardedLaptopStore) Find(id string) (*pb.Laptop, error) {
+	return store.shards[store.shardIndex(id)].Find(id)
+}
+
+// shardResult is a laptop found by the search of a shard
+type shardResult struct {
+	laptop *pb.Laptop
+	order  uint64
+	shard  int
+}
+
+// Search scans every shard in its own goroutine and merges the laptops
+// found, so they come in the same order as from an InMemoryLaptopStore with
+// the same laptops. An error from found stops every shard and is returned.
+func (store *ShardedLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan shardResult, len(store.shards))
+	errs := make([]error, len(store.shards))
+	var group sync.WaitGroup
+	for i, shard := range store.shards {
+		i, shard := i, shard
+		results[i] = make(chan shardResult, shardSearchBuffer)
+
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			defer close(results[i])
+
+			errs[i] = shard.Search(ctx, filter, func(laptop *pb.Laptop) error {
+				result := shardResult{laptop: laptop, order: trieOrder(hashID(laptop.GetId())), shard: i}
+				select {
+				case results[i] <- result:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}()
+	}
+
+	err := mergeShardResults(results, found)
+
+	// the shards stop at the cancelled context once found fails
+	cancel()
+	group.Wait()
+	if err != nil {
+		return err
+	}
+
+	for _, shardErr := range errs {
+		if shardErr != nil && !errors.Is(shardErr, context.Canceled) && !errors.Is(shardErr, context.DeadlineExceeded) {
+			return shardErr
+		}
+	}
+
+	return nil
+}
+
+// mergeShardResults calls found with the next laptop of all shards by order
+// until every shard is done or found fails
+func mergeShardResults(results []chan shardResult, found func(laptop *pb.Laptop) error) error {
+	next := make(shardResultHeap, 0, len(results))
+	for _, shardResults := range results {
+		if result, ok := <-shardResults; ok {
+			next = append(next, result)
+		}
+	}
+	heap.Init(&next)
+
+	for len(next) > 0 {
+		result := next[0]
+		err := found(result.laptop)
+		if err != nil {
+			return err
+		}
+
+		if following, ok := <-results[result.shard]; ok {
+			next[0] = following
+			heap.Fix(&next, 0)
+		} else {
+			heap.Pop(&next)
+		}
+	}
+
+	return nil
+}
+
+// shardResultHeap holds the next laptop of every shard, the first in order on top
+type shardResultHeap []shardResult
+
+func (results shardResultHeap) Len() int {
+	return len(results)
+}
+
+func (results shardResultHeap) Less(i, j int) bool {
+	return results[i].order < results[j].order
+}
+
+func (results shardResultHeap) Swap(i, j int) {
+	results[i], results[j] = results[j], results[i]
+}
+
+func (results *shardResultHeap) Push(x interface{}) {
+	*results = append(*results, x.(shardResult))
+}
+
+func (results *shardResultHeap) Pop() interface{} {
+	old := *results
+	result := old[len(old)-1]
+	*results = old[:len(old)-1]
+	return result
+}
+
+// Count returns the number of laptops in all shards
+func (store *ShardedLaptopStore) Count() int {
+	count := 0
+	for _, shard := range store.shards {
+		count += shard.Count()
+	}
+
+	return count
+}
+
+// Ready always succeeds for the sharded store
+func (store *ShardedLaptopStore) Ready() error {
+	return nil
+}
@@ -0,0 +1,284 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// searchIDs returns the IDs of the laptops a search finds, in order
+func searchIDs(t *testing.T, store service.LaptopStore, filter *pb.Filter) []string {
+	var ids []string
+	err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+		ids = append(ids, laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+
+	return ids
+}
+
+func TestShardedLaptopStoreMatchesInMemory(t *testing.T) {
+	t.Parallel()
+
+	laptops := make([]*pb.Laptop, 2000)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+
+	memoryStore := service.NewInMemoryLaptopStore()
+	_, err := memoryStore.SaveBatch(laptops, true)
+	require.NoError(t, err)
+
+	filters := []*pb.Filter{
+		{MaxPriceUsd: math.MaxFloat64},
+		{MaxPriceUsd: 2000, MinCpuCores: 4, MinCpuGhz: 2.5, MinRam: &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}},
+		{MaxPriceUsd: 1},
+	}
+
+	for _, shards := range []int{1, 3, 16} {
+		shards := shards
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			t.Parallel()
+
+			store := service.NewShardedLaptopStore(shards)
+			for _, laptop := range laptops[:1000] {
+				require.NoError(t, store.Save(laptop))
+			}
+			errs, err := store.SaveBatch(laptops[1000:], true)
+			require.NoError(t, err)
+			require.Equal(t, make([]error, 1000), errs)
+			require.Equal(t, len(laptops), store.Count())
+
+			for _, laptop := range laptops {
+				require.ErrorIs(t, store.Save(laptop), service.ErrorAlreadyExists)
+
+				found, err := store.Find(laptop.GetId())
+				require.NoError(t, err)
+				require.True(t, proto.Equal(laptop, found))
+			}
+
+			// the merged results come in the order of the unsharded store
+			for _, filter := range filters {
+				require.Equal(t, searchIDs(t, memoryStore, filter), searchIDs(t, store, filter))
+			}
+		})
+	}
+}
+
+func TestShardedLaptopStoreSearchStops(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewShardedLaptopStore(8)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	all := searchIDs(t, store, &pb.Filter{MaxPriceUsd: math.MaxFloat64})
+	require.Len(t, all, 1000)
+
+	stop := errors.New("stop")
+	var found []string
+	err := store.Search(context.Background(), &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+		found = append(found, laptop.GetId())
+		if len(found) == 10 {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, all[:10], found)
+
+	// a cancelled search ends without an error, like on the unsharded store
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err = store.Search(ctx, &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Less(t, count, 1000)
+}
+
+func TestShardedLaptopStoreConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewShardedLaptopStore(4)
+	const writers, saves = 8, 100
+
+	var group sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for j := 0; j < saves; j++ {
+				laptop := sample.NewLaptop()
+				if err := store.Save(laptop); err != nil {
+					t.Error(err)
+					return
+				}
+
+				if _, err := store.SaveBatch([]*pb.Laptop{laptop, sample.NewLaptop()}, true); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var searches atomic.Int64
+	group.Add(1)
+	go func() {
+		defer group.Done()
+		for ctx.Err() == nil {
+			err := store.Search(ctx, &pb.Filter{MaxPriceUsd: math.MaxFloat64}, func(laptop *pb.Laptop) error {
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			searches.Add(1)
+		}
+	}()
+
+	for store.Count() < writers*saves && !t.Failed() {
+		runtime.Gosched()
+	}
+	cancel()
+	group.Wait()
+
+	// every batch holds a duplicate, so only the saves count
+	require.Equal(t, writers*saves, store.Count())
+	require.Positive(t, searches.Load())
+}
+
+func TestClientSearchShardedStore(t *testing.T) {
+	t.Parallel()
+
+	memoryStore := service.NewInMemoryLaptopStore()
+	store := service.NewShardedLaptopStore(8)
+	for i := 0; i < 100; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, memoryStore.Save(laptop))
+		require.NoError(t, store.Save(laptop))
+	}
+
+	filter := &pb.Filter{MaxPriceUsd: math.MaxFloat64}
+	expected := searchIDs(t, memoryStore, filter)[:5]
+
+	serverAddress := startTestLaptopServer(t, store, nil, nil, service.WithMaxSearchResults(5))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: filter})
+	require.NoError(t, err)
+
+	var found []string
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		found = append(found, res.GetLaptop().GetId())
+	}
+
+	require.Equal(t, expected, found)
+	require.Equal(t, []string{"true"}, stream.Trailer().Get(service.SearchTruncatedTrailer))
+}
+
+// benchmarkProcs are the GOMAXPROCS values the parallel store benchmarks run with
+var benchmarkProcs = []int{1, 2, 4, 8}
+
+// benchmarkStores are the stores the parallel benchmarks compare
+var benchmarkStores = []struct {
+	name     string
+	newStore func() service.LaptopStore
+}{
+	{name: "memory", newStore: func() service.LaptopStore { return service.NewInMemoryLaptopStore() }},
+	{name: "sharded=16", newStore: func() service.LaptopStore { return service.NewShardedLaptopStore(16) }},
+}
+
+// runWithProcs runs the benchmark once for every GOMAXPROCS value
+func runWithProcs(b *testing.B, name string, benchmark func(b *testing.B)) {
+	for _, procs := range benchmarkProcs {
+		b.Run(fmt.Sprintf("%s/procs=%d", name, procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			benchmark(b)
+		})
+	}
+}
+
+func BenchmarkLaptopStoreParallelSave(b *testing.B) {
+	for _, benchmarkStore := range benchmarkStores {
+		newStore := benchmarkStore.newStore
+		runWithProcs(b, benchmarkStore.name, func(b *testing.B) {
+			store := newStore()
+			laptops := make([]*pb.Laptop, b.N)
+			for i := range laptops {
+				laptops[i] = sample.NewLaptop()
+			}
+
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(parallel *testing.PB) {
+				for parallel.Next() {
+					err := store.Save(laptops[next.Add(1)-1])
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLaptopStoreParallelSearch(b *testing.B) {
+	filter := &pb.Filter{
+		MaxPriceUsd: 2000,
+		MinCpuCores: 4,
+		MinCpuGhz:   2.5,
+		MinRam:      &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE},
+	}
+
+	laptops := make([]*pb.Laptop, 10000)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+
+	for _, benchmarkStore := range benchmarkStores {
+		newStore := benchmarkStore.newStore
+		runWithProcs(b, benchmarkStore.name, func(b *testing.B) {
+			store := newStore()
+			_, err := store.SaveBatch(laptops, true)
+			require.NoError(b, err)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
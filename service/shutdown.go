@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Drainer is a server that is drained together with the gRPC server, like
+// the HTTP gateway
+type Drainer interface {
+	// Shutdown stops taking new requests and waits for the active ones. When
+	// ctx is done the remaining requests are cancelled.
+	Shutdown(ctx context.Context) error
+}
+
+// GracefulShutdown stops the server in order: health turns NOT_SERVING, the
+// gRPC server and the drainers refuse new calls at the same time, and active
+// calls get up to timeout in total to finish before they are cancelled. The
+// closers, usually stores, are closed last so they can flush the writes of
+// the drained calls.
+func GracefulShutdown(grpcServer *grpc.Server, healthChecker *HealthChecker, timeout time.Duration, drainers []Drainer, closers ...io.Closer) error {
+	if healthChecker != nil {
+		healthChecker.Shutdown()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	var errsMutex sync.Mutex
+	wg := sync.WaitGroup{}
+	for _, drainer := range drainers {
+		wg.Add(1)
+		go func(drainer Drainer) {
+			defer wg.Done()
+
+			err := drainer.Shutdown(ctx)
+			if err != nil {
+				errsMutex.Lock()
+				errs = append(errs, fmt.Errorf("cannot drain %T: %w", drainer, err))
+				errsMutex.Unlock()
+			}
+		}(drainer)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		slog.Info("all calls finished")
+	case <-ctx.Done():
+		slog.Warn("calls did not finish in time, cancelling them", "timeout", timeout)
+		grpcServer.Stop()
+		<-stopped
+	}
+	wg.Wait()
+
+	for _, closer := range closers {
+		err := closer.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot close %T: %w", closer, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
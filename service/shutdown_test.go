@@ -0,0 +1,173 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdownFinishesUpload(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	grpcServer, healthServer, laptopClient, started := startShutdownTestServer(t, laptopStore, imageStore)
+	healthChecker := service.NewHealthChecker(healthServer, time.Hour)
+	healthChecker.AddService(pb.LaptopService_ServiceDesc.ServiceName, laptopStore, imageStore)
+	healthChecker.Start()
+
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"},
+		},
+	}))
+	chunk := make([]byte, 1024)
+	require.NoError(t, stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: chunk},
+	}))
+
+	// wait until the upload is running on the server
+	requireStarted(t, started)
+
+	closer := &recordingCloser{}
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- service.GracefulShutdown(grpcServer, healthChecker, 5*time.Second, nil, closer)
+	}()
+
+	// the server is draining: health is down and new calls are refused
+	require.Eventually(t, func() bool {
+		res, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err == nil && res.GetStatus() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := laptopClient.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		return status.Code(err) == codes.Unavailable
+	}, time.Second, 10*time.Millisecond)
+
+	// the upload in progress still finishes
+	for i := 0; i < 3; i++ {
+		require.NoError(t, stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: chunk},
+		}))
+	}
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.EqualValues(t, 4*len(chunk), res.GetSize())
+
+	require.NoError(t, <-shutdownErr)
+	require.True(t, closer.closed)
+
+	count, size := imageStore.Usage()
+	require.Equal(t, 1, count)
+	require.EqualValues(t, 4*len(chunk), size)
+}
+
+func TestGracefulShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	grpcServer, _, laptopClient, started := startShutdownTestServer(t, laptopStore, service.NewDiskImageStore(t.TempDir()))
+
+	// an upload that never ends is cancelled after the timeout
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"},
+		},
+	}))
+	requireStarted(t, started)
+
+	// a drainer with a request that never ends shares the same deadline
+	drainer := &blockingDrainer{}
+	closer := &recordingCloser{}
+	start := time.Now()
+	require.NoError(t, service.GracefulShutdown(grpcServer, nil, 100*time.Millisecond, []service.Drainer{drainer}, closer))
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	require.Less(t, elapsed, 200*time.Millisecond)
+	require.WithinDuration(t, start.Add(100*time.Millisecond), drainer.deadline, 20*time.Millisecond)
+	require.True(t, closer.closed)
+
+	_, err = stream.CloseAndRecv()
+	require.Error(t, err)
+}
+
+// startShutdownTestServer serves the laptop and health services, the returned
+// channel receives a value whenever a stream call starts on the server
+func startShutdownTestServer(t *testing.T, laptopStore service.LaptopStore, imageStore service.ImageStore) (*grpc.Server, *health.Server, pb.LaptopServiceClient, <-chan struct{}) {
+	started := make(chan struct{}, 10)
+	grpcServer := grpc.NewServer(grpc.StreamInterceptor(func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		started <- struct{}{}
+		return handler(srv, stream)
+	}))
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, nil))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return grpcServer, healthServer, pb.NewLaptopServiceClient(conn), started
+}
+
+func requireStarted(t *testing.T, started <-chan struct{}) {
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		require.FailNow(t, "the stream did not start on the server")
+	}
+}
+
+// recordingCloser remembers that it was closed
+type recordingCloser struct {
+	closed bool
+}
+
+func (closer *recordingCloser) Close() error {
+	closer.closed = true
+	return nil
+}
+
+// blockingDrainer has a request that only ends when the shutdown deadline is reached
+type blockingDrainer struct {
+	deadline time.Time
+}
+
+func (drainer *blockingDrainer) Shutdown(ctx context.Context) error {
+	drainer.deadline, _ = ctx.Deadline()
+	<-ctx.Done()
+	return nil
+}
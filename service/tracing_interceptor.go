@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"laptop-app-using-grpc/tracing"
+)
+
+// TracingInterceptor is a server interceptor that starts a span for every
+// call, continuing the trace of the client when it sends a traceparent
+type TracingInterceptor struct {
+	tracer *tracing.Tracer
+}
+
+// NewTracingInterceptor returns a new tracing interceptor
+func NewTracingInterceptor(tracer *tracing.Tracer) *TracingInterceptor {
+	return &TracingInterceptor{tracer}
+}
+
+// Unary returns a server interceptor function to trace unary RPC
+func (interceptor *TracingInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := interceptor.start(ctx, info.FullMethod)
+		res, err := handler(ctx, req)
+		interceptor.end(span, err)
+
+		return res, err
+	}
+}
+
+// Stream returns a server interceptor function to trace stream RPC
+func (interceptor *TracingInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := interceptor.start(stream.Context(), info.FullMethod)
+		err := handler(srv, &contextServerStream{stream, ctx})
+		interceptor.end(span, err)
+
+		return err
+	}
+}
+
+func (interceptor *TracingInterceptor) start(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		values := md.Get(tracing.TraceparentHeader)
+		if len(values) > 0 {
+			// a malformed traceparent starts a new trace, like a missing one
+			remote, err := tracing.ParseTraceparent(values[0])
+			if err == nil {
+				ctx = tracing.ContextWithRemoteSpanContext(ctx, remote)
+			}
+		}
+	}
+
+	ctx, span := interceptor.tracer.Start(ctx, method, tracing.KindServer)
+	span.SetAttribute("rpc.method", method)
+	span.SetAttribute("net.peer", peerAddress(ctx))
+
+	return ctx, span
+}
+
+func (interceptor *TracingInterceptor) end(span *tracing.Span, err error) {
+	span.SetAttribute("rpc.grpc.status_code", status.Code(err).String())
+	span.SetError(err)
+	span.End()
+}
@@ -0,0 +1,160 @@
+package service_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"laptop-app-using-grpc/client"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/service"
+	"laptop-app-using-grpc/tracing"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestTracingSearchLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < 2; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		require.NoError(t, laptopStore.Save(laptop))
+	}
+
+	exporter := &recordingExporter{}
+	serverInterceptor := service.NewTracingInterceptor(tracing.NewTracer("server", exporter))
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(serverInterceptor.Unary()),
+		grpc.StreamInterceptor(serverInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	clientInterceptor := client.NewTracingInterceptor(tracing.NewTracer("client", exporter))
+	conn, err := grpc.Dial(
+		listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(clientInterceptor.Unary()),
+		grpc.WithStreamInterceptor(clientInterceptor.Stream()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 2000},
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	grpcServer.GracefulStop()
+
+	spans := exporter.byName()
+	clientSpan := spans[laptopServicePath+"SearchLaptop"]["client"]
+	serverSpan := spans[laptopServicePath+"SearchLaptop"]["server"]
+	searchSpan := spans["LaptopStore.Search"]["server"]
+	require.Equal(t, tracing.KindClient, clientSpan[0].Kind)
+	require.Empty(t, clientSpan[0].ParentSpanID)
+	require.Equal(t, "OK", clientSpan[0].Attributes["rpc.grpc.status_code"])
+
+	// the server continues the trace of the client
+	require.Equal(t, clientSpan[0].TraceID, serverSpan[0].TraceID)
+	require.Equal(t, clientSpan[0].SpanID, serverSpan[0].ParentSpanID)
+	require.Equal(t, serverSpan[0].SpanID, searchSpan[0].ParentSpanID)
+
+	require.Len(t, spans["DeepCopy"]["server"], 2)
+	require.Len(t, spans["SearchLaptop.Send"]["server"], 2)
+	for _, name := range []string{"DeepCopy", "SearchLaptop.Send"} {
+		for _, span := range spans[name]["server"] {
+			require.Equal(t, clientSpan[0].TraceID, span.TraceID)
+			require.Equal(t, searchSpan[0].SpanID, span.ParentSpanID)
+		}
+	}
+}
+
+func TestTracingRateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	exporter := &recordingExporter{}
+	serverInterceptor := service.NewTracingInterceptor(tracing.NewTracer("server", exporter))
+	grpcServer := grpc.NewServer(grpc.StreamInterceptor(serverInterceptor.Stream()))
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore()))
+	t.Cleanup(grpcServer.Stop)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// a client without tracing gets a new trace on the server
+	stream, err := pb.NewLaptopServiceClient(conn).RateLaptop(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	grpcServer.GracefulStop()
+
+	spans := exporter.byName()
+	serverSpan := spans[laptopServicePath+"RateLaptop"]["server"]
+	require.Len(t, serverSpan, 1)
+	require.Empty(t, serverSpan[0].ParentSpanID)
+	for _, name := range []string{"LaptopStore.Find", "RatingStore.Add"} {
+		require.Len(t, spans[name]["server"], 1)
+		require.Equal(t, serverSpan[0].SpanID, spans[name]["server"][0].ParentSpanID)
+	}
+}
+
+// recordingExporter keeps every exported span in memory
+type recordingExporter struct {
+	mutex sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (exporter *recordingExporter) Export(span tracing.SpanData) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	exporter.spans = append(exporter.spans, span)
+}
+
+// byName groups the spans by name and then by service
+func (exporter *recordingExporter) byName() map[string]map[string][]tracing.SpanData {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	spans := make(map[string]map[string][]tracing.SpanData)
+	for _, span := range exporter.spans {
+		if spans[span.Name] == nil {
+			spans[span.Name] = make(map[string][]tracing.SpanData)
+		}
+		spans[span.Name][span.Service] = append(spans[span.Name][span.Service], span)
+	}
+
+	return spans
+}
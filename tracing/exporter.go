@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Exporter receives every finished and sampled span
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// JSONExporter writes one JSON object per span and line
+type JSONExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONExporter returns an exporter writing to w
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{encoder: json.NewEncoder(w)}
+}
+
+// NewExporter returns an exporter writing to stdout for "stdout" and
+// appending to the file at output otherwise
+func NewExporter(output string) (*JSONExporter, error) {
+	if output == "stdout" {
+		return NewJSONExporter(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open trace file: %w", err)
+	}
+
+	exporter := NewJSONExporter(file)
+	exporter.closer = file
+	return exporter, nil
+}
+
+// Export writes the span, a failed write is logged and the span dropped
+func (exporter *JSONExporter) Export(span SpanData) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	err := exporter.encoder.Encode(span)
+	if err != nil {
+		log.Print("cannot export span: ", err)
+	}
+}
+
+// Close closes the trace file, if the exporter opened one
+func (exporter *JSONExporter) Close() error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	if exporter.closer == nil {
+		return nil
+	}
+	return exporter.closer.Close()
+}
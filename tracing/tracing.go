@@ -0,0 +1,280 @@
+// Package tracing records spans of work across RPCs and exports them, without
+// needing a collector. Trace context travels between processes in the W3C
+// traceparent format.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the metadata key that carries the trace context
+const TraceparentHeader = "traceparent"
+
+// Span kinds
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+)
+
+// TraceID identifies a whole trace
+type TraceID [16]byte
+
+// String returns the ID in lowercase hex
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a single span in a trace
+type SpanID [8]byte
+
+// String returns the ID in lowercase hex
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that is propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a version 00 traceparent value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent value like
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent: %q", value)
+	}
+
+	sc := SpanContext{}
+	err := decodeHex(sc.TraceID[:], parts[1])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace id in traceparent: %w", err)
+	}
+
+	err = decodeHex(sc.SpanID[:], parts[2])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid span id in traceparent: %w", err)
+	}
+
+	flags := [1]byte{}
+	err = decodeHex(flags[:], parts[3])
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid flags in traceparent: %w", err)
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent has a zero id: %q", value)
+	}
+
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex into dst, which it must fill exactly
+func decodeHex(dst []byte, value string) error {
+	if len(value) != hex.EncodedLen(len(dst)) || strings.ToLower(value) != value {
+		return fmt.Errorf("%q is not %d lowercase hex digits", value, hex.EncodedLen(len(dst)))
+	}
+
+	_, err := hex.Decode(dst, []byte(value))
+	return err
+}
+
+// Tracer starts spans and hands the finished ones to its exporter
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+}
+
+// NewTracer returns a tracer that names its spans' service serviceName
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	return &Tracer{
+		serviceName: serviceName,
+		exporter:    exporter,
+	}
+}
+
+// Start starts a span as a child of the span in ctx, or of the remote span
+// context in ctx, or as the root of a new trace
+func (tracer *Tracer) Start(ctx context.Context, name string, kind string) (context.Context, *Span) {
+	span := &Span{
+		tracer:     tracer,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.parentID = parent.context.SpanID
+		span.context.TraceID = parent.context.TraceID
+		span.context.Sampled = parent.context.Sampled
+	} else if remote, ok := ctx.Value(remoteSpanContextKey{}).(SpanContext); ok {
+		span.parentID = remote.SpanID
+		span.context.TraceID = remote.TraceID
+		span.context.Sampled = remote.Sampled
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+	rand.Read(span.context.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartSpan starts an internal child span of the span in ctx with the same
+// tracer. Without a span in ctx tracing is off and the returned span is nil,
+// which is safe to use.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	return parent.tracer.Start(ctx, name, KindInternal)
+}
+
+type spanKey struct{}
+
+type remoteSpanContextKey struct{}
+
+// SpanFromContext returns the current span of ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a context whose next span continues
+// the trace of a span in another process
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
+
+// Span is a timed operation in a trace. All methods do nothing on a nil span.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     string
+	context  SpanContext
+	parentID SpanID
+	start    time.Time
+
+	mutex      sync.Mutex
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+// SpanContext returns the IDs of the span for propagation
+func (span *Span) SpanContext() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+
+	return span.context
+}
+
+// SetAttribute records a key value pair on the span
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	span.attributes[key] = value
+}
+
+// SetError marks the span as failed with err, a nil error is ignored
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	span.err = err
+}
+
+// End finishes the span and exports it if it is sampled, only the first call counts
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	end := time.Now()
+
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+
+	data := SpanData{
+		TraceID:    span.context.TraceID.String(),
+		SpanID:     span.context.SpanID.String(),
+		Name:       span.name,
+		Kind:       span.kind,
+		Service:    span.tracer.serviceName,
+		Start:      span.start,
+		End:        end,
+		Duration:   end.Sub(span.start),
+		Attributes: make(map[string]interface{}, len(span.attributes)),
+	}
+	if span.parentID != (SpanID{}) {
+		data.ParentSpanID = span.parentID.String()
+	}
+	for key, value := range span.attributes {
+		data.Attributes[key] = value
+	}
+	if span.err != nil {
+		data.Error = span.err.Error()
+	}
+	span.mutex.Unlock()
+
+	if span.context.Sampled && span.tracer.exporter != nil {
+		span.tracer.exporter.Export(data)
+	}
+}
+
+// SpanData is a finished span as it is exported
+type SpanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Service      string                 `json:"service"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Duration     time.Duration          `json:"duration_ns"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
@@ -0,0 +1,113 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"laptop-app-using-grpc/tracing"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := tracing.ParseTraceparent(value)
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.True(t, sc.Sampled)
+	require.Equal(t, value, sc.Traceparent())
+
+	sc, err = tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+	require.False(t, sc.Sampled)
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	for _, value := range invalid {
+		_, err := tracing.ParseTraceparent(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestTracerSpans(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	tracer := tracing.NewTracer("test", tracing.NewJSONExporter(&output))
+
+	remote, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+
+	ctx := tracing.ContextWithRemoteSpanContext(context.Background(), remote)
+	ctx, root := tracer.Start(ctx, "root", tracing.KindServer)
+	_, child := tracing.StartSpan(ctx, "child")
+	child.SetAttribute("items", 3)
+	child.SetError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.End()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 2)
+
+	spans := make([]tracing.SpanData, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &spans[i]))
+		require.Equal(t, remote.TraceID.String(), spans[i].TraceID)
+		require.Equal(t, "test", spans[i].Service)
+	}
+
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, tracing.KindInternal, spans[0].Kind)
+	require.Equal(t, root.SpanContext().SpanID.String(), spans[0].ParentSpanID)
+	require.EqualValues(t, 3, spans[0].Attributes["items"])
+	require.Equal(t, "boom", spans[0].Error)
+
+	require.Equal(t, "root", spans[1].Name)
+	require.Equal(t, remote.SpanID.String(), spans[1].ParentSpanID)
+	require.GreaterOrEqual(t, spans[1].Duration, spans[0].Duration)
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	tracer := tracing.NewTracer("test", tracing.NewJSONExporter(&output))
+
+	remote, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+
+	ctx, span := tracer.Start(tracing.ContextWithRemoteSpanContext(context.Background(), remote), "root", tracing.KindServer)
+	require.False(t, span.SpanContext().Sampled)
+	_, child := tracing.StartSpan(ctx, "child")
+	child.End()
+	span.End()
+
+	require.Empty(t, output.String())
+}
+
+func TestStartSpanWithoutTracer(t *testing.T) {
+	t.Parallel()
+
+	ctx, span := tracing.StartSpan(context.Background(), "child")
+	require.Nil(t, span)
+	require.Nil(t, tracing.SpanFromContext(ctx))
+
+	// a nil span is safe to use
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("boom"))
+	span.End()
+	require.False(t, span.SpanContext().IsValid())
+}
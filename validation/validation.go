@@ -0,0 +1,244 @@
+// Package validation checks laptops against the domain rules that their
+// protobuf types cannot express, like a CPU with fewer threads than cores or
+// a memory size without a unit. Every broken rule is reported with the path
+// of its field, such as cpu.max_ghz or gpus[1].memory.unit, so a client can
+// fix all of them at once.
+package validation
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"laptop-app-using-grpc/pb/pb"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the longest brand or name accepted
+const maxTextLength = 100
+
+// the oldest release year accepted, a year in the future is accepted too
+const minReleaseYear = 1970
+
+// the largest screen accepted
+const maxScreenInch = 100
+
+// Violation is a rule broken by the field at Path. The path of the message
+// itself is empty.
+type Violation struct {
+	Path        string
+	Description string
+}
+
+// Violations lists every broken rule of a message, in field order
+type Violations []Violation
+
+// Error joins the violations, so they can be returned as an error
+func (violations Violations) Error() string {
+	descriptions := make([]string, len(violations))
+	for i, violation := range violations {
+		descriptions[i] = violation.Path + ": " + violation.Description
+	}
+
+	return strings.Join(descriptions, "; ")
+}
+
+// Laptop checks the laptop and all its nested messages. A laptop without an
+// ID is valid, the server gives it one.
+func Laptop(laptop *pb.Laptop) Violations {
+	v := &validator{}
+	v.laptop("", laptop)
+
+	return v.violations
+}
+
+// Path joins the path of a message field and the path of a violation inside it
+func Path(parent string, path string) string {
+	if len(parent) == 0 {
+		return path
+	}
+	if len(path) == 0 {
+		return parent
+	}
+	if strings.HasPrefix(path, "[") {
+		return parent + path
+	}
+
+	return parent + "." + path
+}
+
+// validator collects the violations of one message. Every message type has
+// a method listing its rules, one check per line.
+type validator struct {
+	violations Violations
+}
+
+func (v *validator) laptop(path string, laptop *pb.Laptop) {
+	if !v.required(path, laptop != nil) {
+		return
+	}
+
+	if id := laptop.GetId(); len(id) > 0 {
+		_, err := uuid.Parse(id)
+		v.check(err == nil, Path(path, "id"), "%q is not a valid UUID", id)
+	}
+	v.text(Path(path, "brand"), laptop.GetBrand())
+	v.text(Path(path, "name"), laptop.GetName())
+	v.cpu(Path(path, "cpu"), laptop.GetCpu())
+	v.memory(Path(path, "ram"), laptop.GetRam())
+	for i, gpu := range laptop.GetGpus() {
+		v.gpu(Path(path, fmt.Sprintf("gpus[%d]", i)), gpu)
+	}
+	v.check(len(laptop.GetStorages()) > 0, Path(path, "storages"), "at least one storage is required")
+	for i, storage := range laptop.GetStorages() {
+		v.storage(Path(path, fmt.Sprintf("storages[%d]", i)), storage)
+	}
+	v.screen(Path(path, "screen"), laptop.GetScreen())
+	v.keyboard(Path(path, "keyboard"), laptop.GetKeyboard())
+
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		v.positive(Path(path, "weight_kg"), weight.WeightKg)
+	case *pb.Laptop_WeightLb:
+		v.positive(Path(path, "weight_lb"), weight.WeightLb)
+	}
+
+	price := laptop.GetPriceUsd()
+	v.check(price >= 0 && !math.IsInf(price, 1), Path(path, "price_usd"), "must not be negative, got %v", price)
+
+	if year := laptop.GetReleaseYear(); year != 0 {
+		maxYear := uint32(time.Now().Year() + 1)
+		v.check(year >= minReleaseYear && year <= maxYear, Path(path, "release_year"), "must be between %d and %d, got %d", minReleaseYear, maxYear, year)
+	}
+	if updatedAt := laptop.GetUpdatedAt(); updatedAt != nil {
+		err := updatedAt.CheckValid()
+		v.check(err == nil, Path(path, "updated_at"), "is not a valid timestamp: %v", err)
+	}
+}
+
+func (v *validator) cpu(path string, cpu *pb.CPU) {
+	if !v.required(path, cpu != nil) {
+		return
+	}
+
+	v.text(Path(path, "brand"), cpu.GetBrand())
+	v.text(Path(path, "name"), cpu.GetName())
+	v.check(cpu.GetCpuCores() > 0, Path(path, "cpu_cores"), "must be at least 1")
+	v.check(cpu.GetCpuThreads() >= cpu.GetCpuCores(), Path(path, "cpu_threads"), "must not be lower than cpu_cores %d, got %d", cpu.GetCpuCores(), cpu.GetCpuThreads())
+	v.frequencies(path, cpu.GetMinGhz(), cpu.GetMaxGhz())
+}
+
+func (v *validator) gpu(path string, gpu *pb.GPU) {
+	if !v.required(path, gpu != nil) {
+		return
+	}
+
+	v.text(Path(path, "brand"), gpu.GetBrand())
+	v.text(Path(path, "name"), gpu.GetName())
+	v.frequencies(path, gpu.GetMinGhz(), gpu.GetMaxGhz())
+	v.memory(Path(path, "memory"), gpu.GetMemory())
+}
+
+func (v *validator) memory(path string, memory *pb.Memory) {
+	if !v.required(path, memory != nil) {
+		return
+	}
+
+	v.check(memory.GetValue() > 0, Path(path, "value"), "must be at least 1")
+	v.enum(Path(path, "unit"), int32(memory.GetUnit()), pb.Memory_Unit_name)
+}
+
+func (v *validator) storage(path string, storage *pb.Storage) {
+	if !v.required(path, storage != nil) {
+		return
+	}
+
+	v.enum(Path(path, "driver"), int32(storage.GetDriver()), pb.Storage_Driver_name)
+	v.memory(Path(path, "memory"), storage.GetMemory())
+}
+
+func (v *validator) screen(path string, screen *pb.Screen) {
+	if !v.required(path, screen != nil) {
+		return
+	}
+
+	size := screen.GetSizeInch()
+	v.check(size > 0 && size <= maxScreenInch, Path(path, "size_inch"), "must be above 0 and at most %d, got %v", maxScreenInch, size)
+
+	resolution := screen.GetResolution()
+	if v.required(Path(path, "resolution"), resolution != nil) {
+		v.check(resolution.GetWidth() > 0, Path(path, "resolution.width"), "must be at least 1")
+		v.check(resolution.GetHeight() > 0, Path(path, "resolution.height"), "must be at least 1")
+	}
+
+	v.enum(Path(path, "panel"), int32(screen.GetPanel()), pb.Screen_Panel_name)
+}
+
+func (v *validator) keyboard(path string, keyboard *pb.Keyboard) {
+	if !v.required(path, keyboard != nil) {
+		return
+	}
+
+	v.enum(Path(path, "layout"), int32(keyboard.GetLayout()), pb.Keyboard_Layout_name)
+}
+
+// frequencies checks the min_ghz and max_ghz fields of a processor
+func (v *validator) frequencies(path string, minGhz float64, maxGhz float64) {
+	// max_ghz is only compared with a valid min_ghz
+	if !v.positive(Path(path, "min_ghz"), minGhz) {
+		return
+	}
+	v.check(maxGhz >= minGhz && !math.IsInf(maxGhz, 1), Path(path, "max_ghz"), "must not be lower than min_ghz %v, got %v", minGhz, maxGhz)
+}
+
+// required reports whether a message is set and adds a violation if not
+func (v *validator) required(path string, set bool) bool {
+	v.check(set, path, "is required")
+	return set
+}
+
+// text checks a required name, which must not be blank or too long
+func (v *validator) text(path string, value string) {
+	if len(strings.TrimSpace(value)) == 0 {
+		v.add(path, "is required")
+		return
+	}
+
+	v.check(utf8.RuneCountInString(value) <= maxTextLength, path, "must be at most %d characters", maxTextLength)
+}
+
+// positive checks a finite number above zero, which is false for NaN too
+func (v *validator) positive(path string, value float64) bool {
+	ok := value > 0 && !math.IsInf(value, 1)
+	v.check(ok, path, "must be above 0, got %v", value)
+	return ok
+}
+
+// enum checks that an enum is set to a known value other than UNKNOWN
+func (v *validator) enum(path string, value int32, names map[int32]string) {
+	_, known := names[value]
+	v.check(value != 0 && known, path, "must be set to one of %s", enumNames(names))
+}
+
+func (v *validator) check(ok bool, path string, format string, args ...interface{}) {
+	if !ok {
+		v.add(path, format, args...)
+	}
+}
+
+func (v *validator) add(path string, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Path: path, Description: fmt.Sprintf(format, args...)})
+}
+
+// enumNames lists the values of an enum without UNKNOWN, in number order
+func enumNames(names map[int32]string) string {
+	var values []string
+	for value := int32(1); value < int32(len(names)); value++ {
+		if name, ok := names[value]; ok {
+			values = append(values, name)
+		}
+	}
+
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,115 @@
+package validation_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"laptop-app-using-grpc/pb/pb"
+	"laptop-app-using-grpc/sample"
+	"laptop-app-using-grpc/validation"
+	"math"
+	"testing"
+)
+
+func TestSampleLaptopsAreValid(t *testing.T) {
+	t.Parallel()
+
+	for i := 0; i < 100; i++ {
+		require.Empty(t, validation.Laptop(sample.NewLaptop()))
+	}
+
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+	laptop.Gpus = nil
+	laptop.Weight = nil
+	laptop.ReleaseYear = 0
+	laptop.UpdatedAt = nil
+	require.Empty(t, validation.Laptop(laptop))
+}
+
+func TestLaptopViolations(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		change func(laptop *pb.Laptop)
+		path   string
+	}{
+		{"invalid id", func(laptop *pb.Laptop) { laptop.Id = "invalid-uuid" }, "id"},
+		{"no brand", func(laptop *pb.Laptop) { laptop.Brand = " " }, "brand"},
+		{"long name", func(laptop *pb.Laptop) { laptop.Name = string(make([]byte, 101)) + "x" }, "name"},
+		{"no cpu", func(laptop *pb.Laptop) { laptop.Cpu = nil }, "cpu"},
+		{"no cpu cores", func(laptop *pb.Laptop) { laptop.Cpu.CpuCores, laptop.Cpu.CpuThreads = 0, 0 }, "cpu.cpu_cores"},
+		{"fewer threads than cores", func(laptop *pb.Laptop) { laptop.Cpu.CpuCores, laptop.Cpu.CpuThreads = 8, 4 }, "cpu.cpu_threads"},
+		{"zero min ghz", func(laptop *pb.Laptop) { laptop.Cpu.MinGhz, laptop.Cpu.MaxGhz = 0, 3 }, "cpu.min_ghz"},
+		{"min ghz above max", func(laptop *pb.Laptop) { laptop.Cpu.MinGhz, laptop.Cpu.MaxGhz = 4, 3 }, "cpu.max_ghz"},
+		{"no ram", func(laptop *pb.Laptop) { laptop.Ram = nil }, "ram"},
+		{"zero ram", func(laptop *pb.Laptop) { laptop.Ram.Value = 0 }, "ram.value"},
+		{"unknown ram unit", func(laptop *pb.Laptop) { laptop.Ram.Unit = pb.Memory_UNKNOWN }, "ram.unit"},
+		{"undefined ram unit", func(laptop *pb.Laptop) { laptop.Ram.Unit = 42 }, "ram.unit"},
+		{"gpu without memory", func(laptop *pb.Laptop) {
+			laptop.Gpus = append(laptop.Gpus, sample.NewGPU())
+			laptop.Gpus[1].Memory = nil
+		}, "gpus[1].memory"},
+		{"gpu nan ghz", func(laptop *pb.Laptop) { laptop.Gpus[0].MinGhz = math.NaN() }, "gpus[0].min_ghz"},
+		{"no storage", func(laptop *pb.Laptop) { laptop.Storages = nil }, "storages"},
+		{"unknown driver", func(laptop *pb.Laptop) { laptop.Storages[1].Driver = pb.Storage_UNKNOWN }, "storages[1].driver"},
+		{"storage unit", func(laptop *pb.Laptop) { laptop.Storages[0].Memory.Unit = pb.Memory_UNKNOWN }, "storages[0].memory.unit"},
+		{"no screen", func(laptop *pb.Laptop) { laptop.Screen = nil }, "screen"},
+		{"zero screen size", func(laptop *pb.Laptop) { laptop.Screen.SizeInch = 0 }, "screen.size_inch"},
+		{"no resolution", func(laptop *pb.Laptop) { laptop.Screen.Resolution = nil }, "screen.resolution"},
+		{"zero resolution", func(laptop *pb.Laptop) { laptop.Screen.Resolution.Width = 0 }, "screen.resolution.width"},
+		{"unknown panel", func(laptop *pb.Laptop) { laptop.Screen.Panel = pb.Screen_UNKNOWN }, "screen.panel"},
+		{"unknown layout", func(laptop *pb.Laptop) { laptop.Keyboard.Layout = pb.Keyboard_UNKNOWN }, "keyboard.layout"},
+		{"negative weight", func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightLb{WeightLb: -1} }, "weight_lb"},
+		{"negative price", func(laptop *pb.Laptop) { laptop.PriceUsd = -1 }, "price_usd"},
+		{"infinite price", func(laptop *pb.Laptop) { laptop.PriceUsd = math.Inf(1) }, "price_usd"},
+		{"old release year", func(laptop *pb.Laptop) { laptop.ReleaseYear = 1900 }, "release_year"},
+		{"future release year", func(laptop *pb.Laptop) { laptop.ReleaseYear = 3000 }, "release_year"},
+		{"invalid timestamp", func(laptop *pb.Laptop) { laptop.UpdatedAt = &timestamppb.Timestamp{Nanos: -1} }, "updated_at"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := sample.NewLaptop()
+			tc.change(laptop)
+
+			violations := validation.Laptop(laptop)
+			require.Len(t, violations, 1, violations.Error())
+			require.Equal(t, tc.path, violations[0].Path)
+			require.NotEmpty(t, violations[0].Description)
+		})
+	}
+}
+
+func TestLaptopReportsEveryViolation(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Brand = ""
+	laptop.Cpu.CpuCores, laptop.Cpu.CpuThreads = 8, 4
+	laptop.Ram.Unit = pb.Memory_UNKNOWN
+	laptop.PriceUsd = -1
+
+	violations := validation.Laptop(laptop)
+	paths := make([]string, len(violations))
+	for i, violation := range violations {
+		paths[i] = violation.Path
+	}
+	require.Equal(t, []string{"brand", "cpu.cpu_threads", "ram.unit", "price_usd"}, paths)
+	require.Contains(t, violations.Error(), "ram.unit: must be set to one of BIT, BYTE, KILOBYTE, MEGABYTE, GIGABYTE, TERABYTE")
+
+	violations = validation.Laptop(nil)
+	require.Equal(t, validation.Violations{{Path: "", Description: "is required"}}, violations)
+}
+
+func TestPath(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "laptop.cpu.min_ghz", validation.Path("laptop", "cpu.min_ghz"))
+	require.Equal(t, "laptop", validation.Path("laptop", ""))
+	require.Equal(t, "cpu", validation.Path("", "cpu"))
+	require.Equal(t, "laptops[2]", validation.Path("laptops", "[2]"))
+}